@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CrawlLimits stop the crawler wandering too far from its seed URLs, for
+// example into a calendar or an endlessly paginated finder. A nil
+// *CrawlLimits doesn't limit anything.
+type CrawlLimits struct {
+	// MaxDepth is the most links a URL can be from a seed URL for it to be
+	// queued, or zero for no limit.
+	MaxDepth int
+	// PageBudgets is the most URLs queued under each path prefix before
+	// the counts expire. Where more than one prefix matches a path, the
+	// longest one is used.
+	PageBudgets map[string]int
+}
+
+// ExceedsDepth reports whether URLs depth links from a seed URL are too
+// deep to be queued.
+func (l *CrawlLimits) ExceedsDepth(depth int) bool {
+	return l != nil && l.MaxDepth > 0 && depth > l.MaxDepth
+}
+
+// PageBudget returns the budget which applies to path and the prefix it was
+// set for, or zero if there isn't one.
+func (l *CrawlLimits) PageBudget(path string) (prefix string, budget int) {
+	if l == nil {
+		return "", 0
+	}
+
+	prefixes := make([]string, 0, len(l.PageBudgets))
+	for prefix := range l.PageBudgets {
+		prefixes = append(prefixes, prefix)
+	}
+
+	if prefix, ok := longestMatchingPrefix(prefixes, path); ok {
+		return prefix, l.PageBudgets[prefix]
+	}
+
+	return "", 0
+}
+
+// ParsePageBudgets parses a comma separated list of page budgets in the form
+// `prefix=pages`, for example:
+//
+//	/government/statistics=5000,/search=100
+func ParsePageBudgets(budgets string) (map[string]int, error) {
+	pageBudgets := make(map[string]int)
+
+	for _, entry := range strings.Split(budgets, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		prefixAndBudget := strings.SplitN(entry, "=", 2)
+		if len(prefixAndBudget) != 2 || !strings.HasPrefix(prefixAndBudget[0], "/") {
+			return nil, fmt.Errorf("Invalid page budget (expected /prefix=pages): %s", entry)
+		}
+
+		budget, err := strconv.Atoi(strings.TrimSpace(prefixAndBudget[1]))
+		if err != nil || budget < 1 {
+			return nil, fmt.Errorf("Invalid page budget for %s: %s", prefixAndBudget[0], prefixAndBudget[1])
+		}
+
+		pageBudgets[prefixAndBudget[0]] = budget
+	}
+
+	return pageBudgets, nil
+}
+
+// longestMatchingPrefix returns the longest of prefixes that path starts
+// with.
+func longestMatchingPrefix(prefixes []string, path string) (string, bool) {
+	sorted := make([]string, len(prefixes))
+	copy(sorted, prefixes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+
+	for _, prefix := range sorted {
+		if strings.HasPrefix(path, prefix) {
+			return prefix, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,66 @@
+package main_test
+
+import (
+	. "github.com/alphagov/govuk_crawler_worker"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CrawlLimits", func() {
+	It("doesn't limit anything when it's nil", func() {
+		var limits *CrawlLimits
+
+		Expect(limits.ExceedsDepth(1000)).To(BeFalse())
+		Expect(limits.PageBudget("/search")).To(BeZero())
+	})
+
+	It("doesn't limit depth when the maximum is zero", func() {
+		Expect((&CrawlLimits{}).ExceedsDepth(1000)).To(BeFalse())
+	})
+
+	It("limits depth to the maximum", func() {
+		limits := &CrawlLimits{MaxDepth: 3}
+
+		Expect(limits.ExceedsDepth(3)).To(BeFalse())
+		Expect(limits.ExceedsDepth(4)).To(BeTrue())
+	})
+
+	It("uses the page budget of the longest matching prefix", func() {
+		limits := &CrawlLimits{PageBudgets: map[string]int{
+			"/government":            100,
+			"/government/statistics": 10,
+		}}
+
+		prefix, budget := limits.PageBudget("/government/statistics/foo")
+		Expect(prefix).To(Equal("/government/statistics"))
+		Expect(budget).To(Equal(10))
+
+		prefix, budget = limits.PageBudget("/government/news")
+		Expect(prefix).To(Equal("/government"))
+		Expect(budget).To(Equal(100))
+
+		_, budget = limits.PageBudget("/search")
+		Expect(budget).To(BeZero())
+	})
+
+	Describe("ParsePageBudgets", func() {
+		It("parses budgets", func() {
+			Expect(ParsePageBudgets(" /government/statistics=5000, /search=100,")).To(Equal(map[string]int{
+				"/government/statistics": 5000,
+				"/search":                100,
+			}))
+		})
+
+		It("returns an error for invalid budgets", func() {
+			_, err := ParsePageBudgets("/search")
+			Expect(err).To(MatchError("Invalid page budget (expected /prefix=pages): /search"))
+
+			_, err = ParsePageBudgets("search=100")
+			Expect(err).ToNot(BeNil())
+
+			_, err = ParsePageBudgets("/search=0")
+			Expect(err).To(MatchError("Invalid page budget for /search: 0"))
+		})
+	})
+})
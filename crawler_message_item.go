@@ -10,34 +10,78 @@ import (
 
 	"github.com/PuerkitoBio/goquery"
 	log "github.com/Sirupsen/logrus"
+	"github.com/alphagov/govuk_crawler_worker/file_paths"
 	"github.com/alphagov/govuk_crawler_worker/http_crawler"
+	"github.com/alphagov/govuk_crawler_worker/queue"
+	"github.com/alphagov/govuk_crawler_worker/url_normaliser"
+	"github.com/alphagov/govuk_crawler_worker/url_rules"
 	"github.com/streadway/amqp"
 )
 
 type CrawlerMessageItem struct {
 	amqp.Delivery
+	Message  *queue.Message
 	Response *http_crawler.CrawlerResponse
 
-	rootURLs       []*url.URL
-	blacklistPaths []string
+	// SkipRels are the link types, such as "nofollow" or "external",
+	// of links which shouldn't be extracted.
+	SkipRels []string
+	// Normaliser rewrites extracted URLs into their canonical form.
+	Normaliser *url_normaliser.Normaliser
+
+	rootURLs  []*url.URL
+	urlRules  *url_rules.Rules
+	decodeErr error
 }
 
-func NewCrawlerMessageItem(delivery amqp.Delivery, rootURLs []*url.URL, blacklistPaths []string) *CrawlerMessageItem {
+// NewCrawlerMessageItem returns an item for the Message in delivery. If it
+// can't be decoded the body is taken to be the URL, and DecodeError
+// returns why.
+func NewCrawlerMessageItem(delivery amqp.Delivery, rootURLs []*url.URL, urlRules *url_rules.Rules) *CrawlerMessageItem {
+	message, err := queue.DecodeMessage(delivery)
+	if err != nil {
+		message = &queue.Message{URL: string(delivery.Body)}
+	}
+
 	return &CrawlerMessageItem{
-		Delivery:       delivery,
-		rootURLs:       rootURLs,
-		blacklistPaths: blacklistPaths,
+		Delivery:  delivery,
+		Message:   message,
+		rootURLs:  rootURLs,
+		urlRules:  urlRules,
+		decodeErr: err,
 	}
 }
 
+// DecodeError returns the error decoding the item's Message, if there was
+// one.
+func (c *CrawlerMessageItem) DecodeError() error {
+	return c.decodeErr
+}
+
 func (c *CrawlerMessageItem) URL() string {
-	return string(c.Body)
+	return c.Message.URL
+}
+
+// Depth returns the number of links between a seed URL and the item's
+// URL.
+func (c *CrawlerMessageItem) Depth() int {
+	return c.Message.Depth
+}
+
+// SeedID identifies the seed URL the item was found from, which for a seed
+// is its own URL.
+func (c *CrawlerMessageItem) SeedID() string {
+	if c.Message.SeedID != "" {
+		return c.Message.SeedID
+	}
+
+	return c.URL()
 }
 
-func (c *CrawlerMessageItem) hasParams() bool {
+func (c *CrawlerMessageItem) hasDisallowedParams(queryParams *QueryParamAllowList) bool {
 	urlParts, err := url.Parse(c.URL())
 
-	return (err != nil || urlParts.RawQuery != "")
+	return (err != nil || !queryParams.Allows(urlParts))
 }
 
 func (c *CrawlerMessageItem) RelativeFilePath() (string, error) {
@@ -76,55 +120,80 @@ func (c *CrawlerMessageItem) RelativeFilePath() (string, error) {
 	}
 
 	filePath = path.Clean(filePath)
+
+	// Pages with a query are stored alongside the page without one, with
+	// the parameters in a fixed order so each URL has a single file.
+	if urlParts.RawQuery != "" {
+		values, err := url.ParseQuery(urlParts.RawQuery)
+		if err != nil {
+			return "", err
+		}
+
+		extension := path.Ext(filePath)
+		filePath = strings.TrimSuffix(filePath, extension) + "@" + values.Encode() + extension
+	}
+
 	filePath = filepath.Join(host, filePath)
 	filePath = strings.TrimPrefix(filePath, "/")
 
 	return filePath, nil
 }
 
-func (c *CrawlerMessageItem) ExtractURLs() ([]*url.URL, error) {
-	extractedURLs := []*url.URL{}
+// FilePath returns the path of the item's file in the mirror, as given
+// by mapper or by RelativeFilePath if mapper is nil.
+func (c *CrawlerMessageItem) FilePath(mapper *file_paths.Mapper) (string, error) {
+	if mapper == nil {
+		return c.RelativeFilePath()
+	}
 
-	document, err := goquery.NewDocumentFromReader(bytes.NewBuffer(c.Response.Body))
+	urlParts, err := url.Parse(c.URL())
 	if err != nil {
-		return extractedURLs, err
+		return "", err
 	}
 
-	urlElementMatches := [][]string{
-		{"a", "href"},
-		{"img", "src"},
-		{"link", "href"},
-		{"script", "src"},
+	contentType, err := c.Response.ParseContentType()
+	if err != nil {
+		return "", err
 	}
 
-	var hrefs []string
-	var urls []*url.URL
+	return mapper.FilePath(urlParts, contentType == http_crawler.HTML)
+}
 
-	for _, attr := range urlElementMatches {
-		element, attr := attr[0], attr[1]
+func (c *CrawlerMessageItem) ExtractURLs() ([]*url.URL, error) {
+	extractedURLs := []*url.URL{}
+
+	body, err := c.Response.UTF8Body()
+	if err != nil {
+		log.Warningln("Couldn't convert body to UTF-8, extracting URLs from it as it is:", c.URL(), err)
+		body = c.Response.Body
+	}
 
-		hrefs = findHrefsByElementAttribute(document, element, attr)
-		urls, err = parseURLs(hrefs)
+	var hrefs []string
+	baseURL := c.Response.URL
 
+	if c.Response.IsCSS() {
+		hrefs = extractCSSURLs(string(body))
+	} else {
+		document, err := goquery.NewDocumentFromReader(bytes.NewBuffer(body))
 		if err != nil {
 			return extractedURLs, err
 		}
 
-		baseURL := &url.URL{
-			Scheme: c.Response.URL.Scheme,
-			Host:   c.Response.URL.Host,
-		}
-		urls = convertURLsToAbsolute(baseURL, urls)
-		urls = filterURLsByHost(c.rootURLs, urls)
-		urls = filterBlacklistedURLs(c.blacklistPaths, urls)
-		urls = removeFragmentFromURLs(urls)
-
-		extractedURLs = append(extractedURLs, urls...)
+		baseURL = documentBaseURL(document, baseURL)
+		hrefs = extractHTMLURLs(document, c.SkipRels)
 	}
 
+	urls := parseURLs(c.URL(), hrefs)
+	urls = convertURLsToAbsolute(baseURL, urls)
+	urls = normaliseURLs(c.Normaliser, urls)
+	urls = filterURLsByHost(c.rootURLs, urls)
+	urls = filterBlacklistedURLs(c.urlRules, urls)
+	urls = removeFragmentFromURLs(urls)
+
+	extractedURLs = append(extractedURLs, urls...)
 	extractedURLs = filterDuplicateURLs(extractedURLs)
 
-	return extractedURLs, err
+	return extractedURLs, nil
 }
 
 func (c *CrawlerMessageItem) IsBlacklisted() bool {
@@ -133,22 +202,24 @@ func (c *CrawlerMessageItem) IsBlacklisted() bool {
 		log.Warningln("Malformed URL", c.URL())
 		return false
 	}
-	return isBlacklistedPath(urlParts.Path, c.blacklistPaths)
+	return c.urlRules.Excludes(urlParts)
 }
 
-func parseURLs(urls []string) ([]*url.URL, error) {
+// parseURLs parses the URLs extracted from pageURL, skipping any which
+// are malformed so that one bad link doesn't lose the rest of the page's.
+func parseURLs(pageURL string, urls []string) []*url.URL {
 	var parsedURLs []*url.URL
-	var err error
 
 	for _, u := range urls {
-		u, err := url.Parse(u)
+		parsedURL, err := url.Parse(u)
 		if err != nil {
-			return parsedURLs, err
+			log.Warningln("Skipping malformed URL found on", pageURL, err)
+			continue
 		}
-		parsedURLs = append(parsedURLs, u)
+		parsedURLs = append(parsedURLs, parsedURL)
 	}
 
-	return parsedURLs, err
+	return parsedURLs
 }
 
 func convertURLsToAbsolute(baseURL *url.URL, urls []*url.URL) []*url.URL {
@@ -157,6 +228,10 @@ func convertURLsToAbsolute(baseURL *url.URL, urls []*url.URL) []*url.URL {
 	})
 }
 
+func normaliseURLs(normaliser *url_normaliser.Normaliser, urls []*url.URL) []*url.URL {
+	return mapURLs(urls, normaliser.Normalise)
+}
+
 func removeFragmentFromURLs(urls []*url.URL) []*url.URL {
 	return mapURLs(urls, func(url *url.URL) *url.URL {
 		url.Fragment = ""
@@ -174,9 +249,9 @@ func filterURLsByHost(allowedHosts []*url.URL, urls []*url.URL) (ret []*url.URL)
 	return ret
 }
 
-func filterBlacklistedURLs(blacklistedPaths []string, urls []*url.URL) []*url.URL {
+func filterBlacklistedURLs(urlRules *url_rules.Rules, urls []*url.URL) []*url.URL {
 	return filterURLs(urls, func(url *url.URL) bool {
-		return !isBlacklistedPath(url.Path, blacklistedPaths)
+		return !urlRules.Excludes(url)
 	})
 }
 
@@ -235,13 +310,3 @@ func findHrefsByElementAttribute(
 
 	return hrefs
 }
-
-func isBlacklistedPath(path string, blacklistedPaths []string) bool {
-	for _, blacklistedPath := range blacklistedPaths {
-		if strings.HasPrefix(path, blacklistedPath) {
-			return true
-		}
-	}
-
-	return false
-}
@@ -3,9 +3,14 @@ package main_test
 import (
 	"io/ioutil"
 	"net/url"
+	"strings"
 
 	. "github.com/alphagov/govuk_crawler_worker"
+	"github.com/alphagov/govuk_crawler_worker/file_paths"
 	. "github.com/alphagov/govuk_crawler_worker/http_crawler"
+	"github.com/alphagov/govuk_crawler_worker/queue"
+	"github.com/alphagov/govuk_crawler_worker/url_normaliser"
+	"github.com/alphagov/govuk_crawler_worker/url_rules"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -40,7 +45,7 @@ var _ = Describe("CrawlerMessageItem", func() {
 		}
 
 		delivery = amqp.Delivery{Body: []byte(testURL.String())}
-		item = NewCrawlerMessageItem(delivery, rootURLs, []string{})
+		item = NewCrawlerMessageItem(delivery, rootURLs, nil)
 
 		urlA = &url.URL{
 			Scheme: "https",
@@ -76,7 +81,7 @@ var _ = Describe("CrawlerMessageItem", func() {
 	})
 
 	It("generates a CrawlerMessageItem object", func() {
-		Expect(NewCrawlerMessageItem(delivery, rootURLs, []string{})).ToNot(BeNil())
+		Expect(NewCrawlerMessageItem(delivery, rootURLs, nil)).ToNot(BeNil())
 	})
 
 	It("can get the Response.Request.URL of the crawled URL", func() {
@@ -89,7 +94,7 @@ var _ = Describe("CrawlerMessageItem", func() {
 		})
 
 		It("can set the Response.Body of the crawled URL", func() {
-			item := NewCrawlerMessageItem(delivery, rootURLs, []string{})
+			item := NewCrawlerMessageItem(delivery, rootURLs, nil)
 			item.Response = &CrawlerResponse{Body: []byte("foo")}
 
 			Expect(item.Response.Body).To(Equal([]byte("foo")))
@@ -98,10 +103,51 @@ var _ = Describe("CrawlerMessageItem", func() {
 
 	It("detects when a URL is blacklisted", func() {
 		delivery = amqp.Delivery{Body: []byte("https://www.example.com/blacklisted")}
-		item := NewCrawlerMessageItem(delivery, rootURLs, []string{"/blacklisted"})
+		item := NewCrawlerMessageItem(delivery, rootURLs, url_rules.ExcludePrefixes([]string{"/blacklisted"}))
 		Expect(item.IsBlacklisted()).To(BeTrue())
 	})
 
+	It("lets URL rules include URLs under blacklisted paths", func() {
+		rules, err := url_rules.Parse(strings.NewReader("include host=www.gov.uk glob=/government/uploads/**.pdf"))
+		Expect(err).To(BeNil())
+		rules = url_rules.Combine(rules, url_rules.ExcludePrefixes([]string{"/government/uploads"}))
+
+		delivery = amqp.Delivery{Body: []byte("https://www.gov.uk/government/uploads/file/1/report.pdf")}
+		Expect(NewCrawlerMessageItem(delivery, rootURLs, rules).IsBlacklisted()).To(BeFalse())
+
+		delivery = amqp.Delivery{Body: []byte("https://www.gov.uk/government/uploads/file/1/report.odt")}
+		Expect(NewCrawlerMessageItem(delivery, rootURLs, rules).IsBlacklisted()).To(BeTrue())
+
+		delivery = amqp.Delivery{Body: []byte("https://assets.digital.cabinet-office.gov.uk/government/uploads/report.pdf")}
+		Expect(NewCrawlerMessageItem(delivery, rootURLs, rules).IsBlacklisted()).To(BeTrue())
+	})
+
+	It("reads JSON messages", func() {
+		delivery = amqp.Delivery{
+			ContentType: queue.JSONContentType,
+			Body:        []byte(`{"version": 1, "url": "https://www.gov.uk/foo", "depth": 3, "seed_id": "https://www.gov.uk/"}`),
+		}
+		item := NewCrawlerMessageItem(delivery, rootURLs, nil)
+
+		Expect(item.DecodeError()).To(BeNil())
+		Expect(item.URL()).To(Equal("https://www.gov.uk/foo"))
+		Expect(item.Depth()).To(Equal(3))
+		Expect(item.SeedID()).To(Equal("https://www.gov.uk/"))
+	})
+
+	It("treats plain text messages as seeds at depth 0", func() {
+		Expect(item.DecodeError()).To(BeNil())
+		Expect(item.Depth()).To(Equal(0))
+		Expect(item.SeedID()).To(Equal(testURL.String()))
+	})
+
+	It("returns the error decoding a message it can't read", func() {
+		delivery = amqp.Delivery{ContentType: queue.JSONContentType, Body: []byte(`{"version": 1}`)}
+		item := NewCrawlerMessageItem(delivery, rootURLs, nil)
+
+		Expect(item.DecodeError()).To(Equal(queue.ErrMessageMissingURL))
+	})
+
 	It("returns its URL", func() {
 		Expect(item.URL()).To(Equal(testURL.String()))
 	})
@@ -117,7 +163,7 @@ var _ = Describe("CrawlerMessageItem", func() {
 
 			delivery = amqp.Delivery{Body: []byte(testURL.String())}
 
-			item = NewCrawlerMessageItem(delivery, rootURLs, []string{})
+			item = NewCrawlerMessageItem(delivery, rootURLs, nil)
 			item.Response = &CrawlerResponse{
 				Body:        []byte("foo"),
 				ContentType: HTML,
@@ -130,7 +176,7 @@ var _ = Describe("CrawlerMessageItem", func() {
 			testURL.Path = "/../../one/./two/../three"
 			delivery = amqp.Delivery{Body: []byte(testURL.String())}
 
-			item = NewCrawlerMessageItem(delivery, rootURLs, []string{})
+			item = NewCrawlerMessageItem(delivery, rootURLs, nil)
 			item.Response = &CrawlerResponse{
 				Body:        []byte("foo"),
 				ContentType: HTML,
@@ -143,7 +189,7 @@ var _ = Describe("CrawlerMessageItem", func() {
 			testURL.Path = "/test/UPPER/MiXeD"
 			delivery = amqp.Delivery{Body: []byte(testURL.String())}
 
-			item = NewCrawlerMessageItem(delivery, rootURLs, []string{})
+			item = NewCrawlerMessageItem(delivery, rootURLs, nil)
 			item.Response = &CrawlerResponse{
 				Body:        []byte("foo"),
 				ContentType: HTML,
@@ -156,7 +202,7 @@ var _ = Describe("CrawlerMessageItem", func() {
 			testURL.Path = "/test/!T@e£s$t/U^R*L(){}"
 			delivery = amqp.Delivery{Body: []byte(testURL.String())}
 
-			item = NewCrawlerMessageItem(delivery, rootURLs, []string{})
+			item = NewCrawlerMessageItem(delivery, rootURLs, nil)
 			item.Response = &CrawlerResponse{
 				Body:        []byte("foo"),
 				ContentType: HTML,
@@ -169,7 +215,7 @@ var _ = Describe("CrawlerMessageItem", func() {
 			testURL.Path = "/test/one-two--three---"
 			delivery = amqp.Delivery{Body: []byte(testURL.String())}
 
-			item = NewCrawlerMessageItem(delivery, rootURLs, []string{})
+			item = NewCrawlerMessageItem(delivery, rootURLs, nil)
 			item.Response = &CrawlerResponse{
 				Body:        []byte("foo"),
 				ContentType: HTML,
@@ -182,7 +228,7 @@ var _ = Describe("CrawlerMessageItem", func() {
 			testURL.Path = url.QueryEscape("/test/如何在香港申請英國簽證")
 			delivery = amqp.Delivery{Body: []byte(testURL.String())}
 
-			item = NewCrawlerMessageItem(delivery, rootURLs, []string{})
+			item = NewCrawlerMessageItem(delivery, rootURLs, nil)
 			item.Response = &CrawlerResponse{
 				Body:        []byte("foo"),
 				ContentType: HTML,
@@ -195,7 +241,7 @@ var _ = Describe("CrawlerMessageItem", func() {
 			testURL.Path = "/this/url/has/a/trailing/slash/"
 			delivery = amqp.Delivery{Body: []byte(testURL.String())}
 
-			item = NewCrawlerMessageItem(delivery, rootURLs, []string{})
+			item = NewCrawlerMessageItem(delivery, rootURLs, nil)
 			item.Response = &CrawlerResponse{
 				Body:        []byte("foo"),
 				ContentType: HTML,
@@ -208,7 +254,7 @@ var _ = Describe("CrawlerMessageItem", func() {
 			testURL.Path = "/"
 			delivery = amqp.Delivery{Body: []byte(testURL.String())}
 
-			item = NewCrawlerMessageItem(delivery, rootURLs, []string{})
+			item = NewCrawlerMessageItem(delivery, rootURLs, nil)
 			item.Response = &CrawlerResponse{
 				Body:        []byte("foo"),
 				ContentType: HTML,
@@ -217,22 +263,38 @@ var _ = Describe("CrawlerMessageItem", func() {
 			Expect(item.RelativeFilePath()).To(Equal("www.gov.uk/index.html"))
 		})
 
-		It("omits URL query parameters", func() {
-			delivery := amqp.Delivery{Body: []byte(testURL.String() + "?foo=bar")}
-			item = NewCrawlerMessageItem(delivery, rootURLs, []string{})
+		It("adds URL query parameters to the file name in a fixed order", func() {
+			delivery := amqp.Delivery{Body: []byte(testURL.String() + "?page=2&foo=b%2Far")}
+			item = NewCrawlerMessageItem(delivery, rootURLs, nil)
 
 			item.Response = &CrawlerResponse{
 				Body:        []byte("foo"),
 				ContentType: HTML,
 			}
 
-			Expect(item.RelativeFilePath()).To(Equal("www.gov.uk/government/organisations.html"))
+			Expect(item.RelativeFilePath()).To(Equal("www.gov.uk/government/organisations@foo=b%2Far&page=2.html"))
+		})
+
+		It("adds URL query parameters before the file extension", func() {
+			testURL.Path = "/"
+			delivery := amqp.Delivery{Body: []byte(testURL.String() + "?page=2")}
+			item = NewCrawlerMessageItem(delivery, rootURLs, nil)
+			item.Response = &CrawlerResponse{Body: []byte("foo"), ContentType: HTML}
+
+			Expect(item.RelativeFilePath()).To(Equal("www.gov.uk/index@page=2.html"))
+
+			testURL.Path = "/data.csv"
+			delivery = amqp.Delivery{Body: []byte(testURL.String() + "?year=2015")}
+			item = NewCrawlerMessageItem(delivery, rootURLs, nil)
+			item.Response = &CrawlerResponse{Body: []byte("foo"), ContentType: CSV}
+
+			Expect(item.RelativeFilePath()).To(Equal("www.gov.uk/data@year=2015.csv"))
 		})
 
 		It("omits URL fragments", func() {
 			delivery := amqp.Delivery{Body: []byte(testURL.String() + "#foo")}
 
-			item = NewCrawlerMessageItem(delivery, rootURLs, []string{})
+			item = NewCrawlerMessageItem(delivery, rootURLs, nil)
 			item.Response = &CrawlerResponse{
 				Body:        []byte("foo"),
 				ContentType: HTML,
@@ -245,7 +307,7 @@ var _ = Describe("CrawlerMessageItem", func() {
 			testURL.Path = "/things.atom"
 			delivery = amqp.Delivery{Body: []byte(testURL.String())}
 
-			item = NewCrawlerMessageItem(delivery, rootURLs, []string{})
+			item = NewCrawlerMessageItem(delivery, rootURLs, nil)
 			item.Response = &CrawlerResponse{Body: []byte(""), ContentType: ATOM}
 
 			Expect(item.RelativeFilePath()).To(Equal("www.gov.uk/things.atom"))
@@ -255,13 +317,38 @@ var _ = Describe("CrawlerMessageItem", func() {
 			testURL.Path = "/api.json"
 			delivery = amqp.Delivery{Body: []byte(testURL.String())}
 
-			item = NewCrawlerMessageItem(delivery, rootURLs, []string{})
+			item = NewCrawlerMessageItem(delivery, rootURLs, nil)
 			item.Response = &CrawlerResponse{Body: []byte(""), ContentType: JSON}
 
 			Expect(item.RelativeFilePath()).To(Equal("www.gov.uk/api.json"))
 		})
 	})
 
+	Describe("FilePath", func() {
+		It("uses RelativeFilePath without a mapper", func() {
+			Expect(item.FilePath(nil)).To(Equal("www.gov.uk/government/organisations.html"))
+		})
+
+		It("uses the file path scheme of a mapper", func() {
+			mapper, err := file_paths.New(file_paths.DefaultMaxSegmentLength)
+			Expect(err).To(BeNil())
+
+			testURL.Path = "/government/organisations.html"
+			delivery = amqp.Delivery{Body: []byte(testURL.String() + "?page=2")}
+			item = NewCrawlerMessageItem(delivery, rootURLs, nil)
+			item.Response = &CrawlerResponse{Body: html, ContentType: HTML}
+
+			Expect(item.FilePath(mapper)).To(Equal("www.gov.uk/government/organisations@page=2.html.html"))
+
+			testURL.Path = "/api/content"
+			delivery = amqp.Delivery{Body: []byte(testURL.String())}
+			item = NewCrawlerMessageItem(delivery, rootURLs, nil)
+			item.Response = &CrawlerResponse{Body: []byte("{}"), ContentType: JSON}
+
+			Expect(item.FilePath(mapper)).To(Equal("www.gov.uk/api/content@"))
+		})
+	})
+
 	Describe("ExtractURLs", func() {
 		It("should extract all URLs that match rootURLs in a given HTML document", func() {
 			item.Response.Body = []byte(`
@@ -402,7 +489,7 @@ var _ = Describe("CrawlerMessageItem", func() {
 		})
 
 		It("removes paths that are blacklisted", func() {
-			item := NewCrawlerMessageItem(delivery, rootURLs, []string{"/trade-tariff"})
+			item := NewCrawlerMessageItem(delivery, rootURLs, url_rules.ExcludePrefixes([]string{"/trade-tariff"}))
 			item.Response = &CrawlerResponse{
 				Body:        []byte(`<div><a href="/foo/bar">a</a><a href="/trade-tariff">b</a></div>`),
 				ContentType: HTML,
@@ -415,6 +502,206 @@ var _ = Describe("CrawlerMessageItem", func() {
 			Expect(len(urls)).To(Equal(1))
 		})
 
+		It("extracts URLs from media, frames and objects", func() {
+			item.Response.Body = []byte(`
+<video src="/movie.mp4" poster="/poster.jpg"><track src="/captions.vtt"></video>
+<audio><source src="/sound.ogg"></audio>
+<iframe src="/embedded"></iframe>
+<object data="/document.pdf"></object>`)
+			urls, err := item.ExtractURLs()
+
+			Expect(err).To(BeNil())
+			Expect(urlStrings(urls)).To(ConsistOf(
+				"https://www.gov.uk/movie.mp4",
+				"https://www.gov.uk/poster.jpg",
+				"https://www.gov.uk/captions.vtt",
+				"https://www.gov.uk/sound.ogg",
+				"https://www.gov.uk/embedded",
+				"https://www.gov.uk/document.pdf",
+			))
+		})
+
+		It("extracts every candidate of responsive images", func() {
+			item.Response.Body = []byte(`
+<picture>
+  <source srcset="/wide.webp 1200w,/wide,small.webp 600w">
+  <img src="/fallback.png" srcset="/image.png, /image-2x.png 2x">
+</picture>`)
+			urls, err := item.ExtractURLs()
+
+			Expect(err).To(BeNil())
+			Expect(urlStrings(urls)).To(ConsistOf(
+				"https://www.gov.uk/wide.webp",
+				"https://www.gov.uk/wide,small.webp",
+				"https://www.gov.uk/fallback.png",
+				"https://www.gov.uk/image.png",
+				"https://www.gov.uk/image-2x.png",
+			))
+		})
+
+		It("extracts the target of a meta refresh", func() {
+			item.Response.Body = []byte(`<head><meta http-equiv="Refresh" content="5; URL='/moved'"></head>`)
+			urls, err := item.ExtractURLs()
+
+			Expect(err).To(BeNil())
+			Expect(urlStrings(urls)).To(ConsistOf("https://www.gov.uk/moved"))
+		})
+
+		It("ignores a meta refresh which reloads the page", func() {
+			item.Response.Body = []byte(`<head><meta http-equiv="refresh" content="30"></head>`)
+			urls, err := item.ExtractURLs()
+
+			Expect(err).To(BeNil())
+			Expect(urls).To(BeEmpty())
+		})
+
+		It("extracts url() references from style elements and attributes", func() {
+			item.Response.Body = []byte(`
+<style>
+  @import "/print.css";
+  /* body { background: url(/commented-out.png) } */
+  .logo { background-image: url( "/logo.png" ) }
+</style>
+<div style="background: url(/banner.jpg) no-repeat"></div>
+<div style="background: url(data:image/gif;base64,R0lGODlh)"></div>`)
+			urls, err := item.ExtractURLs()
+
+			Expect(err).To(BeNil())
+			Expect(urlStrings(urls)).To(ConsistOf(
+				"https://www.gov.uk/print.css",
+				"https://www.gov.uk/logo.png",
+				"https://www.gov.uk/banner.jpg",
+			))
+		})
+
+		It("skips malformed url() and srcset candidates but keeps the page's other URLs", func() {
+			item.Response.Body = []byte(`
+<div style="background: url(http://[::1%zz]/bad.png)"></div>
+<img src="/good.png" srcset="http://%zz/bad-2x.png 2x, /good-2x.png 2x">
+<a href="/page">Page</a>`)
+			urls, err := item.ExtractURLs()
+
+			Expect(err).To(BeNil())
+			Expect(urlStrings(urls)).To(ConsistOf(
+				"https://www.gov.uk/good.png",
+				"https://www.gov.uk/good-2x.png",
+				"https://www.gov.uk/page",
+			))
+		})
+
+		It("extracts url() and @import references relative to a stylesheet", func() {
+			item.Response = &CrawlerResponse{
+				Body: []byte(`@import url("base.css");
+@import 'https://www.gov.uk/fonts.css' screen;
+h1 { background: url('../images/heading.png') }
+li { list-style-image: url(/bullet.svg) }`),
+				ContentType: CSS,
+				URL: &url.URL{
+					Scheme: "https",
+					Host:   "www.gov.uk",
+					Path:   "/assets/stylesheets/application.css",
+				},
+			}
+
+			urls, err := item.ExtractURLs()
+
+			Expect(err).To(BeNil())
+			Expect(urlStrings(urls)).To(ConsistOf(
+				"https://www.gov.uk/assets/stylesheets/base.css",
+				"https://www.gov.uk/fonts.css",
+				"https://www.gov.uk/assets/images/heading.png",
+				"https://www.gov.uk/bullet.svg",
+			))
+		})
+
+		It("resolves relative URLs against the URL of the page", func() {
+			item.Response.URL = &url.URL{
+				Scheme: "https",
+				Host:   "www.gov.uk",
+				Path:   "/government/organisations/hm-treasury",
+			}
+			item.Response.Body = []byte(`<a href="../publications">a</a><a href="about">b</a><a href="?page=2">c</a>`)
+
+			urls, err := item.ExtractURLs()
+
+			Expect(err).To(BeNil())
+			Expect(urlStrings(urls)).To(ConsistOf(
+				"https://www.gov.uk/government/publications",
+				"https://www.gov.uk/government/organisations/about",
+				"https://www.gov.uk/government/organisations/hm-treasury?page=2",
+			))
+		})
+
+		It("resolves relative URLs against the <base href> of the page", func() {
+			item.Response.URL = &url.URL{
+				Scheme: "https",
+				Host:   "www.gov.uk",
+				Path:   "/government/organisations/hm-treasury",
+			}
+			item.Response.Body = []byte(`<head><base href="/guidance/"></head>
+<body><a href="tax">a</a><img src="../logo.png"><a href="https://example.com/absolute">b</a></body>`)
+
+			urls, err := item.ExtractURLs()
+
+			Expect(err).To(BeNil())
+			Expect(urlStrings(urls)).To(ConsistOf(
+				"https://www.gov.uk/guidance/tax",
+				"https://www.gov.uk/logo.png",
+				"https://example.com/absolute",
+			))
+		})
+
+		It("only uses the first <base href>", func() {
+			item.Response.Body = []byte(`<base target="_blank"><base href="https://www.gov.uk/first/"><base href="/second/"><a href="page">a</a>`)
+
+			urls, err := item.ExtractURLs()
+
+			Expect(err).To(BeNil())
+			Expect(urlStrings(urls)).To(ConsistOf("https://www.gov.uk/first/page"))
+		})
+
+		It("skips links with a rel listed in SkipRels", func() {
+			item.Response.Body = []byte(`
+<a href="/followed">a</a>
+<a href="/nofollow" rel="NoFollow">b</a>
+<a href="/external" rel="noopener external">c</a>
+<link rel="stylesheet" href="/style.css">`)
+			item.SkipRels = []string{"nofollow", "external"}
+
+			urls, err := item.ExtractURLs()
+
+			Expect(err).To(BeNil())
+			Expect(urlStrings(urls)).To(ConsistOf(
+				"https://www.gov.uk/followed",
+				"https://www.gov.uk/style.css",
+			))
+		})
+
+		It("follows nofollow and external links by default", func() {
+			item.Response.Body = []byte(`<a href="/nofollow" rel="nofollow">a</a><a href="/external" rel="external">b</a>`)
+
+			urls, err := item.ExtractURLs()
+
+			Expect(err).To(BeNil())
+			Expect(urls).To(HaveLen(2))
+		})
+
+		It("normalises extracted URLs", func() {
+			normaliser, err := url_normaliser.New(url_normaliser.DefaultRules, "")
+			Expect(err).To(BeNil())
+
+			item.Normaliser = normaliser
+			item.Response.Body = []byte(`
+<a href="HTTPS://WWW.GOV.UK/foo">a</a>
+<a href="https://www.gov.uk:443/foo">b</a>
+<a href="/bar/../%66oo">c</a>`)
+
+			urls, err := item.ExtractURLs()
+
+			Expect(err).To(BeNil())
+			Expect(urlStrings(urls)).To(ConsistOf("https://www.gov.uk/foo"))
+		})
+
 		It("should only return unique URLs", func() {
 			item.Response.Body = []byte(`<a href="https://www.gov.uk/foo">a</a><a href="https://www.gov.uk/foo">b</a>`)
 			urls, err := item.ExtractURLs()
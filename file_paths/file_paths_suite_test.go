@@ -0,0 +1,13 @@
+package file_paths_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestFilePaths(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "File Paths Suite")
+}
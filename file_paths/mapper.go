@@ -0,0 +1,258 @@
+package file_paths
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// The schemes for naming files which can be configured. ExtensionScheme
+// is the naming done by CrawlerMessageItem.RelativeFilePath.
+const (
+	ExtensionScheme  = "extension"
+	ReversibleScheme = "reversible"
+)
+
+// DefaultMaxSegmentLength leaves room below the 255 byte limit on file
+// names of most filesystems.
+const DefaultMaxSegmentLength = 200
+
+const (
+	htmlExtension = ".html"
+	indexName     = "index"
+	// Hashed segments keep this many hex digits of their SHA-256.
+	digestLength = 16
+	hashMarker   = "@@"
+)
+
+var (
+	ErrHashedPath  = errors.New("File path was shortened with a hash and can't be mapped back to a URL")
+	ErrInvalidPath = errors.New("File path wasn't written by the reversible scheme")
+	ErrNoHost      = errors.New("URL has no host")
+)
+
+// Names reserved by Windows, whatever their extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Mapper gives every URL a file path of its own under the mirror, which
+// can be mapped back to the URL. Paths are made of the host and the
+// segments of the URL path, with these changes:
+//
+// Characters which are unsafe on common filesystems, including an encoded
+// `/`, as well as `$`, `%` and `@` are percent-encoded, as are a leading
+// dot and a trailing dot or space.
+// Directories have every dot encoded, so that a directory such as the
+// one needed by `/file.csv/preview` never has the same name as a file.
+//
+// Files always have a dot or an `@` in their name. HTML pages have
+// `.html` added, as do other files whose names end in `.html`, so that
+// `/page` and `/page.html` have different files. Other files without
+// either get an `@` added. The last segment of a path ending in `/` is
+// named `index`, and a literal `index` is encoded.
+//
+// A query is added before the extension, after an `@`, with its
+// parameters sorted and its dots encoded, as in `page@p=2.html`.
+//
+// Segments longer than the maximum are cut short and end with `@@` and
+// part of their hash instead, keeping their extension. Those can't be
+// mapped back to their URL.
+type Mapper struct {
+	maxSegmentLength int
+}
+
+func New(maxSegmentLength int) (*Mapper, error) {
+	if minimum := len(hashMarker) + digestLength + 16; maxSegmentLength < minimum {
+		return nil, fmt.Errorf("Maximum segment length must be at least %d", minimum)
+	}
+
+	return &Mapper{maxSegmentLength: maxSegmentLength}, nil
+}
+
+// FilePath returns the path of the file for u, relative to the root of
+// the mirror. html is whether the response is an HTML page.
+func (m *Mapper) FilePath(u *url.URL, html bool) (string, error) {
+	host := u.Hostname()
+	if host == "" {
+		return "", ErrNoHost
+	}
+
+	segments := strings.Split(strings.TrimPrefix(u.EscapedPath(), "/"), "/")
+	filePath := []string{host}
+
+	// Empty directory segments are dropped, as web servers merge the
+	// slashes either side of them.
+	for _, segment := range segments[:len(segments)-1] {
+		segment, err := url.PathUnescape(segment)
+		if err != nil {
+			return "", err
+		}
+
+		if segment != "" {
+			filePath = append(filePath, m.shorten(escape(segment, true), false))
+		}
+	}
+
+	last, err := url.PathUnescape(segments[len(segments)-1])
+	if err != nil {
+		return "", err
+	}
+
+	name := indexName
+	if last != "" {
+		name = escape(last, false)
+	}
+
+	if u.RawQuery != "" {
+		values, err := url.ParseQuery(u.RawQuery)
+		if err != nil {
+			return "", err
+		}
+
+		extension := path.Ext(name)
+		query := strings.Replace(values.Encode(), ".", "%2E", -1)
+		name = strings.TrimSuffix(name, extension) + "@" + query + extension
+	}
+
+	switch {
+	case html || strings.HasSuffix(name, htmlExtension):
+		name += htmlExtension
+	case !strings.ContainsAny(name, ".@"):
+		name += "@"
+	}
+
+	filePath = append(filePath, m.shorten(name, true))
+
+	return strings.Join(filePath, "/"), nil
+}
+
+// URL returns the URL whose file is at filePath, without a scheme.
+func (m *Mapper) URL(filePath string) (*url.URL, error) {
+	segments := strings.Split(filePath, "/")
+	if len(segments) < 2 || segments[0] == "" {
+		return nil, ErrInvalidPath
+	}
+
+	for _, segment := range segments[1:] {
+		if strings.Contains(segment, hashMarker) {
+			return nil, ErrHashedPath
+		}
+	}
+
+	last := segments[len(segments)-1]
+	if !strings.ContainsAny(last, ".@") {
+		return nil, ErrInvalidPath
+	}
+
+	escapedPath := []string{""}
+	for _, segment := range segments[1 : len(segments)-1] {
+		if strings.ContainsAny(segment, ".@") {
+			return nil, ErrInvalidPath
+		}
+
+		segment, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, ErrInvalidPath
+		}
+
+		escapedPath = append(escapedPath, url.PathEscape(segment))
+	}
+
+	name := strings.TrimSuffix(last, htmlExtension)
+	query := ""
+
+	if i := strings.Index(name, "@"); i >= 0 {
+		rest := name[i+1:]
+		extension := path.Ext(rest)
+
+		values, err := url.ParseQuery(strings.TrimSuffix(rest, extension))
+		if err != nil {
+			return nil, ErrInvalidPath
+		}
+
+		query = values.Encode()
+		name = name[:i] + extension
+	}
+
+	if name == indexName {
+		name = ""
+	}
+
+	name, err := url.PathUnescape(name)
+	if err != nil {
+		return nil, ErrInvalidPath
+	}
+
+	u, err := url.Parse("//" + segments[0] + strings.Join(append(escapedPath, url.PathEscape(name)), "/"))
+	if err != nil {
+		return nil, ErrInvalidPath
+	}
+	u.RawQuery = query
+
+	return u, nil
+}
+
+// escape percent-encodes the characters of a segment which aren't safe in
+// the names of files, or directories if dir is set.
+func escape(segment string, dir bool) string {
+	var escaped strings.Builder
+
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+
+		switch {
+		case c < 0x20, c == 0x7f, strings.IndexByte(`"$%*/:<>?@\|`, c) >= 0,
+			c == '.' && (dir || i == 0 || i == len(segment)-1),
+			c == ' ' && i == len(segment)-1,
+			i == 0 && (isReserved(segment) || !dir && segment == indexName):
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		default:
+			escaped.WriteByte(c)
+		}
+	}
+
+	return escaped.String()
+}
+
+func isReserved(segment string) bool {
+	return reservedNames[strings.ToUpper(strings.SplitN(segment, ".", 2)[0])]
+}
+
+// shorten cuts a segment longer than the maximum short, ending it with
+// part of its hash and, for a file, its extension.
+func (m *Mapper) shorten(segment string, file bool) string {
+	if len(segment) <= m.maxSegmentLength {
+		return segment
+	}
+
+	extension := ""
+	if file {
+		if extension = path.Ext(segment); len(extension) > 16 {
+			extension = ""
+		}
+	}
+
+	digest := sha256.Sum256([]byte(segment))
+	suffix := hashMarker + hex.EncodeToString(digest[:])[:digestLength] + extension
+	prefix := segment[:m.maxSegmentLength-len(suffix)]
+
+	// Don't leave part of a percent-encoded byte or of a character.
+	if i := strings.LastIndexByte(prefix, '%'); i >= 0 && i >= len(prefix)-2 {
+		prefix = prefix[:i]
+	}
+	for !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+
+	return prefix + suffix
+}
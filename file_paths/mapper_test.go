@@ -0,0 +1,186 @@
+package file_paths_test
+
+import (
+	"net/url"
+	"path"
+	"strings"
+
+	. "github.com/alphagov/govuk_crawler_worker/file_paths"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Mapper", func() {
+	var mapper *Mapper
+
+	BeforeEach(func() {
+		var err error
+		mapper, err = New(DefaultMaxSegmentLength)
+		Expect(err).To(BeNil())
+	})
+
+	filePath := func(rawURL string, html bool) string {
+		u, err := url.Parse(rawURL)
+		Expect(err).To(BeNil())
+
+		filePath, err := mapper.FilePath(u, html)
+		Expect(err).To(BeNil())
+
+		return filePath
+	}
+
+	It("refuses a maximum segment length too short to hash", func() {
+		_, err := New(10)
+		Expect(err).ToNot(BeNil())
+	})
+
+	Describe("FilePath", func() {
+		It("adds .html to pages", func() {
+			Expect(filePath("https://www.gov.uk/bank-holidays", true)).To(Equal("www.gov.uk/bank-holidays.html"))
+			Expect(filePath("https://www.gov.uk/guidance.pdf", true)).To(Equal("www.gov.uk/guidance.pdf.html"))
+			Expect(filePath("https://www.gov.uk/page.html", true)).To(Equal("www.gov.uk/page.html.html"))
+		})
+
+		It("names pages for paths ending in a slash index", func() {
+			Expect(filePath("https://www.gov.uk", true)).To(Equal("www.gov.uk/index.html"))
+			Expect(filePath("https://www.gov.uk/", true)).To(Equal("www.gov.uk/index.html"))
+			Expect(filePath("https://www.gov.uk/government/", true)).To(Equal("www.gov.uk/government/index.html"))
+			Expect(filePath("https://www.gov.uk/government/index", true)).To(Equal("www.gov.uk/government/%69ndex.html"))
+		})
+
+		It("keeps the names of other files", func() {
+			Expect(filePath("https://www.gov.uk/foo.pdf", false)).To(Equal("www.gov.uk/foo.pdf"))
+			Expect(filePath("https://www.gov.uk/feed.atom", false)).To(Equal("www.gov.uk/feed.atom"))
+		})
+
+		It("marks other files without an extension", func() {
+			Expect(filePath("https://www.gov.uk/api/content", false)).To(Equal("www.gov.uk/api/content@"))
+			Expect(filePath("https://www.gov.uk/page.html", false)).To(Equal("www.gov.uk/page.html.html"))
+		})
+
+		It("adds sorted query parameters before the extension", func() {
+			Expect(filePath("https://www.gov.uk/search?q=tax&page=2", true)).To(Equal("www.gov.uk/search@page=2&q=tax.html"))
+			Expect(filePath("https://www.gov.uk/data.csv?year=2015", false)).To(Equal("www.gov.uk/data@year=2015.csv"))
+			Expect(filePath("https://www.gov.uk/api/content?v=1.2", false)).To(Equal("www.gov.uk/api/content@v=1%2E2"))
+			Expect(filePath("https://www.gov.uk/?page=2", true)).To(Equal("www.gov.uk/index@page=2.html"))
+		})
+
+		It("encodes characters which aren't safe in file names", func() {
+			Expect(filePath("https://www.gov.uk/a:b*c%3F%22d%7C", true)).To(Equal("www.gov.uk/a%3Ab%2Ac%3F%22d%7C.html"))
+			Expect(filePath("https://www.gov.uk/50%25@home$", true)).To(Equal("www.gov.uk/50%25%40home%24.html"))
+			Expect(filePath("https://www.gov.uk/.htaccess", false)).To(Equal("www.gov.uk/%2Ehtaccess@"))
+			Expect(filePath("https://www.gov.uk/trailing.", false)).To(Equal("www.gov.uk/trailing%2E@"))
+			Expect(filePath("https://www.gov.uk/con/aux.txt", false)).To(Equal("www.gov.uk/%63on/%61ux.txt"))
+			Expect(filePath("https://www.gov.uk/a%2Fb", true)).To(Equal("www.gov.uk/a%2Fb.html"))
+		})
+
+		It("keeps non-ASCII characters", func() {
+			Expect(filePath("https://www.gov.uk/如何在香港申請英國簽證", true)).To(Equal("www.gov.uk/如何在香港申請英國簽證.html"))
+		})
+
+		It("never gives a file and a directory the same name", func() {
+			Expect(filePath("https://www.gov.uk/file.csv", false)).To(Equal("www.gov.uk/file.csv"))
+			Expect(filePath("https://www.gov.uk/file.csv/preview", true)).To(Equal("www.gov.uk/file%2Ecsv/preview.html"))
+
+			Expect(filePath("https://www.gov.uk/api", false)).To(Equal("www.gov.uk/api@"))
+			Expect(filePath("https://www.gov.uk/api/content", false)).To(Equal("www.gov.uk/api/content@"))
+		})
+
+		It("gives every URL a different file", func() {
+			urls := []string{
+				"/foo", "/foo/", "/foo.html", "/foo/index", "/foo/index.html", "/foo?a=1",
+				"/foo.html?a=1", "/foo/bar", "/foo.html/bar", "/foo%40", "/foo@a=1",
+			}
+
+			files := map[string]string{}
+			for _, u := range urls {
+				for _, html := range []bool{true, false} {
+					file := filePath("https://www.gov.uk"+u, html)
+					dirs := strings.Split(path.Dir(file), "/")
+
+					for _, dir := range dirs[1:] {
+						Expect(dir).ToNot(ContainSubstring("."))
+						Expect(dir).ToNot(ContainSubstring("@"))
+					}
+					Expect(path.Base(file)).To(MatchRegexp(`[.@]`))
+
+					if other, ok := files[file]; ok && other != u {
+						Fail(u + " and " + other + " are both written to " + file)
+					}
+					files[file] = u
+				}
+			}
+		})
+
+		It("shortens long segments with a hash, keeping their extension", func() {
+			long := strings.Repeat("a", 300)
+
+			file := filePath("https://www.gov.uk/"+long+"/"+long+".pdf", false)
+			segments := strings.Split(file, "/")
+
+			Expect(segments).To(HaveLen(3))
+			Expect(segments[1]).To(HaveLen(DefaultMaxSegmentLength))
+			Expect(segments[1]).To(MatchRegexp(`^a+@@[0-9a-f]{16}$`))
+			Expect(segments[2]).To(HaveLen(DefaultMaxSegmentLength))
+			Expect(segments[2]).To(MatchRegexp(`^a+@@[0-9a-f]{16}\.pdf$`))
+
+			Expect(filePath("https://www.gov.uk/"+long+"b", true)).ToNot(Equal(filePath("https://www.gov.uk/"+long+"c", true)))
+		})
+
+		It("doesn't split encoded characters when shortening", func() {
+			file := filePath("https://www.gov.uk/"+strings.Repeat("%3A", 100), true)
+
+			Expect(path.Base(file)).To(MatchRegexp(`^(%3A)+@@[0-9a-f]{16}\.html$`))
+		})
+
+		It("returns an error for URLs without a host", func() {
+			_, err := mapper.FilePath(&url.URL{Path: "/foo"}, true)
+			Expect(err).To(Equal(ErrNoHost))
+		})
+	})
+
+	Describe("URL", func() {
+		It("maps files back to their URLs", func() {
+			urls := []string{
+				"//www.gov.uk/", "//www.gov.uk/bank-holidays", "//www.gov.uk/government/",
+				"//www.gov.uk/government/index", "//www.gov.uk/page.html", "//www.gov.uk/foo.pdf",
+				"//www.gov.uk/api/content", "//www.gov.uk/search?page=2&q=tax",
+				"//www.gov.uk/data.csv?year=2015", "//www.gov.uk/api/content?v=1.2",
+				"//www.gov.uk/a:b*c%3F%22d%7C", "//www.gov.uk/50%25@home$", "//www.gov.uk/.htaccess",
+				"//www.gov.uk/con/aux.txt", "//www.gov.uk/a%2Fb", "//www.gov.uk/file.csv/preview",
+				"//www.gov.uk/如何在香港申請英國簽證",
+			}
+
+			for _, rawURL := range urls {
+				for _, html := range []bool{true, false} {
+					u, err := url.Parse(rawURL)
+					Expect(err).To(BeNil())
+
+					file, err := mapper.FilePath(u, html)
+					Expect(err).To(BeNil())
+
+					mapped, err := mapper.URL(file)
+					Expect(err).To(BeNil())
+					Expect(mapped.Host).To(Equal(u.Host), file)
+					Expect(mapped.Path).To(Equal(u.Path), file)
+					Expect(mapped.RawQuery).To(Equal(u.RawQuery), file)
+				}
+			}
+		})
+
+		It("can't map hashed files back", func() {
+			file := filePath("https://www.gov.uk/"+strings.Repeat("a", 300), true)
+
+			_, err := mapper.URL(file)
+			Expect(err).To(Equal(ErrHashedPath))
+		})
+
+		It("returns an error for files the scheme wouldn't write", func() {
+			for _, file := range []string{"foo.html", "www.gov.uk/foo", "www.gov.uk/foo.bar/baz.html", "/foo.html"} {
+				_, err := mapper.URL(file)
+				Expect(err).To(Equal(ErrInvalidPath), file)
+			}
+		})
+	})
+})
@@ -0,0 +1,82 @@
+package file_paths
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/alphagov/govuk_crawler_worker/util"
+)
+
+// NginxVariable is set by the map WriteNginxMap writes to the file for a
+// request, if NginxLocations can't find it by itself.
+const NginxVariable = "$mirror_file"
+
+// NginxLocations serves the files of a host's mirror written with the
+// reversible scheme. It belongs in the `server` block whose root is the
+// directory of the host, and needs the map written by WriteNginxMap to be
+// included in the `http` block.
+const NginxLocations = `location ~ /$ {
+    try_files ` + NginxVariable + ` ${uri}index.html =404;
+}
+
+location ~ \.html$ {
+    try_files ` + NginxVariable + ` $uri.html =404;
+}
+
+location / {
+    try_files ` + NginxVariable + ` $uri.html $uri =404;
+}
+`
+
+// WriteNginxMap writes an nginx `map` from requests to the files in
+// filePaths, keyed by file path with the URL of each, for every file
+// NginxLocations can't find from the request alone. That includes every
+// file for a URL with a query.
+func WriteNginxMap(w io.Writer, filePaths map[string]*url.URL) error {
+	keys := make([]string, 0, len(filePaths))
+	for filePath := range filePaths {
+		keys = append(keys, filePath)
+	}
+	sort.Strings(keys)
+
+	buffered := bufio.NewWriter(w)
+	fmt.Fprintf(buffered, "map $host$uri$is_args$args %s {\n", NginxVariable)
+	fmt.Fprintf(buffered, "    default \"\";\n")
+
+	for _, filePath := range keys {
+		u := filePaths[filePath]
+		hostPath := "/" + strings.SplitN(filePath, "/", 2)[1]
+
+		if u.RawQuery == "" && util.ContainsString(triedPaths(u.Path), hostPath) {
+			continue
+		}
+
+		request := u.Hostname() + u.Path
+		if u.RawQuery != "" {
+			request += "?" + u.RawQuery
+		}
+
+		fmt.Fprintf(buffered, "    %s %s;\n", util.NginxQuote(request), util.NginxQuote(hostPath))
+	}
+
+	fmt.Fprintf(buffered, "}\n")
+
+	return buffered.Flush()
+}
+
+// triedPaths returns the files NginxLocations tries for a request for
+// urlPath.
+func triedPaths(urlPath string) []string {
+	switch {
+	case urlPath == "" || strings.HasSuffix(urlPath, "/"):
+		return []string{strings.TrimSuffix(urlPath, "/") + "/index.html"}
+	case strings.HasSuffix(urlPath, htmlExtension):
+		return []string{urlPath + htmlExtension}
+	default:
+		return []string{urlPath + htmlExtension, urlPath}
+	}
+}
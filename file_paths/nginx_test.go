@@ -0,0 +1,55 @@
+package file_paths_test
+
+import (
+	"bytes"
+	"net/url"
+
+	. "github.com/alphagov/govuk_crawler_worker/file_paths"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteNginxMap", func() {
+	It("maps requests to the files the locations can't find", func() {
+		mapper, err := New(DefaultMaxSegmentLength)
+		Expect(err).To(BeNil())
+
+		filePaths := map[string]*url.URL{}
+		for _, page := range []string{
+			"https://www.gov.uk/",
+			"https://www.gov.uk/bank-holidays",
+			"https://www.gov.uk/government/",
+			"https://www.gov.uk/page.html",
+			"https://www.gov.uk/search?q=tax",
+			"https://www.gov.uk/file.csv/preview",
+			"https://www.gov.uk/say-\"hello\"",
+		} {
+			u, _ := url.Parse(page)
+			filePath, err := mapper.FilePath(u, true)
+			Expect(err).To(BeNil())
+
+			filePaths[filePath] = u
+		}
+
+		for _, file := range []string{"https://www.gov.uk/foo.pdf", "https://www.gov.uk/api"} {
+			u, _ := url.Parse(file)
+			filePath, err := mapper.FilePath(u, false)
+			Expect(err).To(BeNil())
+
+			filePaths[filePath] = u
+		}
+
+		var config bytes.Buffer
+		Expect(WriteNginxMap(&config, filePaths)).To(BeNil())
+
+		Expect(config.String()).To(Equal(`map $host$uri$is_args$args $mirror_file {
+    default "";
+    "www.gov.uk/api" "/api@";
+    "www.gov.uk/file.csv/preview" "/file%2Ecsv/preview.html";
+    "www.gov.uk/say-\"hello\"" "/say-%22hello%22.html";
+    "www.gov.uk/search?q=tax" "/search@q=tax.html";
+}
+`))
+	})
+})
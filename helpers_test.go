@@ -1,9 +1,13 @@
 package main_test
 
 import (
+	"io"
 	"log"
+	"net/url"
 	"os"
+	"sync"
 
+	"github.com/alphagov/govuk_crawler_worker/storage"
 	"github.com/fzzy/radix/redis"
 )
 
@@ -34,3 +38,35 @@ func PurgeAllKeys(prefix string, address string) error {
 
 	return nil
 }
+
+func urlStrings(urls []*url.URL) []string {
+	strings := make([]string, len(urls))
+	for i, u := range urls {
+		strings[i] = u.String()
+	}
+
+	return strings
+}
+
+// readCountingStore counts the keys read from a store.
+type readCountingStore struct {
+	storage.Store
+
+	mutex sync.Mutex
+	reads []string
+}
+
+func (s *readCountingStore) Get(key string) (io.ReadCloser, error) {
+	s.mutex.Lock()
+	s.reads = append(s.reads, key)
+	s.mutex.Unlock()
+
+	return s.Store.Get(key)
+}
+
+func (s *readCountingStore) Reads() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]string{}, s.reads...)
+}
@@ -0,0 +1,198 @@
+package http_crawler
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Character encodings that bodies can be converted from.
+const (
+	UTF8        = "utf-8"
+	UTF16LE     = "utf-16le"
+	UTF16BE     = "utf-16be"
+	Windows1252 = "windows-1252"
+)
+
+// How far into a page to look for a <meta> charset declaration, as
+// browsers do.
+const charsetPrescanLen = 1024
+
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+
+	metaCharsetRegexp = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([a-z0-9_:.+-]+)`)
+
+	// Labels for the encodings we support, following the WHATWG Encoding
+	// standard in treating ISO-8859-1 and ASCII as Windows-1252.
+	charsetLabels = map[string]string{
+		"unicode-1-1-utf-8": UTF8,
+		"utf-8":             UTF8,
+		"utf8":              UTF8,
+		"utf-16":            UTF16LE,
+		"utf-16le":          UTF16LE,
+		"utf-16be":          UTF16BE,
+		"ansi_x3.4-1968":    Windows1252,
+		"ascii":             Windows1252,
+		"cp1252":            Windows1252,
+		"cp819":             Windows1252,
+		"csisolatin1":       Windows1252,
+		"ibm819":            Windows1252,
+		"iso-8859-1":        Windows1252,
+		"iso-ir-100":        Windows1252,
+		"iso8859-1":         Windows1252,
+		"iso88591":          Windows1252,
+		"iso_8859-1":        Windows1252,
+		"iso_8859-1:1987":   Windows1252,
+		"l1":                Windows1252,
+		"latin1":            Windows1252,
+		"us-ascii":          Windows1252,
+		"windows-1252":      Windows1252,
+		"x-cp1252":          Windows1252,
+	}
+
+	// The characters Windows-1252 has in place of the C1 controls of
+	// ISO-8859-1. Unassigned bytes map to the control characters.
+	windows1252C1 = [32]rune{
+		'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡',
+		'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+		'\u0090', '‘', '’', '“', '”', '•', '–', '—',
+		'˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+	}
+)
+
+// DetectCharset works out the character encoding of an HTML page in the way
+// browsers do: from a byte order mark, then the charset parameter of its
+// Content-Type, then a <meta> declaration. Pages which declare nothing are
+// taken to be UTF-8 if they're valid UTF-8 and Windows-1252 if not. Returns
+// the declared label, lower cased, if it isn't one we can convert from.
+func DetectCharset(contentType string, body []byte) string {
+	switch {
+	case bytes.HasPrefix(body, utf8BOM):
+		return UTF8
+	case bytes.HasPrefix(body, utf16LEBOM):
+		return UTF16LE
+	case bytes.HasPrefix(body, utf16BEBOM):
+		return UTF16BE
+	}
+
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+		return charsetFromLabel(params["charset"])
+	}
+
+	if label := metaCharset(body); label != "" {
+		charset := charsetFromLabel(label)
+
+		// A page that could be read well enough to find the <meta>
+		// can't actually be UTF-16.
+		if charset == UTF16LE || charset == UTF16BE {
+			return UTF8
+		}
+
+		return charset
+	}
+
+	if utf8.Valid(body) {
+		return UTF8
+	}
+
+	return Windows1252
+}
+
+// ToUTF8 converts body from charset to UTF-8, dropping any byte order mark.
+func ToUTF8(body []byte, charset string) ([]byte, error) {
+	switch charset {
+	case UTF8:
+		return bytes.TrimPrefix(body, utf8BOM), nil
+	case UTF16LE:
+		return utf16ToUTF8(bytes.TrimPrefix(body, utf16LEBOM), func(b []byte) uint16 {
+			return uint16(b[0]) | uint16(b[1])<<8
+		}), nil
+	case UTF16BE:
+		return utf16ToUTF8(bytes.TrimPrefix(body, utf16BEBOM), func(b []byte) uint16 {
+			return uint16(b[0])<<8 | uint16(b[1])
+		}), nil
+	case Windows1252:
+		return windows1252ToUTF8(body), nil
+	}
+
+	return nil, fmt.Errorf("Unsupported charset: %s", charset)
+}
+
+// DeclareUTF8 changes the charset in a page's <meta> declaration, if it
+// has one, to UTF-8.
+func DeclareUTF8(body []byte) []byte {
+	match := metaCharsetRegexp.FindSubmatchIndex(prescan(body))
+	if match == nil || strings.EqualFold(string(body[match[2]:match[3]]), UTF8) {
+		return body
+	}
+
+	declared := make([]byte, 0, len(body))
+	declared = append(declared, body[:match[2]]...)
+	declared = append(declared, UTF8...)
+	declared = append(declared, body[match[3]:]...)
+
+	return declared
+}
+
+func charsetFromLabel(label string) string {
+	label = strings.ToLower(strings.TrimSpace(label))
+
+	if charset, ok := charsetLabels[label]; ok {
+		return charset
+	}
+
+	return label
+}
+
+func metaCharset(body []byte) string {
+	match := metaCharsetRegexp.FindSubmatch(prescan(body))
+	if match == nil {
+		return ""
+	}
+
+	return string(match[1])
+}
+
+func prescan(body []byte) []byte {
+	if len(body) > charsetPrescanLen {
+		return body[:charsetPrescanLen]
+	}
+
+	return body
+}
+
+func utf16ToUTF8(body []byte, decode func([]byte) uint16) []byte {
+	units := make([]uint16, 0, len(body)/2)
+	for i := 0; i+1 < len(body); i += 2 {
+		units = append(units, decode(body[i:i+2]))
+	}
+
+	return []byte(string(utf16.Decode(units)))
+}
+
+func windows1252ToUTF8(body []byte) []byte {
+	converted := make([]byte, 0, len(body))
+	buf := make([]byte, utf8.UTFMax)
+
+	for _, b := range body {
+		switch {
+		case b < 0x80:
+			converted = append(converted, b)
+		case b < 0xa0:
+			n := utf8.EncodeRune(buf, windows1252C1[b-0x80])
+			converted = append(converted, buf[:n]...)
+		default:
+			n := utf8.EncodeRune(buf, rune(b))
+			converted = append(converted, buf[:n]...)
+		}
+	}
+
+	return converted
+}
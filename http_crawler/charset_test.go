@@ -0,0 +1,79 @@
+package http_crawler_test
+
+import (
+	. "github.com/alphagov/govuk_crawler_worker/http_crawler"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Charsets", func() {
+	Describe("DetectCharset", func() {
+		It("uses a byte order mark over anything declared", func() {
+			Expect(DetectCharset("text/html; charset=windows-1252", []byte("\xef\xbb\xbf<p>"))).To(Equal(UTF8))
+			Expect(DetectCharset("", []byte("\xff\xfe<\x00"))).To(Equal(UTF16LE))
+			Expect(DetectCharset("", []byte("\xfe\xff\x00<"))).To(Equal(UTF16BE))
+		})
+
+		It("uses the Content-Type charset over a <meta> declaration", func() {
+			body := []byte(`<meta charset="utf-8">`)
+
+			Expect(DetectCharset("text/html; charset=ISO-8859-1", body)).To(Equal(Windows1252))
+		})
+
+		It("uses a <meta> declaration", func() {
+			Expect(DetectCharset(HTML, []byte(`<head><meta charset="windows-1252">`))).To(Equal(Windows1252))
+			Expect(DetectCharset(HTML, []byte(`<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=latin1">`))).To(Equal(Windows1252))
+		})
+
+		It("only looks for a <meta> declaration near the start of the page", func() {
+			body := make([]byte, 2000)
+			for i := range body {
+				body[i] = ' '
+			}
+			body = append(body, `<meta charset="windows-1252">`...)
+
+			Expect(DetectCharset(HTML, body)).To(Equal(UTF8))
+		})
+
+		It("guesses from the body when nothing is declared", func() {
+			Expect(DetectCharset(HTML, []byte("caf\xc3\xa9"))).To(Equal(UTF8))
+			Expect(DetectCharset(HTML, []byte("caf\xe9"))).To(Equal(Windows1252))
+		})
+
+		It("returns unknown charsets as they were declared", func() {
+			Expect(DetectCharset("text/html; charset=Shift_JIS", nil)).To(Equal("shift_jis"))
+		})
+	})
+
+	Describe("ToUTF8", func() {
+		It("converts Windows-1252", func() {
+			Expect(ToUTF8([]byte("\x93caf\xe9\x94 \x80"), Windows1252)).To(Equal([]byte("“café” €")))
+		})
+
+		It("converts UTF-16", func() {
+			Expect(ToUTF8([]byte("\xff\xfec\x00a\x00f\x00\xe9\x00"), UTF16LE)).To(Equal([]byte("café")))
+			Expect(ToUTF8([]byte("\x00c\x00a\x00f\x00\xe9"), UTF16BE)).To(Equal([]byte("café")))
+		})
+
+		It("drops a UTF-8 byte order mark", func() {
+			Expect(ToUTF8([]byte("\xef\xbb\xbfcafé"), UTF8)).To(Equal([]byte("café")))
+		})
+
+		It("returns an error for unsupported charsets", func() {
+			_, err := ToUTF8([]byte("foo"), "shift_jis")
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Describe("DeclareUTF8", func() {
+		It("replaces the charset in a <meta> declaration", func() {
+			Expect(string(DeclareUTF8([]byte(`<meta http-equiv="Content-Type" content="text/html; charset=windows-1252"><p>`)))).
+				To(Equal(`<meta http-equiv="Content-Type" content="text/html; charset=utf-8"><p>`))
+		})
+
+		It("leaves pages without a declaration alone", func() {
+			Expect(string(DeclareUTF8([]byte(`<p>café</p>`)))).To(Equal(`<p>café</p>`))
+		})
+	})
+})
@@ -0,0 +1,136 @@
+package http_crawler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// CrawlerOptions configures a Crawler and the HTTP client it owns. Zero
+// values leave the net/http defaults in place.
+type CrawlerOptions struct {
+	BasicAuth      *BasicAuth
+	RateLimitToken string
+
+	// MaxBodySize is the largest response body, in bytes, that will be
+	// read. Larger responses fail with ErrBodyTooLarge. Zero means there
+	// is no limit.
+	MaxBodySize int64
+	// StreamDir, if set, is a directory that bodies other than HTML are
+	// written to as they're read, instead of being held in memory. See
+	// CrawlerResponse.BodyFile.
+	StreamDir string
+	// ContentTypeSource is where a response's content type is taken from
+	// when it can be found in more than one way: ContentTypeFromHeader
+	// (the default), ContentTypeFromSniffing or ContentTypeFromExtension.
+	// See ResolveContentType.
+	ContentTypeSource string
+	// NormaliseCharset converts HTML pages to UTF-8 as they're crawled,
+	// so that they're mirrored as UTF-8. Otherwise pages are mirrored as
+	// they were sent, and only converted for extracting links.
+	NormaliseCharset bool
+
+	// DialTimeout limits how long establishing a TCP connection may take.
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout limits how long a TLS handshake may take.
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout limits how long to wait for the response
+	// headers once the request has been written.
+	ResponseHeaderTimeout time.Duration
+	// Timeout limits the total time of a request, including reading the
+	// response body.
+	Timeout time.Duration
+	// MaxIdleConnsPerHost is the number of keep-alive connections kept
+	// open to each host.
+	MaxIdleConnsPerHost int
+
+	// ProxyURL routes all requests through a proxy. If unset the usual
+	// HTTP_PROXY/HTTPS_PROXY environment variables are honoured.
+	ProxyURL *url.URL
+	// CABundleFile is a PEM file of certificate authorities to trust
+	// instead of the system roots.
+	CABundleFile string
+	// ClientCertFile and ClientKeyFile are a PEM certificate and key
+	// presented to servers that require TLS client authentication.
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// Transport replaces the transport built from the options above, for
+	// example to stub out the network in tests.
+	Transport http.RoundTripper
+}
+
+func newHTTPClient(options CrawlerOptions) (*http.Client, error) {
+	transport := options.Transport
+
+	if transport == nil {
+		tlsConfig, err := newTLSConfig(options)
+		if err != nil {
+			return nil, err
+		}
+
+		proxy := http.ProxyFromEnvironment
+		if options.ProxyURL != nil {
+			proxy = http.ProxyURL(options.ProxyURL)
+		}
+
+		transport = &http.Transport{
+			Proxy: proxy,
+			DialContext: (&net.Dialer{
+				Timeout:   options.DialTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   options.TLSHandshakeTimeout,
+			ResponseHeaderTimeout: options.ResponseHeaderTimeout,
+			MaxIdleConnsPerHost:   options.MaxIdleConnsPerHost,
+			IdleConnTimeout:       90 * time.Second,
+		}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   options.Timeout,
+		// Redirects are recorded rather than followed, see Crawler.Crawl.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, nil
+}
+
+func newTLSConfig(options CrawlerOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if options.CABundleFile != "" {
+		pem, err := ioutil.ReadFile(options.CABundleFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in CA bundle: %s", options.CABundleFile)
+		}
+	}
+
+	if options.ClientCertFile != "" || options.ClientKeyFile != "" {
+		if options.ClientCertFile == "" || options.ClientKeyFile == "" {
+			return nil, errors.New("Both a client certificate and key are needed for TLS client authentication")
+		}
+
+		cert, err := tls.LoadX509KeyPair(options.ClientCertFile, options.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
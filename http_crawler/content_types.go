@@ -0,0 +1,154 @@
+package http_crawler
+
+import (
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// DefaultContentTypes are the content types crawled when no others are
+// configured.
+var DefaultContentTypes = []string{
+	CSS, CSV, DOCX, GIF, HTML, ICO, ICS, JAVASCRIPT,
+	JPEG, JSON, ODP, ODS, ODT, PDF, PNG, XLS, XLSX,
+}
+
+var defaultContentTypePolicy = NewContentTypePolicy(DefaultContentTypes, nil, nil)
+
+// ContentTypePolicy decides which content types are kept and how large
+// their bodies may be. Patterns are MIME types, which may use a wildcard
+// for the subtype (`image/*`) or for the whole type (`*/*`).
+type ContentTypePolicy struct {
+	allow    []string
+	deny     []string
+	maxSizes map[string]int64
+}
+
+// NewContentTypePolicy returns a policy accepting content types which match
+// a pattern in allow and none in deny. maxSizes limits the body size, in
+// bytes, of content types matching each pattern. Where more than one
+// pattern matches, the most specific one is used.
+func NewContentTypePolicy(allow []string, deny []string, maxSizes map[string]int64) *ContentTypePolicy {
+	policy := &ContentTypePolicy{
+		allow:    normaliseContentTypePatterns(allow),
+		deny:     normaliseContentTypePatterns(deny),
+		maxSizes: make(map[string]int64),
+	}
+
+	for pattern, size := range maxSizes {
+		policy.maxSizes[normaliseContentTypePattern(pattern)] = size
+	}
+
+	return policy
+}
+
+// Accepts reports whether a Content-Type header value is allowed by the
+// policy. Values that can't be parsed are never accepted.
+func (p *ContentTypePolicy) Accepts(contentType string) bool {
+	mimeType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range p.deny {
+		if contentTypeMatches(pattern, mimeType) {
+			return false
+		}
+	}
+
+	for _, pattern := range p.allow {
+		if contentTypeMatches(pattern, mimeType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MaxSize returns the largest body allowed for a Content-Type header value,
+// or zero if there's no limit.
+func (p *ContentTypePolicy) MaxSize(contentType string) int64 {
+	mimeType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return p.maxSizes["*/*"]
+	}
+
+	if size, ok := p.maxSizes[mimeType]; ok {
+		return size
+	}
+
+	if size, ok := p.maxSizes[strings.SplitN(mimeType, "/", 2)[0]+"/*"]; ok {
+		return size
+	}
+
+	return p.maxSizes["*/*"]
+}
+
+// ParseContentTypeList parses a comma separated list of content type
+// patterns.
+func ParseContentTypeList(list string) []string {
+	patterns := []string{}
+
+	for _, pattern := range strings.Split(list, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return patterns
+}
+
+// ParseContentTypeSizes parses a comma separated list of maximum body sizes
+// in the form `pattern=bytes`, for example:
+//
+//	application/pdf=104857600,image/*=10485760
+func ParseContentTypeSizes(sizes string) (map[string]int64, error) {
+	maxSizes := make(map[string]int64)
+
+	for _, entry := range ParseContentTypeList(sizes) {
+		patternAndSize := strings.SplitN(entry, "=", 2)
+		if len(patternAndSize) != 2 {
+			return nil, fmt.Errorf("Invalid content type size (expected pattern=bytes): %s", entry)
+		}
+
+		size, err := strconv.ParseInt(strings.TrimSpace(patternAndSize[1]), 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("Invalid maximum size for content type %s: %s", patternAndSize[0], patternAndSize[1])
+		}
+
+		maxSizes[strings.TrimSpace(patternAndSize[0])] = size
+	}
+
+	return maxSizes, nil
+}
+
+func normaliseContentTypePatterns(patterns []string) []string {
+	normalised := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		normalised[i] = normaliseContentTypePattern(pattern)
+	}
+
+	return normalised
+}
+
+// Patterns are compared in lower case, and a bare `*` is short for `*/*`.
+func normaliseContentTypePattern(pattern string) string {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if pattern == "*" {
+		return "*/*"
+	}
+
+	return pattern
+}
+
+func contentTypeMatches(pattern string, mimeType string) bool {
+	switch {
+	case pattern == "*/*":
+		return true
+	case strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(mimeType, strings.TrimSuffix(pattern, "*"))
+	}
+
+	return pattern == mimeType
+}
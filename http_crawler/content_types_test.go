@@ -0,0 +1,91 @@
+package http_crawler_test
+
+import (
+	. "github.com/alphagov/govuk_crawler_worker/http_crawler"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ContentTypePolicy", func() {
+	Describe("Accepts", func() {
+		It("accepts the default content types", func() {
+			policy := NewContentTypePolicy(DefaultContentTypes, nil, nil)
+
+			Expect(policy.Accepts("text/html; charset=utf-8")).To(BeTrue())
+			Expect(policy.Accepts(PDF)).To(BeTrue())
+			Expect(policy.Accepts(ATOM)).To(BeFalse())
+			Expect(policy.Accepts("image/svg+xml")).To(BeFalse())
+		})
+
+		It("accepts content types matching a wildcard", func() {
+			policy := NewContentTypePolicy([]string{"image/*", "Text/Plain"}, nil, nil)
+
+			Expect(policy.Accepts("image/svg+xml")).To(BeTrue())
+			Expect(policy.Accepts("IMAGE/PNG")).To(BeTrue())
+			Expect(policy.Accepts("text/plain")).To(BeTrue())
+			Expect(policy.Accepts(HTML)).To(BeFalse())
+		})
+
+		It("rejects content types matching the deny list, even if they're allowed", func() {
+			policy := NewContentTypePolicy([]string{"*"}, []string{"video/*", ATOM}, nil)
+
+			Expect(policy.Accepts(HTML)).To(BeTrue())
+			Expect(policy.Accepts("video/mp4")).To(BeFalse())
+			Expect(policy.Accepts(ATOM)).To(BeFalse())
+		})
+
+		It("rejects content types that can't be parsed", func() {
+			policy := NewContentTypePolicy([]string{"*/*"}, nil, nil)
+
+			Expect(policy.Accepts("")).To(BeFalse())
+			Expect(policy.Accepts("text/html; charset")).To(BeFalse())
+		})
+	})
+
+	Describe("MaxSize", func() {
+		It("uses the most specific matching pattern", func() {
+			policy := NewContentTypePolicy(DefaultContentTypes, nil, map[string]int64{
+				"*":         100,
+				"image/*":   10,
+				"image/png": 5,
+			})
+
+			Expect(policy.MaxSize("image/png")).To(Equal(int64(5)))
+			Expect(policy.MaxSize("image/gif")).To(Equal(int64(10)))
+			Expect(policy.MaxSize("text/html; charset=utf-8")).To(Equal(int64(100)))
+		})
+
+		It("returns zero if there's no limit", func() {
+			policy := NewContentTypePolicy(DefaultContentTypes, nil, nil)
+
+			Expect(policy.MaxSize(PDF)).To(BeZero())
+		})
+	})
+
+	Describe("ParseContentTypeList", func() {
+		It("parses a comma separated list", func() {
+			Expect(ParseContentTypeList(" image/*, text/html ,,")).To(Equal([]string{"image/*", "text/html"}))
+			Expect(ParseContentTypeList("")).To(BeEmpty())
+		})
+	})
+
+	Describe("ParseContentTypeSizes", func() {
+		It("parses a list of maximum sizes", func() {
+			sizes, err := ParseContentTypeSizes("application/pdf=1024, image/*=512")
+
+			Expect(err).To(BeNil())
+			Expect(sizes).To(Equal(map[string]int64{
+				"application/pdf": 1024,
+				"image/*":         512,
+			}))
+		})
+
+		It("returns an error for malformed sizes", func() {
+			for _, sizes := range []string{"application/pdf", "application/pdf=big", "image/*=-1"} {
+				_, err := ParseContentTypeSizes(sizes)
+				Expect(err).ToNot(BeNil())
+			}
+		})
+	})
+})
@@ -1,131 +1,713 @@
 package http_crawler
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"html"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
-var (
-	ErrCannotCrawlURL  = errors.New("Cannot crawl URLs that don't live under the provided root URLs")
-	ErrNotFound        = errors.New("404 Not Found")
-	ErrRetryRequest5XX = errors.New("Retry request: 5XX HTTP Response returned")
-	ErrRetryRequest429 = errors.New("Retry request: 429 HTTP Response returned (back off)")
+// The product token we identify as in the User-Agent header and match
+// against robots.txt User-agent lines. RFC 9309 only allows letters,
+// underscores and hyphens in it.
+const userAgentProduct = "GOVUKCrawlerWorker"
+
+// Robots.txt files larger than this are truncated before parsing.
+const maxRobotsTxtSize = 500 * 1024
+
+// The longest redirect chain followed to find a robots.txt file. RFC 9309
+// asks for at least five.
+const maxRobotsTxtRedirects = 5
 
-	redirectStatusCodes = []int{http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect}
+// The longest redirect chain that will be followed, the same limit as
+// net/http uses.
+const maxRedirects = 10
+
+var (
+	ErrBodyTooLarge       = errors.New("Response body is larger than the maximum body size")
+	ErrCannotCrawlURL     = errors.New("Cannot crawl URLs that don't live under the provided root URLs")
+	ErrDisallowedByRobots = errors.New("Cannot crawl URLs that are disallowed by robots.txt")
+	ErrNotFound           = errors.New("404 Not Found")
+	ErrRetryRequest5XX    = errors.New("Retry request: 5XX HTTP Response returned")
+	ErrRetryRequest429    = errors.New("Retry request: 429 HTTP Response returned (back off)")
+
+	redirectStatusCodes = []int{
+		http.StatusMovedPermanently,
+		http.StatusFound,
+		http.StatusSeeOther,
+		http.StatusTemporaryRedirect,
+		http.StatusPermanentRedirect,
+	}
 
 	statusCodes []int
 	once        sync.Once
 )
 
+// RetryError is returned when a request should be retried later. Err is
+// either ErrRetryRequest429 or ErrRetryRequest5XX, and RetryAfter is the
+// delay advised by any Retry-After response header (zero if absent).
+type RetryError struct {
+	Err        error
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *RetryError) Error() string {
+	return e.Err.Error()
+}
+
+// BackOff reports whether the server is asking us to slow down, rather than
+// just failing this particular request.
+func (e *RetryError) BackOff() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusServiceUnavailable
+}
+
 type BasicAuth struct {
 	Username string
 	Password string
 }
 
 type Crawler struct {
-	RootURLs []*url.URL
+	RootURLs  []*url.URL
+	Scheduler *Scheduler
+	// Validators, if set, is used to make conditional requests for URLs
+	// that have been crawled before.
+	Validators ValidatorStore
+	// ContentTypes decides which responses are worth keeping, and limits
+	// the size of their bodies.
+	ContentTypes *ContentTypePolicy
 
 	basicAuth      *BasicAuth
+	client         *http.Client
 	version        string
 	rateLimitToken string
+	maxBodySize    int64
+	streamDir      string
+
+	contentTypeSource string
+	normaliseCharset  bool
+
+	robotsMutex sync.Mutex
+	robots      map[string]*robotsEntry
+}
+
+// robotsEntry caches the robots.txt rules for a single scheme and host.
+type robotsEntry struct {
+	mutex     sync.Mutex
+	robots    *RobotsTxt
+	fetchedAt time.Time
 }
 
-func NewCrawler(rootURLs []*url.URL, versionNumber string, rateLimitToken string, basicAuth *BasicAuth) *Crawler {
+func NewCrawler(rootURLs []*url.URL, versionNumber string, options CrawlerOptions) (*Crawler, error) {
+	client, err := newHTTPClient(options)
+	if err != nil {
+		return nil, err
+	}
+
+	contentTypeSource := options.ContentTypeSource
+	if contentTypeSource == "" {
+		contentTypeSource = ContentTypeFromHeader
+	}
+	if !isValidContentTypeSource(contentTypeSource) {
+		return nil, fmt.Errorf("Unknown content type source: %s", contentTypeSource)
+	}
+
 	return &Crawler{
-		RootURLs: rootURLs,
+		RootURLs:     rootURLs,
+		Scheduler:    NewScheduler(0, HostLimit{}, nil),
+		ContentTypes: defaultContentTypePolicy,
 
-		basicAuth:      basicAuth,
+		basicAuth:      options.BasicAuth,
+		client:         client,
 		version:        versionNumber,
-		rateLimitToken: rateLimitToken,
-	}
+		rateLimitToken: options.RateLimitToken,
+		maxBodySize:    options.MaxBodySize,
+		streamDir:      options.StreamDir,
+
+		contentTypeSource: contentTypeSource,
+		normaliseCharset:  options.NormaliseCharset,
+
+		robots: make(map[string]*robotsEntry),
+	}, nil
 }
 
 func (c *Crawler) Crawl(crawlURL *url.URL) (*CrawlerResponse, error) {
-	var redirectDestinationURL = ""
-	var redirectBody = ""
-	var body []byte
-
 	if !IsAllowedHost(crawlURL.Host, c.RootURLs) {
 		return nil, ErrCannotCrawlURL
 	}
 
-	req, err := http.NewRequest("GET", crawlURL.String(), nil)
+	robots, err := c.robotsTxt(c.robotsEntry(crawlURL), crawlURL)
 	if err != nil {
 		return nil, err
 	}
 
-	if c.basicAuth != nil {
-		req.SetBasicAuth(c.basicAuth.Username, c.basicAuth.Password)
+	if !robots.Allowed(crawlURL.RequestURI()) {
+		return nil, ErrDisallowedByRobots
 	}
 
-	if c.rateLimitToken != "" {
-		req.Header.Set("Rate-Limit-Token", c.rateLimitToken)
+	release := c.Scheduler.Acquire(crawlURL.Host, robots.CrawlDelay)
+	defer release()
+
+	req, err := c.newRequest(crawlURL)
+	if err != nil {
+		return nil, err
 	}
 
-	hostname, _ := os.Hostname()
+	var validators *Validators
+	if c.Validators != nil {
+		validators, err = c.Validators.Get(crawlURL)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	req.Header.Set("User-Agent", fmt.Sprintf(
-		"GOV.UK Crawler Worker/%s on host '%s'", c.version, hostname))
+	if validators != nil {
+		if validators.ETag != "" {
+			req.Header.Set("If-None-Match", validators.ETag)
+		}
+		if validators.LastModified != "" {
+			req.Header.Set("If-Modified-Since", validators.LastModified)
+		}
+	}
 
-	resp, err := http.DefaultTransport.RoundTrip(req)
+	timer := newRequestTimer()
+	resp, err := c.client.Do(timer.trace(req))
 
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && validators != nil {
+		// The body is left empty, it's up to the caller to use the
+		// copy it stored when the URL was last crawled.
+		return &CrawlerResponse{
+			ContentType:      validators.ContentType,
+			URL:              resp.Request.URL,
+			ResponseMetadata: timer.metadata(resp, 0),
+			NotModified:      true,
+			ETag:             validators.ETag,
+			LastModified:     validators.LastModified,
+		}, nil
+	}
+
 	switch {
-	case resp.StatusCode == 429:
-		return nil, ErrRetryRequest429
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil, newRetryError(ErrRetryRequest429, resp)
 	case containsInt(Retry5XXStatusCodes(), resp.StatusCode):
-		return nil, ErrRetryRequest5XX
+		return nil, newRetryError(ErrRetryRequest5XX, resp)
 	case resp.StatusCode == http.StatusNotFound:
 		return nil, ErrNotFound
 	case containsInt(redirectStatusCodes, resp.StatusCode):
+		// Each hop takes a slot of its own, which may be on this host.
+		release()
+
+		redirects, err := c.followRedirects(resp)
+		if err != nil {
+			return nil, err
+		}
+
 		// If we encounter a redirect, create some HTML that does the redirect
 		// and return this as a body. This enables two things:
 		//  1. A file with this HTML will be created which will allow
 		//     redirects to work in a static environment.
 		//  2. The link to the new URL will be picked up and added to the queue
 		//     so it will be visited and the eventual content saved.
-		redirectDestinationURL = resp.Header.Get("Location")
-		redirectBody = `<!DOCTYPE html>
-		<html lang="en">
-		<head>
-		<meta http-equiv="refresh" content="1; url=` + redirectDestinationURL + `">
-		<title>Redirecting</title>
-		</head>
-		<body>
-		<p>Redirecting you to <a href="` + redirectDestinationURL + `">` + redirectDestinationURL + `</a>.</p>
-		</body>
-		</html>`
-	}
-
-	if redirectBody != "" {
-		body = []byte(redirectBody)
+		return &CrawlerResponse{
+			Body:             redirectPage(redirects[len(redirects)-1].To),
+			ContentType:      HTML,
+			URL:              resp.Request.URL,
+			ResponseMetadata: timer.metadata(resp, resp.ContentLength),
+			Redirects:        redirects,
+		}, nil
+	}
+
+	decoded, err := decodeBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	// The start of the body is needed to sniff its type, which decides
+	// how the rest of it is read.
+	body := bufio.NewReaderSize(decoded, sniffLen)
+	start, err := body.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	declared := resp.Header.Get("Content-Type")
+	contentType, sniffed, mismatch := ResolveContentType(c.contentTypeSource, declared, resp.Request.URL, start)
+
+	response := &CrawlerResponse{
+		ContentType:  contentType,
+		URL:          resp.Request.URL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	var contentLength int64
+	maxSize := c.maxSize(response.ContentType)
+
+	if c.streamDir != "" && !response.HasLinks() {
+		// Only HTML and CSS are needed in memory, for extracting links.
+		response.BodyFile, contentLength, err = c.streamBody(body, decodedContentLength(resp), maxSize)
+		if err != nil {
+			return nil, err
+		}
 	} else {
-		body, err = ioutil.ReadAll(resp.Body)
+		response.Body, err = c.readBody(body, decodedContentLength(resp), maxSize)
 		if err != nil {
 			return nil, err
 		}
-	}
 
-	response := &CrawlerResponse{
-		Body:        body,
-		ContentType: resp.Header.Get("Content-Type"),
-		URL:         resp.Request.URL,
+		contentLength = int64(len(response.Body))
+
+		if response.IsHTML() {
+			c.setCharset(response, declared)
+		}
 	}
 
+	response.ResponseMetadata = timer.metadata(resp, contentLength)
+	response.DeclaredContentType = declared
+	response.SniffedContentType = sniffed
+	response.ContentTypeMismatch = mismatch
+
 	return response, nil
 }
 
+// Fetch requests a URL on one of our hosts and returns its decoded body,
+// for files such as robots.txt and sitemaps which are read by the crawler
+// rather than mirrored. Redirects aren't followed, so anything but a 2XX
+// response is an error.
+func (c *Crawler) Fetch(fetchURL *url.URL) ([]byte, error) {
+	if !IsAllowedHost(fetchURL.Host, c.RootURLs) {
+		return nil, ErrCannotCrawlURL
+	}
+
+	robots, err := c.robotsTxt(c.robotsEntry(fetchURL), fetchURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if !robots.Allowed(fetchURL.RequestURI()) {
+		return nil, ErrDisallowedByRobots
+	}
+
+	release := c.Scheduler.Acquire(fetchURL.Host, robots.CrawlDelay)
+	defer release()
+
+	req, err := c.newRequest(fetchURL)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil, newRetryError(ErrRetryRequest429, resp)
+	case containsInt(Retry5XXStatusCodes(), resp.StatusCode):
+		return nil, newRetryError(ErrRetryRequest5XX, resp)
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return nil, fmt.Errorf("Unexpected HTTP status fetching %s: %s", fetchURL, resp.Status)
+	}
+
+	decoded, err := decodeBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.readBody(decoded, decodedContentLength(resp), c.maxBodySize)
+}
+
+// followRedirects follows the redirect chain starting with resp and
+// returns each hop of it. Hops are only followed while they stay on our
+// hosts and are allowed by robots.txt, so the last hop's destination may
+// not have been requested. Each hop waits for the Scheduler like any other
+// request. Nothing but the status of each hop is read, as the destination
+// is crawled in its own right once it's been queued.
+func (c *Crawler) followRedirects(resp *http.Response) ([]Redirect, error) {
+	redirects := []Redirect{}
+	seen := map[string]bool{resp.Request.URL.String(): true}
+
+	for {
+		location, err := resp.Location()
+		if err != nil {
+			if len(redirects) == 0 {
+				return nil, err
+			}
+
+			return redirects, nil
+		}
+
+		redirects = append(redirects, Redirect{
+			From:       resp.Request.URL,
+			To:         location,
+			StatusCode: resp.StatusCode,
+		})
+
+		if len(redirects) >= maxRedirects || seen[location.String()] {
+			return redirects, nil
+		}
+		seen[location.String()] = true
+
+		robots := c.followableRobotsTxt(location)
+		if robots == nil {
+			return redirects, nil
+		}
+
+		req, err := c.newRequest(location)
+		if err != nil {
+			return redirects, nil
+		}
+
+		release := c.Scheduler.Acquire(location.Host, robots.CrawlDelay)
+		next, err := c.client.Do(req)
+		if err == nil {
+			next.Body.Close()
+		}
+		release()
+
+		if err != nil {
+			return redirects, nil
+		}
+
+		if !containsInt(redirectStatusCodes, next.StatusCode) {
+			return redirects, nil
+		}
+
+		resp = next
+	}
+}
+
+// followableRobotsTxt returns the robots.txt rules for the host of a
+// redirect's location, or nil if the redirect can't be followed.
+func (c *Crawler) followableRobotsTxt(location *url.URL) *RobotsTxt {
+	if location.Scheme != "http" && location.Scheme != "https" {
+		return nil
+	}
+
+	if !IsAllowedHost(location.Host, c.RootURLs) {
+		return nil
+	}
+
+	robots, err := c.robotsTxt(c.robotsEntry(location), location)
+	if err != nil || !robots.Allowed(location.RequestURI()) {
+		return nil
+	}
+
+	return robots
+}
+
+// redirectPage returns an HTML page which sends browsers on to destination.
+func redirectPage(destination *url.URL) []byte {
+	escaped := html.EscapeString(destination.String())
+
+	return []byte(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta http-equiv="refresh" content="1; url=` + escaped + `">
+<title>Redirecting</title>
+</head>
+<body>
+<p>Redirecting you to <a href="` + escaped + `">` + escaped + `</a>.</p>
+</body>
+</html>`)
+}
+
+// setCharset records the character encoding of an HTML response, and
+// converts it to UTF-8 if the crawler normalises charsets. Pages in a
+// charset we can't convert from are left as they are. Only the declared
+// Content-Type is used, as a sniffed one always claims to be UTF-8.
+func (c *Crawler) setCharset(response *CrawlerResponse, declared string) {
+	response.Charset = DetectCharset(declared, response.Body)
+
+	if !c.normaliseCharset {
+		return
+	}
+
+	body, err := ToUTF8(response.Body, response.Charset)
+	if err != nil {
+		return
+	}
+
+	response.Body = DeclareUTF8(body)
+	response.Charset = UTF8
+
+	if mimeType, params, err := mime.ParseMediaType(response.ContentType); err == nil {
+		params["charset"] = UTF8
+		response.ContentType = mime.FormatMediaType(mimeType, params)
+	}
+}
+
+// maxSize returns the largest body allowed for a content type, which is the
+// smaller of the crawler's maximum body size and any maximum for the type.
+// Zero means there's no limit.
+func (c *Crawler) maxSize(contentType string) int64 {
+	maxSize := c.ContentTypes.MaxSize(contentType)
+
+	if maxSize <= 0 || (c.maxBodySize > 0 && c.maxBodySize < maxSize) {
+		return c.maxBodySize
+	}
+
+	return maxSize
+}
+
+// readBody reads the whole of a response body into memory.
+func (c *Crawler) readBody(reader io.Reader, contentLength int64, maxSize int64) ([]byte, error) {
+	if bodyTooLarge(contentLength, maxSize) {
+		return nil, ErrBodyTooLarge
+	}
+
+	body, err := ioutil.ReadAll(limitBody(reader, maxSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if bodyTooLarge(int64(len(body)), maxSize) {
+		return nil, ErrBodyTooLarge
+	}
+
+	return body, nil
+}
+
+// streamBody copies a response body to a new temporary file in the stream
+// directory and returns its path and size.
+func (c *Crawler) streamBody(reader io.Reader, contentLength int64, maxSize int64) (string, int64, error) {
+	if bodyTooLarge(contentLength, maxSize) {
+		return "", 0, ErrBodyTooLarge
+	}
+
+	file, err := ioutil.TempFile(c.streamDir, "body-")
+	if err != nil {
+		return "", 0, err
+	}
+
+	var size int64
+
+	// Temporary files are private by default, but this one will become
+	// part of the mirror.
+	err = file.Chmod(0644)
+	if err == nil {
+		size, err = io.Copy(file, limitBody(reader, maxSize))
+	}
+	if err == nil {
+		// The file is renamed into the mirror as it is, so it needs to
+		// be on disk before then.
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && bodyTooLarge(size, maxSize) {
+		err = ErrBodyTooLarge
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+		return "", 0, err
+	}
+
+	return file.Name(), size, nil
+}
+
+// limitBody stops reading a body one byte past the maximum size, so that
+// bodies without a Content-Length can still be found to be too large.
+func limitBody(body io.Reader, maxSize int64) io.Reader {
+	if maxSize <= 0 {
+		return body
+	}
+
+	return io.LimitReader(body, maxSize+1)
+}
+
+func bodyTooLarge(size int64, maxSize int64) bool {
+	return maxSize > 0 && size > maxSize
+}
+
+func (c *Crawler) newRequest(requestURL *url.URL) (*http.Request, error) {
+	req, err := http.NewRequest("GET", requestURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.basicAuth != nil {
+		req.SetBasicAuth(c.basicAuth.Username, c.basicAuth.Password)
+	}
+
+	if c.rateLimitToken != "" {
+		req.Header.Set("Rate-Limit-Token", c.rateLimitToken)
+	}
+
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+
+	hostname, _ := os.Hostname()
+
+	req.Header.Set("User-Agent", fmt.Sprintf(
+		"%s/%s (GOV.UK Crawler Worker on host '%s')", userAgentProduct, c.version, hostname))
+
+	return req, nil
+}
+
+func (c *Crawler) robotsEntry(crawlURL *url.URL) *robotsEntry {
+	key := crawlURL.Scheme + "://" + crawlURL.Host
+
+	c.robotsMutex.Lock()
+	defer c.robotsMutex.Unlock()
+
+	entry, ok := c.robots[key]
+	if !ok {
+		entry = &robotsEntry{}
+		c.robots[key] = entry
+	}
+
+	return entry
+}
+
+// robotsTxt returns the cached robots.txt rules for the host of crawlURL,
+// fetching them first if they're missing or older than RobotsTxtTTL.
+func (c *Crawler) robotsTxt(entry *robotsEntry, crawlURL *url.URL) (*RobotsTxt, error) {
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+
+	if entry.robots != nil && time.Since(entry.fetchedAt) < RobotsTxtTTL {
+		return entry.robots, nil
+	}
+
+	robotsURL := &url.URL{
+		Scheme: crawlURL.Scheme,
+		Host:   crawlURL.Host,
+		Path:   "/robots.txt",
+	}
+
+	resp, release, err := c.getRobotsTxt(robotsURL)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil, newRetryError(ErrRetryRequest429, resp)
+	case containsInt(Retry5XXStatusCodes(), resp.StatusCode):
+		return nil, newRetryError(ErrRetryRequest5XX, resp)
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		decoded, err := decodeBody(resp)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(decoded, maxRobotsTxtSize))
+		if err != nil {
+			return nil, err
+		}
+
+		entry.robots, err = ParseRobotsTxt(body, userAgentProduct)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		// A missing or inaccessible robots.txt places no restrictions
+		// on crawling.
+		entry.robots = &RobotsTxt{}
+	}
+
+	entry.fetchedAt = time.Now()
+
+	return entry.robots, nil
+}
+
+// getRobotsTxt requests robotsURL, following up to maxRobotsTxtRedirects
+// redirects, even to other hosts. Credentials are only sent to our own
+// hosts. Every request waits for the Scheduler, and the function returned
+// must be called once the body of the last response has been read. That
+// response is still a redirect if there were too many of them.
+func (c *Crawler) getRobotsTxt(robotsURL *url.URL) (*http.Response, func(), error) {
+	for redirects := 0; ; redirects++ {
+		req, err := c.newRequest(robotsURL)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !IsAllowedHost(robotsURL.Host, c.RootURLs) {
+			req.Header.Del("Authorization")
+			req.Header.Del("Rate-Limit-Token")
+		}
+
+		release := c.Scheduler.Acquire(robotsURL.Host, 0)
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+
+		if redirects == maxRobotsTxtRedirects || !containsInt(redirectStatusCodes, resp.StatusCode) {
+			return resp, release, nil
+		}
+
+		location, err := resp.Location()
+		if err != nil || (location.Scheme != "http" && location.Scheme != "https") {
+			return resp, release, nil
+		}
+		resp.Body.Close()
+		release()
+
+		robotsURL = location
+	}
+}
+
+func newRetryError(err error, resp *http.Response) *RetryError {
+	return &RetryError{
+		Err:        err,
+		StatusCode: resp.StatusCode,
+		RetryAfter: ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// ParseRetryAfter parses the value of a Retry-After header, which is either
+// a number of seconds or an HTTP-date, into a duration relative to now.
+// Returns zero if the value is missing, invalid or in the past.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil || !date.After(now) {
+		return 0
+	}
+
+	return date.Sub(now)
+}
+
 func Retry5XXStatusCodes() []int {
 	// This is go's equivalent of memoization/macro expansion. It's
 	// being used here because we have a fixed array we're generating
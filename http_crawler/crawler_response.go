@@ -3,6 +3,8 @@ package http_crawler
 import (
 	"mime"
 	"net/url"
+	"os"
+	"strings"
 )
 
 const (
@@ -30,21 +32,129 @@ type CrawlerResponse struct {
 	Body        []byte
 	ContentType string
 	URL         *url.URL
+
+	ResponseMetadata
+
+	// Charset is the character encoding of an HTML Body, see
+	// DetectCharset.
+	Charset string
+
+	// BodyFile is the path of a temporary file holding the body when it
+	// was streamed to disk rather than read into Body. Whoever handles
+	// the response last must move it into place or call RemoveBodyFile.
+	BodyFile string
+
+	// Redirects is the redirect chain followed from URL, and is only set
+	// when Body is a generated redirect page.
+	Redirects []Redirect
+
+	// NotModified is true when a conditional request found the URL
+	// unchanged since it was last crawled. Body is empty in that case.
+	NotModified  bool
+	ETag         string
+	LastModified string
 }
 
+// Redirect is a single hop of a redirect chain.
+type Redirect struct {
+	From       *url.URL
+	To         *url.URL
+	StatusCode int
+}
+
+// AcceptedContentType reports whether the response is one of the
+// DefaultContentTypes. Crawlers configured with their own ContentTypes
+// should use those instead.
 func (c *CrawlerResponse) AcceptedContentType() bool {
+	return defaultContentTypePolicy.Accepts(c.ContentType)
+}
+
+// FinalURL returns the URL the response's redirects end at, or URL if it
+// wasn't redirected.
+func (c *CrawlerResponse) FinalURL() *url.URL {
+	if len(c.Redirects) == 0 {
+		return c.URL
+	}
+
+	return c.Redirects[len(c.Redirects)-1].To
+}
+
+// UTF8Body returns Body converted to UTF-8 from its Charset, which is
+// detected first if it isn't already known.
+func (c *CrawlerResponse) UTF8Body() ([]byte, error) {
+	charset := c.Charset
+	if charset == "" {
+		charset = DetectCharset(c.ContentType, c.Body)
+	}
+
+	return ToUTF8(c.Body, charset)
+}
+
+// IsHTML reports whether the response is an HTML page.
+func (c *CrawlerResponse) IsHTML() bool {
+	mimeType, err := c.ParseContentType()
+
+	return err == nil && mimeType == HTML
+}
+
+// IsCSS reports whether the response is a stylesheet.
+func (c *CrawlerResponse) IsCSS() bool {
+	mimeType, err := c.ParseContentType()
+
+	return err == nil && mimeType == CSS
+}
+
+// HasLinks reports whether URLs can be extracted from the response.
+func (c *CrawlerResponse) HasLinks() bool {
+	return c.IsHTML() || c.IsCSS()
+}
+
+// Compressible reports whether the response is text, and so worth storing
+// compressed.
+func (c *CrawlerResponse) Compressible() bool {
 	mimeType, err := c.ParseContentType()
 	if err != nil {
 		return false
 	}
 
 	switch mimeType {
-	case CSS, CSV, DOCX, GIF, HTML, ICO, ICS, JAVASCRIPT,
-		JPEG, JSON, ODP, ODS, ODT, PDF, PNG, XLS, XLSX:
+	case ATOM, CSS, CSV, HTML, ICS, JAVASCRIPT, JSON, "image/svg+xml":
 		return true
 	}
 
-	return false
+	return strings.HasPrefix(mimeType, "text/") || strings.HasSuffix(mimeType, "+xml") ||
+		strings.HasSuffix(mimeType, "/xml")
+}
+
+// RemoveBodyFile deletes the temporary file holding a streamed body, if
+// there is one.
+func (c *CrawlerResponse) RemoveBodyFile() error {
+	if c.BodyFile == "" {
+		return nil
+	}
+
+	err := os.Remove(c.BodyFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	c.BodyFile = ""
+
+	return nil
+}
+
+// Validators returns the cache validators for the response, or nil if the
+// server didn't send any.
+func (c *CrawlerResponse) Validators() *Validators {
+	if c.ETag == "" && c.LastModified == "" {
+		return nil
+	}
+
+	return &Validators{
+		ETag:         c.ETag,
+		LastModified: c.LastModified,
+		ContentType:  c.ContentType,
+	}
 }
 
 func (c *CrawlerResponse) ParseContentType() (string, error) {
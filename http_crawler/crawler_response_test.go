@@ -1,6 +1,8 @@
 package http_crawler_test
 
 import (
+	"net/url"
+
 	. "github.com/alphagov/govuk_crawler_worker/http_crawler"
 
 	. "github.com/onsi/ginkgo"
@@ -38,6 +40,42 @@ var _ = Describe("CrawlerResponse", func() {
 		})
 	})
 
+	Describe("FinalURL", func() {
+		It("returns the URL if there were no redirects", func() {
+			u, _ := url.Parse("https://www.gov.uk/foo")
+			response := &CrawlerResponse{URL: u}
+
+			Expect(response.FinalURL()).To(Equal(u))
+		})
+
+		It("returns the destination of the last redirect", func() {
+			from, _ := url.Parse("https://www.gov.uk/foo")
+			via, _ := url.Parse("https://www.gov.uk/bar")
+			to, _ := url.Parse("https://www.gov.uk/baz")
+			response := &CrawlerResponse{
+				URL: from,
+				Redirects: []Redirect{
+					{From: from, To: via, StatusCode: 301},
+					{From: via, To: to, StatusCode: 301},
+				},
+			}
+
+			Expect(response.FinalURL()).To(Equal(to))
+		})
+	})
+
+	Describe("HasLinks", func() {
+		It("is true for HTML and CSS", func() {
+			Expect((&CrawlerResponse{ContentType: "text/html; charset=utf-8"}).HasLinks()).To(BeTrue())
+			Expect((&CrawlerResponse{ContentType: CSS}).HasLinks()).To(BeTrue())
+		})
+
+		It("is false for other content types", func() {
+			Expect((&CrawlerResponse{ContentType: JAVASCRIPT}).HasLinks()).To(BeFalse())
+			Expect((&CrawlerResponse{ContentType: PNG}).HasLinks()).To(BeFalse())
+		})
+	})
+
 	Describe("ContentType", func() {
 		It("returns the error if we can't parse the content type", func() {
 			mime, err := response.ParseContentType()
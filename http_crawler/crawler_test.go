@@ -1,13 +1,24 @@
 package http_crawler_test
 
 import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	. "github.com/alphagov/govuk_crawler_worker/http_crawler"
 
@@ -15,6 +26,23 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+// recordingTransport is an http.RoundTripper which records the paths it's
+// asked for and responds to them all without using the network.
+type recordingTransport struct {
+	paths []string
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.paths = append(t.paths, req.URL.Path)
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/plain"}},
+		Body:       ioutil.NopCloser(strings.NewReader("from transport")),
+		Request:    req,
+	}, nil
+}
+
 func testServer(status int, body string) *httptest.Server {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(status)
@@ -28,6 +56,7 @@ var _ = Describe("Crawl", func() {
 	var rootURLs []*url.URL
 	var urlA, urlB *url.URL
 	var token string
+	var err error
 
 	BeforeEach(func() {
 		urlA = &url.URL{
@@ -42,7 +71,8 @@ var _ = Describe("Crawl", func() {
 		}
 		rootURLs = []*url.URL{urlA, urlB}
 		token = "Ay8aix8guitai0uud4ohdeiqu0theuyeiy3Da1ool6nau0ohphaey9nai5teeDac"
-		crawler = NewCrawler(rootURLs, "0.0.0", token, nil)
+		crawler, err = NewCrawler(rootURLs, "0.0.0", CrawlerOptions{RateLimitToken: token})
+		Expect(err).To(BeNil())
 		Expect(crawler).ToNot(BeNil())
 	})
 
@@ -72,7 +102,11 @@ var _ = Describe("Crawl", func() {
 			basicAuthTestServer := httptest.NewServer(http.HandlerFunc(basic("username", "password")))
 			defer basicAuthTestServer.Close()
 
-			basicAuthCrawler := NewCrawler([]*url.URL{urlA}, "0.0.0", token, &BasicAuth{"username", "password"})
+			basicAuthCrawler, err := NewCrawler([]*url.URL{urlA}, "0.0.0", CrawlerOptions{
+				BasicAuth:      &BasicAuth{"username", "password"},
+				RateLimitToken: token,
+			})
+			Expect(err).To(BeNil())
 
 			testURL, _ := url.Parse(basicAuthTestServer.URL)
 			response, err := basicAuthCrawler.Crawl(testURL)
@@ -82,6 +116,97 @@ var _ = Describe("Crawl", func() {
 		})
 	})
 
+	Describe("NewCrawler() options", func() {
+		It("uses a custom transport", func() {
+			transport := &recordingTransport{}
+			transportCrawler, err := NewCrawler([]*url.URL{urlA}, "0.0.0", CrawlerOptions{Transport: transport})
+			Expect(err).To(BeNil())
+
+			testURL, _ := url.Parse("http://127.0.0.1/foo")
+			response, err := transportCrawler.Crawl(testURL)
+
+			Expect(err).To(BeNil())
+			Expect(string(response.Body)).To(Equal("from transport"))
+			Expect(transport.paths).To(Equal([]string{"/robots.txt", "/foo"}))
+		})
+
+		It("gives up on requests that take longer than the timeout", func() {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/robots.txt" {
+					time.Sleep(500 * time.Millisecond)
+				}
+				fmt.Fprintln(w, "Too slow")
+			}))
+			defer ts.Close()
+
+			timeoutCrawler, err := NewCrawler(rootURLs, "0.0.0", CrawlerOptions{Timeout: 100 * time.Millisecond})
+			Expect(err).To(BeNil())
+
+			testURL, _ := url.Parse(ts.URL + "/slow")
+			response, err := timeoutCrawler.Crawl(testURL)
+
+			Expect(err).ToNot(BeNil())
+			Expect(response).To(BeNil())
+		})
+
+		It("sends requests through a proxy", func() {
+			proxiedPaths := []string{}
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				proxiedPaths = append(proxiedPaths, r.URL.String())
+				fmt.Fprintln(w, "Proxied")
+			}))
+			defer proxy.Close()
+
+			proxyURL, _ := url.Parse(proxy.URL)
+			proxyCrawler, err := NewCrawler(rootURLs, "0.0.0", CrawlerOptions{ProxyURL: proxyURL})
+			Expect(err).To(BeNil())
+
+			testURL, _ := url.Parse("http://127.0.0.2/foo")
+			response, err := proxyCrawler.Crawl(testURL)
+
+			Expect(err).To(BeNil())
+			Expect(strings.TrimSpace(string(response.Body))).To(Equal("Proxied"))
+			Expect(proxiedPaths).To(ContainElement("http://127.0.0.2/foo"))
+		})
+
+		It("trusts servers signed by a custom CA bundle", func() {
+			ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintln(w, "Hello TLS")
+			}))
+			defer ts.Close()
+
+			caFile, err := ioutil.TempFile("", "ca_bundle")
+			Expect(err).To(BeNil())
+			defer os.Remove(caFile.Name())
+
+			pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: ts.TLS.Certificates[0].Certificate[0]})
+			caFile.Close()
+
+			testURL, _ := url.Parse(ts.URL)
+
+			untrustingCrawler, err := NewCrawler(rootURLs, "0.0.0", CrawlerOptions{})
+			Expect(err).To(BeNil())
+			_, err = untrustingCrawler.Crawl(testURL)
+			Expect(err).ToNot(BeNil())
+
+			trustingCrawler, err := NewCrawler(rootURLs, "0.0.0", CrawlerOptions{CABundleFile: caFile.Name()})
+			Expect(err).To(BeNil())
+			response, err := trustingCrawler.Crawl(testURL)
+			Expect(err).To(BeNil())
+			Expect(strings.TrimSpace(string(response.Body))).To(Equal("Hello TLS"))
+		})
+
+		It("returns an error if the CA bundle can't be loaded", func() {
+			_, err := NewCrawler(rootURLs, "0.0.0", CrawlerOptions{CABundleFile: "/does/not/exist.pem"})
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("returns an error if only one of a client certificate and key is given", func() {
+			_, err := NewCrawler(rootURLs, "0.0.0", CrawlerOptions{ClientCertFile: "cert.pem"})
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
 	Describe("Crawler.Crawl()", func() {
 		It("specifies a user agent when making a request", func() {
 			userAgentTestServer := func(httpStatus int) *httptest.Server {
@@ -98,7 +223,7 @@ var _ = Describe("Crawl", func() {
 			response, err := crawler.Crawl(testURL)
 
 			Expect(err).To(BeNil())
-			Expect(string(response.Body)).Should(MatchRegexp("GOV.UK Crawler Worker/" + "0.0.0"))
+			Expect(string(response.Body)).Should(HavePrefix("GOVUKCrawlerWorker/0.0.0 (GOV.UK Crawler Worker on host "))
 		})
 
 		It("returns an error when a redirect is encounted", func() {
@@ -113,7 +238,89 @@ var _ = Describe("Crawl", func() {
 			response, err := crawler.Crawl(testURL)
 
 			Expect(err).To(BeNil())
-			Expect(string(response.Body)).Should(MatchRegexp("Redirecting you to <a href=\"/redirect\">/redirect</a>"))
+			Expect(string(response.Body)).Should(ContainSubstring(`Redirecting you to <a href="` + ts.URL + `/redirect">` + ts.URL + `/redirect</a>`))
+			Expect(response.ContentType).To(Equal(HTML))
+		})
+
+		Describe("following redirects", func() {
+			var redirectTestServer *httptest.Server
+
+			BeforeEach(func() {
+				redirectTestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch r.URL.Path {
+					case "/first":
+						http.Redirect(w, r, "second", http.StatusMovedPermanently)
+					case "/second":
+						http.Redirect(w, r, "/third", http.StatusPermanentRedirect)
+					case "/third":
+						fmt.Fprintln(w, "Arrived")
+					case "/external":
+						http.Redirect(w, r, "https://example.com/elsewhere", http.StatusFound)
+					case "/loop":
+						http.Redirect(w, r, "/loop", http.StatusFound)
+					case "/quotes":
+						w.Header().Set("Location", `/"><script>alert(1)</script>`)
+						w.WriteHeader(http.StatusFound)
+					}
+				}))
+			})
+
+			AfterEach(func() {
+				redirectTestServer.Close()
+			})
+
+			redirectURL := func(path string) *url.URL {
+				u, _ := url.Parse(redirectTestServer.URL + path)
+				return u
+			}
+
+			It("records the whole chain, resolving relative locations", func() {
+				response, err := crawler.Crawl(redirectURL("/first"))
+
+				Expect(err).To(BeNil())
+				Expect(response.Redirects).To(Equal([]Redirect{
+					{From: redirectURL("/first"), To: redirectURL("/second"), StatusCode: http.StatusMovedPermanently},
+					{From: redirectURL("/second"), To: redirectURL("/third"), StatusCode: http.StatusPermanentRedirect},
+				}))
+				Expect(string(response.Body)).To(ContainSubstring(`<a href="` + redirectURL("/third").String() + `">`))
+				Expect(response.StatusCode).To(Equal(http.StatusMovedPermanently))
+				Expect(response.FinalURL()).To(Equal(redirectURL("/third")))
+			})
+
+			It("doesn't follow redirects to other hosts", func() {
+				response, err := crawler.Crawl(redirectURL("/external"))
+
+				Expect(err).To(BeNil())
+				Expect(response.Redirects).To(HaveLen(1))
+				Expect(response.Redirects[0].To.String()).To(Equal("https://example.com/elsewhere"))
+			})
+
+			It("stops following redirect loops", func() {
+				response, err := crawler.Crawl(redirectURL("/loop"))
+
+				Expect(err).To(BeNil())
+				Expect(response.Redirects).To(HaveLen(1))
+			})
+
+			It("waits for the scheduler before each hop", func() {
+				crawler.Scheduler = NewScheduler(1, HostLimit{MaxInFlight: 1, Delay: 100 * time.Millisecond}, nil)
+
+				start := time.Now()
+				response, err := crawler.Crawl(redirectURL("/first"))
+
+				Expect(err).To(BeNil())
+				Expect(response.Redirects).To(HaveLen(2))
+				// robots.txt, /first, /second and /third
+				Expect(time.Since(start)).To(BeNumerically(">=", 300*time.Millisecond))
+			})
+
+			It("escapes the destination in the redirect page", func() {
+				response, err := crawler.Crawl(redirectURL("/quotes"))
+
+				Expect(err).To(BeNil())
+				Expect(string(response.Body)).ToNot(ContainSubstring("<script>"))
+				Expect(string(response.Body)).To(ContainSubstring(`/%22%3E%3Cscript%3Ealert%281%29%3C/script%3E`))
+			})
 		})
 
 		It("returns an error when server returns a 404", func() {
@@ -137,6 +344,178 @@ var _ = Describe("Crawl", func() {
 			Expect(strings.TrimSpace(string(response.Body))).To(Equal("Hello world"))
 		})
 
+		It("records metadata about the response", func() {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Served-By", "test")
+				w.WriteHeader(http.StatusOK)
+				time.Sleep(20 * time.Millisecond)
+				fmt.Fprint(w, "Hello world")
+			}))
+			defer ts.Close()
+
+			testURL, _ := url.Parse(ts.URL)
+			start := time.Now()
+			response, err := crawler.Crawl(testURL)
+
+			Expect(err).To(BeNil())
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+			Expect(response.Proto).To(Equal("HTTP/1.1"))
+			Expect(response.Header.Get("X-Served-By")).To(Equal("test"))
+			Expect(response.RequestHeader.Get("Rate-Limit-Token")).To(Equal(token))
+			Expect(response.StartedAt).To(BeTemporally("~", start, time.Second))
+			Expect(response.ContentLength).To(Equal(int64(len("Hello world"))))
+			Expect(response.RemoteIP).To(Equal("127.0.0.1"))
+			Expect(response.TLSVersion).To(BeEmpty())
+			Expect(response.Timing.FirstByte).To(BeNumerically(">", 0))
+			Expect(response.Timing.Total).To(BeNumerically(">=", response.Timing.FirstByte))
+		})
+
+		Describe("negotiating compression", func() {
+			body := "<!DOCTYPE html><p>" + strings.Repeat("Hello world ", 100) + "</p>"
+			brotliBody, _ := base64.StdEncoding.DecodeString("G8UEAAQccqQHC6SdMww24AQgsTDhxfleqk+0TED2Ll/w9wJsLg==")
+			var acceptEncoding string
+			var encodingTestServer *httptest.Server
+
+			BeforeEach(func() {
+				encodingTestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					acceptEncoding = r.Header.Get("Accept-Encoding")
+					encoding := strings.TrimPrefix(r.URL.Path, "/")
+
+					var writer io.WriteCloser
+					switch encoding {
+					case "gzip":
+						writer = gzip.NewWriter(w)
+					case "deflate":
+						writer = zlib.NewWriter(w)
+					case "raw-deflate":
+						encoding = "deflate"
+						writer, _ = flate.NewWriter(w, flate.DefaultCompression)
+					case "br":
+						// There's no brotli encoder vendored, so the
+						// body is compressed ahead of time.
+						w.Header().Set("Content-Type", "text/html; charset=utf-8")
+						w.Header().Set("Content-Encoding", encoding)
+						w.Write(brotliBody)
+						return
+					case "compress":
+						w.Header().Set("Content-Encoding", encoding)
+						return
+					default:
+						http.NotFound(w, r)
+						return
+					}
+
+					w.Header().Set("Content-Type", "text/html; charset=utf-8")
+					w.Header().Set("Content-Encoding", encoding)
+					io.WriteString(writer, body)
+					writer.Close()
+				}))
+			})
+
+			AfterEach(func() {
+				encodingTestServer.Close()
+			})
+
+			It("asks for and decodes compressed bodies", func() {
+				for _, encoding := range []string{"gzip", "deflate", "raw-deflate", "br"} {
+					testURL, _ := url.Parse(encodingTestServer.URL + "/" + encoding)
+					response, err := crawler.Crawl(testURL)
+
+					Expect(err).To(BeNil())
+					Expect(acceptEncoding).To(Equal("gzip, deflate, br"))
+					Expect(string(response.Body)).To(Equal(body))
+					Expect(response.ContentLength).To(Equal(int64(len(body))))
+					Expect(response.IsHTML()).To(BeTrue())
+				}
+			})
+
+			It("returns an error for encodings it can't decode", func() {
+				testURL, _ := url.Parse(encodingTestServer.URL + "/compress")
+				_, err := crawler.Crawl(testURL)
+
+				Expect(err).ToNot(BeNil())
+			})
+		})
+
+		Describe("handling charsets", func() {
+			body := "<html><head><meta charset=\"windows-1252\"></head><body>caf\xe9</body></html>"
+			var charsetTestServer *httptest.Server
+
+			BeforeEach(func() {
+				charsetTestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", HTML)
+					fmt.Fprint(w, body)
+				}))
+			})
+
+			AfterEach(func() {
+				charsetTestServer.Close()
+			})
+
+			It("records the charset of HTML pages and leaves them as they are", func() {
+				testURL, _ := url.Parse(charsetTestServer.URL)
+				response, err := crawler.Crawl(testURL)
+
+				Expect(err).To(BeNil())
+				Expect(response.Charset).To(Equal(Windows1252))
+				Expect(string(response.Body)).To(Equal(body))
+				Expect(response.UTF8Body()).To(ContainSubstring("café"))
+			})
+
+			It("converts HTML pages to UTF-8 when normalising charsets", func() {
+				normalisingCrawler, err := NewCrawler(rootURLs, "0.0.0", CrawlerOptions{NormaliseCharset: true})
+				Expect(err).To(BeNil())
+
+				testURL, _ := url.Parse(charsetTestServer.URL)
+				response, err := normalisingCrawler.Crawl(testURL)
+
+				Expect(err).To(BeNil())
+				Expect(response.Charset).To(Equal(UTF8))
+				Expect(response.ContentType).To(Equal("text/html; charset=utf-8"))
+				Expect(string(response.Body)).To(Equal(`<html><head><meta charset="utf-8"></head><body>café</body></html>`))
+			})
+		})
+
+		It("sniffs the content type of responses without a Content-Type header", func() {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Stop net/http from sniffing the content type itself.
+				w.Header()["Content-Type"] = nil
+				fmt.Fprint(w, "<!DOCTYPE html><p>Hello world</p>")
+			}))
+			defer ts.Close()
+
+			testURL, _ := url.Parse(ts.URL + "/page")
+			response, err := crawler.Crawl(testURL)
+
+			Expect(err).To(BeNil())
+			Expect(response.DeclaredContentType).To(BeEmpty())
+			Expect(response.IsHTML()).To(BeTrue())
+			Expect(string(response.Body)).To(Equal("<!DOCTYPE html><p>Hello world</p>"))
+		})
+
+		It("returns an error for an unknown content type source", func() {
+			_, err := NewCrawler(rootURLs, "0.0.0", CrawlerOptions{ContentTypeSource: "guess"})
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("records the TLS version of HTTPS responses", func() {
+			ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "Hello TLS")
+			}))
+			defer ts.Close()
+
+			tlsCrawler, err := NewCrawler(rootURLs, "0.0.0", CrawlerOptions{
+				Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+			})
+			Expect(err).To(BeNil())
+
+			testURL, _ := url.Parse(ts.URL)
+			response, err := tlsCrawler.Crawl(testURL)
+
+			Expect(err).To(BeNil())
+			Expect(response.TLSVersion).To(HavePrefix("TLS 1."))
+		})
+
 		It("returns an error if URL host is not in rootURLs", func() {
 			testURL, _ := url.Parse("http://www.google.com/foo")
 			response, err := crawler.Crawl(testURL)
@@ -145,7 +524,298 @@ var _ = Describe("Crawl", func() {
 			Expect(response).To(BeNil())
 		})
 
+		Describe("honouring robots.txt", func() {
+			robotsTestServer := func(robots string, robotsRequests *int) *httptest.Server {
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Path == "/robots.txt" {
+						*robotsRequests++
+						fmt.Fprintln(w, robots)
+						return
+					}
+
+					fmt.Fprintln(w, "Hello world")
+				}))
+			}
+
+			It("returns an error for URLs disallowed by robots.txt", func() {
+				robotsRequests := 0
+				ts := robotsTestServer("User-agent: *\nDisallow: /private", &robotsRequests)
+				defer ts.Close()
+
+				testURL, _ := url.Parse(ts.URL + "/private/page")
+				response, err := crawler.Crawl(testURL)
+
+				Expect(err).To(Equal(ErrDisallowedByRobots))
+				Expect(response).To(BeNil())
+			})
+
+			It("crawls URLs allowed by robots.txt", func() {
+				robotsRequests := 0
+				ts := robotsTestServer("User-agent: *\nDisallow: /private", &robotsRequests)
+				defer ts.Close()
+
+				testURL, _ := url.Parse(ts.URL + "/public")
+				response, err := crawler.Crawl(testURL)
+
+				Expect(err).To(BeNil())
+				Expect(strings.TrimSpace(string(response.Body))).To(Equal("Hello world"))
+			})
+
+			It("only fetches robots.txt once per host", func() {
+				robotsRequests := 0
+				ts := robotsTestServer("User-agent: *\nDisallow: /private", &robotsRequests)
+				defer ts.Close()
+
+				for _, path := range []string{"/a", "/b", "/private"} {
+					testURL, _ := url.Parse(ts.URL + path)
+					crawler.Crawl(testURL)
+				}
+
+				Expect(robotsRequests).To(Equal(1))
+			})
+
+			It("follows redirects to robots.txt", func() {
+				redirects := 0
+				ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if strings.HasPrefix(r.URL.Path, "/robots.txt") {
+						hop, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/robots.txt"))
+						if hop < 5 {
+							redirects++
+							http.Redirect(w, r, fmt.Sprintf("/robots.txt%d", hop+1), http.StatusMovedPermanently)
+							return
+						}
+
+						fmt.Fprintln(w, "User-agent: *\nDisallow: /private")
+						return
+					}
+
+					fmt.Fprintln(w, "Hello world")
+				}))
+				defer ts.Close()
+
+				testURL, _ := url.Parse(ts.URL + "/private/page")
+				_, err := crawler.Crawl(testURL)
+
+				Expect(err).To(Equal(ErrDisallowedByRobots))
+				Expect(redirects).To(Equal(5))
+			})
+
+			It("places no restrictions on crawling after too many robots.txt redirects", func() {
+				ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if strings.HasPrefix(r.URL.Path, "/robots.txt") {
+						http.Redirect(w, r, r.URL.Path+"x", http.StatusFound)
+						return
+					}
+
+					fmt.Fprintln(w, "Hello world")
+				}))
+				defer ts.Close()
+
+				testURL, _ := url.Parse(ts.URL + "/private/page")
+				response, err := crawler.Crawl(testURL)
+
+				Expect(err).To(BeNil())
+				Expect(strings.TrimSpace(string(response.Body))).To(Equal("Hello world"))
+			})
+
+			It("waits for the Crawl-delay between requests to the same host", func() {
+				robotsRequests := 0
+				ts := robotsTestServer("User-agent: *\nCrawl-delay: 0.2", &robotsRequests)
+				defer ts.Close()
+
+				start := time.Now()
+				for _, path := range []string{"/a", "/b"} {
+					testURL, _ := url.Parse(ts.URL + path)
+					_, err := crawler.Crawl(testURL)
+					Expect(err).To(BeNil())
+				}
+
+				Expect(time.Since(start)).To(BeNumerically(">=", 200*time.Millisecond))
+			})
+		})
+
+		Describe("making conditional requests", func() {
+			var root string
+			var conditionalTestServer *httptest.Server
+
+			BeforeEach(func() {
+				var err error
+				root, err = ioutil.TempDir("", "crawler_validators_test")
+				Expect(err).To(BeNil())
+
+				crawler.Validators = NewFileValidatorStore(root)
+
+				conditionalTestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Header.Get("If-None-Match") == `"v1"` {
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+
+					w.Header().Set("Content-Type", "text/html; charset=utf-8")
+					w.Header().Set("ETag", `"v1"`)
+					w.Header().Set("Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT")
+					fmt.Fprintln(w, "Hello world")
+				}))
+			})
+
+			AfterEach(func() {
+				conditionalTestServer.Close()
+				os.RemoveAll(root)
+			})
+
+			It("returns the validators sent with a response", func() {
+				testURL, _ := url.Parse(conditionalTestServer.URL + "/page")
+				response, err := crawler.Crawl(testURL)
+
+				Expect(err).To(BeNil())
+				Expect(response.NotModified).To(BeFalse())
+				Expect(response.Validators()).To(Equal(&Validators{
+					ETag:         `"v1"`,
+					LastModified: "Wed, 21 Oct 2015 07:28:00 GMT",
+					ContentType:  "text/html; charset=utf-8",
+				}))
+			})
+
+			It("sends stored validators and reports unchanged responses", func() {
+				testURL, _ := url.Parse(conditionalTestServer.URL + "/page")
+				crawler.Validators.Set(testURL, &Validators{ETag: `"v1"`, ContentType: HTML})
+
+				response, err := crawler.Crawl(testURL)
+
+				Expect(err).To(BeNil())
+				Expect(response.NotModified).To(BeTrue())
+				Expect(response.Body).To(BeEmpty())
+				Expect(response.ContentType).To(Equal(HTML))
+			})
+
+			It("crawls in full when the stored validators are out of date", func() {
+				testURL, _ := url.Parse(conditionalTestServer.URL + "/page")
+				crawler.Validators.Set(testURL, &Validators{ETag: `"v0"`, ContentType: HTML})
+
+				response, err := crawler.Crawl(testURL)
+
+				Expect(err).To(BeNil())
+				Expect(response.NotModified).To(BeFalse())
+				Expect(strings.TrimSpace(string(response.Body))).To(Equal("Hello world"))
+			})
+		})
+
+		Describe("limiting and streaming bodies", func() {
+			var streamDir string
+			var bodyTestServer *httptest.Server
+
+			BeforeEach(func() {
+				var err error
+				streamDir, err = ioutil.TempDir("", "crawler_stream_test")
+				Expect(err).To(BeNil())
+
+				bodyTestServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch r.URL.Path {
+					case "/page":
+						w.Header().Set("Content-Type", "text/html; charset=utf-8")
+						fmt.Fprint(w, "Hello world")
+					case "/attachment.pdf":
+						w.Header().Set("Content-Type", PDF)
+						fmt.Fprint(w, strings.Repeat("x", 100))
+					case "/chunked":
+						// Flushing before the body is complete means
+						// there's no Content-Length.
+						w.Header().Set("Content-Type", HTML)
+						for i := 0; i < 10; i++ {
+							fmt.Fprint(w, strings.Repeat("x", 10))
+							w.(http.Flusher).Flush()
+						}
+					}
+				}))
+			})
+
+			AfterEach(func() {
+				bodyTestServer.Close()
+				os.RemoveAll(streamDir)
+			})
+
+			crawlWith := func(options CrawlerOptions, path string) (*CrawlerResponse, error) {
+				bodyCrawler, err := NewCrawler(rootURLs, "0.0.0", options)
+				Expect(err).To(BeNil())
+
+				testURL, _ := url.Parse(bodyTestServer.URL + path)
+				return bodyCrawler.Crawl(testURL)
+			}
+
+			It("returns an error for bodies larger than the maximum size", func() {
+				response, err := crawlWith(CrawlerOptions{MaxBodySize: 99}, "/attachment.pdf")
+
+				Expect(err).To(Equal(ErrBodyTooLarge))
+				Expect(response).To(BeNil())
+			})
+
+			It("returns an error for bodies without a Content-Length larger than the maximum size", func() {
+				response, err := crawlWith(CrawlerOptions{MaxBodySize: 99}, "/chunked")
+
+				Expect(err).To(Equal(ErrBodyTooLarge))
+				Expect(response).To(BeNil())
+			})
+
+			It("returns bodies up to the maximum size", func() {
+				response, err := crawlWith(CrawlerOptions{MaxBodySize: 100}, "/chunked")
+
+				Expect(err).To(BeNil())
+				Expect(response.Body).To(HaveLen(100))
+			})
+
+			It("applies the maximum size for the content type", func() {
+				sizeCrawler, err := NewCrawler(rootURLs, "0.0.0", CrawlerOptions{MaxBodySize: 1000})
+				Expect(err).To(BeNil())
+				sizeCrawler.ContentTypes = NewContentTypePolicy(DefaultContentTypes, nil, map[string]int64{PDF: 50})
+
+				pdfURL, _ := url.Parse(bodyTestServer.URL + "/attachment.pdf")
+				_, err = sizeCrawler.Crawl(pdfURL)
+				Expect(err).To(Equal(ErrBodyTooLarge))
+
+				pageURL, _ := url.Parse(bodyTestServer.URL + "/chunked")
+				response, err := sizeCrawler.Crawl(pageURL)
+				Expect(err).To(BeNil())
+				Expect(response.Body).To(HaveLen(100))
+			})
+
+			It("streams bodies that aren't HTML to a file", func() {
+				response, err := crawlWith(CrawlerOptions{StreamDir: streamDir}, "/attachment.pdf")
+
+				Expect(err).To(BeNil())
+				Expect(response.Body).To(BeEmpty())
+				Expect(filepath.Dir(response.BodyFile)).To(Equal(streamDir))
+				Expect(ioutil.ReadFile(response.BodyFile)).To(Equal([]byte(strings.Repeat("x", 100))))
+
+				Expect(response.RemoveBodyFile()).To(BeNil())
+				Expect(ioutil.ReadDir(streamDir)).To(BeEmpty())
+			})
+
+			It("keeps HTML bodies in memory", func() {
+				response, err := crawlWith(CrawlerOptions{StreamDir: streamDir}, "/page")
+
+				Expect(err).To(BeNil())
+				Expect(response.BodyFile).To(BeEmpty())
+				Expect(string(response.Body)).To(Equal("Hello world"))
+			})
+
+			It("doesn't leave a file behind when a streamed body is too large", func() {
+				_, err := crawlWith(CrawlerOptions{MaxBodySize: 50, StreamDir: streamDir}, "/attachment.pdf")
+
+				Expect(err).To(Equal(ErrBodyTooLarge))
+				Expect(ioutil.ReadDir(streamDir)).To(BeEmpty())
+			})
+		})
+
 		Describe("returning a retry error", func() {
+			retryAfterTestServer := func(status int, retryAfter string) *httptest.Server {
+				return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if retryAfter != "" {
+						w.Header().Set("Retry-After", retryAfter)
+					}
+					w.WriteHeader(status)
+				}))
+			}
+
 			It("returns a retry error if we get a response code of Too Many Requests", func() {
 				ts := testServer(429, "Too Many Requests")
 				defer ts.Close()
@@ -153,7 +823,9 @@ var _ = Describe("Crawl", func() {
 				testURL, _ := url.Parse(ts.URL)
 				response, err := crawler.Crawl(testURL)
 
-				Expect(err).To(Equal(ErrRetryRequest429))
+				Expect(err).To(BeAssignableToTypeOf(&RetryError{}))
+				Expect(err.(*RetryError).Err).To(Equal(ErrRetryRequest429))
+				Expect(err.(*RetryError).BackOff()).To(BeTrue())
 				Expect(response).To(BeNil())
 			})
 
@@ -164,7 +836,9 @@ var _ = Describe("Crawl", func() {
 				testURL, _ := url.Parse(ts.URL)
 				response, err := crawler.Crawl(testURL)
 
-				Expect(err).To(Equal(ErrRetryRequest5XX))
+				Expect(err).To(BeAssignableToTypeOf(&RetryError{}))
+				Expect(err.(*RetryError).Err).To(Equal(ErrRetryRequest5XX))
+				Expect(err.(*RetryError).BackOff()).To(BeFalse())
 				Expect(response).To(BeNil())
 			})
 
@@ -175,9 +849,94 @@ var _ = Describe("Crawl", func() {
 				testURL, _ := url.Parse(ts.URL)
 				response, err := crawler.Crawl(testURL)
 
-				Expect(err).To(Equal(ErrRetryRequest5XX))
+				Expect(err).To(BeAssignableToTypeOf(&RetryError{}))
+				Expect(err.(*RetryError).Err).To(Equal(ErrRetryRequest5XX))
 				Expect(response).To(BeNil())
 			})
+
+			It("includes the delay from a Retry-After header given in seconds", func() {
+				ts := retryAfterTestServer(http.StatusServiceUnavailable, "120")
+				defer ts.Close()
+
+				testURL, _ := url.Parse(ts.URL)
+				_, err := crawler.Crawl(testURL)
+
+				Expect(err.(*RetryError).Err).To(Equal(ErrRetryRequest5XX))
+				Expect(err.(*RetryError).StatusCode).To(Equal(http.StatusServiceUnavailable))
+				Expect(err.(*RetryError).BackOff()).To(BeTrue())
+				Expect(err.(*RetryError).RetryAfter).To(Equal(120 * time.Second))
+			})
+
+			It("includes the delay from a Retry-After header given as an HTTP-date", func() {
+				retryAt := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+				ts := retryAfterTestServer(429, retryAt)
+				defer ts.Close()
+
+				testURL, _ := url.Parse(ts.URL)
+				_, err := crawler.Crawl(testURL)
+
+				Expect(err.(*RetryError).RetryAfter).To(BeNumerically("~", time.Hour, 5*time.Second))
+			})
+		})
+	})
+
+	Describe("Crawler.Fetch()", func() {
+		It("returns the decoded body of a URL", func() {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+
+				writer := gzip.NewWriter(w)
+				fmt.Fprint(writer, "<urlset></urlset>")
+				writer.Close()
+			}))
+			defer ts.Close()
+
+			testURL, _ := url.Parse(ts.URL + "/sitemap.xml")
+			body, err := crawler.Fetch(testURL)
+
+			Expect(err).To(BeNil())
+			Expect(string(body)).To(Equal("<urlset></urlset>"))
+		})
+
+		It("returns an error for responses that aren't successful", func() {
+			ts := testServer(http.StatusForbidden, "Forbidden")
+			defer ts.Close()
+
+			testURL, _ := url.Parse(ts.URL + "/sitemap.xml")
+			_, err := crawler.Fetch(testURL)
+			Expect(err).To(MatchError(ContainSubstring("403 Forbidden")))
+
+			missing := testServer(http.StatusNotFound, "Not Found")
+			defer missing.Close()
+
+			testURL, _ = url.Parse(missing.URL + "/sitemap.xml")
+			_, err = crawler.Fetch(testURL)
+			Expect(err).To(Equal(ErrNotFound))
+		})
+
+		It("returns an error if URL host is not in rootURLs", func() {
+			testURL, _ := url.Parse("http://www.google.com/sitemap.xml")
+			_, err := crawler.Fetch(testURL)
+
+			Expect(err).To(Equal(ErrCannotCrawlURL))
+		})
+	})
+
+	Describe("ParseRetryAfter", func() {
+		now := time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC)
+
+		It("parses a number of seconds", func() {
+			Expect(ParseRetryAfter("30", now)).To(Equal(30 * time.Second))
+		})
+
+		It("parses an HTTP-date", func() {
+			Expect(ParseRetryAfter("Wed, 21 Oct 2015 07:30:00 GMT", now)).To(Equal(2 * time.Minute))
+		})
+
+		It("returns zero for missing, invalid or past values", func() {
+			for _, value := range []string{"", "soon", "-5", "Wed, 21 Oct 2015 07:00:00 GMT"} {
+				Expect(ParseRetryAfter(value, now)).To(BeZero())
+			}
 		})
 	})
 
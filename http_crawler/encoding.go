@@ -0,0 +1,65 @@
+package http_crawler
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/dsnet/compress/brotli"
+)
+
+// The content encodings we ask for, and can decode.
+const acceptEncoding = "gzip, deflate, br"
+
+// decodeBody returns a reader of the body of resp with any Content-Encoding
+// removed. Asking for an encoding ourselves stops net/http from decoding
+// gzip for us, so every body read needs to go through this.
+func decodeBody(resp *http.Response) (io.Reader, error) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+
+	switch encoding {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(resp.Body)
+	case "deflate":
+		return newDeflateReader(resp.Body)
+	case "br":
+		return brotli.NewReader(resp.Body, nil)
+	}
+
+	return nil, fmt.Errorf("Unsupported Content-Encoding: %s", encoding)
+}
+
+// Deflate should be zlib wrapped, but some servers send raw deflate data,
+// so the zlib header is checked for first.
+func newDeflateReader(body io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(body)
+
+	header, err := buffered.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+
+	return flate.NewReader(buffered), nil
+}
+
+// decodedContentLength returns the length of the body of resp once it's been
+// decoded, or -1 if that isn't known until it's been read.
+func decodedContentLength(resp *http.Response) int64 {
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return resp.ContentLength
+	}
+
+	return -1
+}
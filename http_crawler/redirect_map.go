@@ -0,0 +1,191 @@
+package http_crawler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Formats a RedirectMap can be written in.
+const (
+	// NginxRedirectMap is for inclusion in an nginx map block, e.g.
+	//
+	//	map $host$request_uri $redirect_destination {
+	//	    include /path/to/redirects.map;
+	//	}
+	NginxRedirectMap = "nginx"
+	// ApacheRedirectMap is a text RewriteMap, e.g.
+	//
+	//	RewriteMap redirects "txt:/path/to/redirects.map"
+	//	RewriteCond ${redirects:%{SERVER_NAME}%{REQUEST_URI}} (.+)
+	//	RewriteRule ^ %1 [R=301,L]
+	ApacheRedirectMap = "apache"
+)
+
+// RedirectMap is a file mapping the host and request URI of redirected URLs,
+// such as `www.gov.uk/foo?bar=baz`, to their eventual destinations, which
+// the web server in front of a static mirror can load to serve real
+// redirects instead of redirect pages. Hosts are lower case and have no
+// port. Destinations on the same host are written as request URIs, others
+// as absolute URLs.
+type RedirectMap struct {
+	path   string
+	format string
+
+	mutex   sync.Mutex
+	entries map[string]string
+}
+
+// NewRedirectMap returns a RedirectMap writing to path in the given format,
+// keeping any entries already in the file.
+func NewRedirectMap(path string, format string) (*RedirectMap, error) {
+	if format != NginxRedirectMap && format != ApacheRedirectMap {
+		return nil, fmt.Errorf("Unknown redirect map format: %s", format)
+	}
+
+	redirectMap := &RedirectMap{
+		path:    path,
+		format:  format,
+		entries: make(map[string]string),
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return redirectMap, nil
+		}
+
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(strings.TrimSuffix(strings.TrimSpace(scanner.Text()), ";"))
+		// Entries without a host, from before they had one, would
+		// never match so are dropped the next time the file's
+		// rewritten.
+		if len(fields) == 2 && !strings.HasPrefix(fields[0], "/") {
+			redirectMap.entries[fields[0]] = fields[1]
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return redirectMap, nil
+}
+
+// Add maps every URL in a redirect chain straight to the chain's final
+// destination. URLs that can't be written safely in the file, for example
+// because they contain whitespace or quotes, are left out so that they fall
+// back on the redirect page.
+func (m *RedirectMap) Add(redirects []Redirect) error {
+	if len(redirects) == 0 {
+		return nil
+	}
+
+	destination := redirects[len(redirects)-1].To
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	added := []string{}
+	changed := false
+
+	for _, redirect := range redirects {
+		source := strings.ToLower(redirect.From.Hostname()) + redirect.From.RequestURI()
+		target := destination.String()
+		if destination.Scheme == redirect.From.Scheme && destination.Host == redirect.From.Host {
+			target = destination.RequestURI()
+		}
+
+		if !mappable(source) || !mappable(target) {
+			continue
+		}
+
+		existing, ok := m.entries[source]
+		switch {
+		case !ok:
+			added = append(added, source)
+		case existing != target:
+			changed = true
+		default:
+			continue
+		}
+
+		m.entries[source] = target
+	}
+
+	// New entries are appended, but a changed entry means rewriting the
+	// whole file so that it doesn't end up with conflicting entries.
+	if changed {
+		return m.rewrite()
+	}
+
+	if len(added) == 0 {
+		return nil
+	}
+
+	file, err := os.OpenFile(m.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = m.writeEntries(file, added)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func (m *RedirectMap) rewrite() error {
+	sources := make([]string, 0, len(m.entries))
+	for source := range m.entries {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	tempPath := m.path + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+
+	err = m.writeEntries(file, sources)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	return os.Rename(tempPath, m.path)
+}
+
+func (m *RedirectMap) writeEntries(w io.Writer, sources []string) error {
+	line := "%s %s\n"
+	if m.format == NginxRedirectMap {
+		line = "%s %s;\n"
+	}
+
+	for _, source := range sources {
+		if _, err := fmt.Fprintf(w, line, source, m.entries[source]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func mappable(value string) bool {
+	return value != "" && !strings.ContainsAny(value, " \t\r\n;\"'{}\\#")
+}
@@ -0,0 +1,126 @@
+package http_crawler_test
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	. "github.com/alphagov/govuk_crawler_worker/http_crawler"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RedirectMap", func() {
+	var root, mapPath string
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "redirect_map_test")
+		Expect(err).To(BeNil())
+
+		mapPath = filepath.Join(root, "redirects.map")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
+	})
+
+	redirect := func(from string, to string) Redirect {
+		fromURL, _ := url.Parse(from)
+		toURL, _ := url.Parse(to)
+
+		return Redirect{From: fromURL, To: toURL, StatusCode: 301}
+	}
+
+	readMap := func() string {
+		content, err := ioutil.ReadFile(mapPath)
+		Expect(err).To(BeNil())
+
+		return string(content)
+	}
+
+	It("maps every URL in a chain to its final destination in nginx format", func() {
+		redirectMap, err := NewRedirectMap(mapPath, NginxRedirectMap)
+		Expect(err).To(BeNil())
+
+		Expect(redirectMap.Add([]Redirect{
+			redirect("https://www.gov.uk/a?b=c", "https://www.gov.uk/b"),
+			redirect("https://www.gov.uk/b", "https://www.gov.uk/c"),
+		})).To(BeNil())
+
+		Expect(readMap()).To(Equal("www.gov.uk/a?b=c /c;\nwww.gov.uk/b /c;\n"))
+	})
+
+	It("writes Apache format and absolute URLs for other hosts", func() {
+		redirectMap, err := NewRedirectMap(mapPath, ApacheRedirectMap)
+		Expect(err).To(BeNil())
+
+		Expect(redirectMap.Add([]Redirect{
+			redirect("https://www.gov.uk/a", "https://example.com/b"),
+		})).To(BeNil())
+
+		Expect(readMap()).To(Equal("www.gov.uk/a https://example.com/b\n"))
+	})
+
+	It("doesn't repeat entries, including ones already in the file", func() {
+		Expect(ioutil.WriteFile(mapPath, []byte("www.gov.uk/a /b;\n"), 0644)).To(BeNil())
+
+		redirectMap, err := NewRedirectMap(mapPath, NginxRedirectMap)
+		Expect(err).To(BeNil())
+
+		Expect(redirectMap.Add([]Redirect{redirect("https://www.gov.uk/a", "https://www.gov.uk/b")})).To(BeNil())
+		Expect(redirectMap.Add([]Redirect{redirect("https://www.gov.uk/c", "https://www.gov.uk/d")})).To(BeNil())
+		Expect(redirectMap.Add([]Redirect{redirect("https://www.gov.uk/c", "https://www.gov.uk/d")})).To(BeNil())
+
+		Expect(readMap()).To(Equal("www.gov.uk/a /b;\nwww.gov.uk/c /d;\n"))
+	})
+
+	It("replaces entries whose destination has changed", func() {
+		redirectMap, err := NewRedirectMap(mapPath, NginxRedirectMap)
+		Expect(err).To(BeNil())
+
+		Expect(redirectMap.Add([]Redirect{redirect("https://www.gov.uk/z", "https://www.gov.uk/y")})).To(BeNil())
+		Expect(redirectMap.Add([]Redirect{redirect("https://www.gov.uk/a", "https://www.gov.uk/b")})).To(BeNil())
+		Expect(redirectMap.Add([]Redirect{redirect("https://www.gov.uk/a", "https://www.gov.uk/c")})).To(BeNil())
+
+		Expect(readMap()).To(Equal("www.gov.uk/a /c;\nwww.gov.uk/z /y;\n"))
+	})
+
+	It("keeps the redirects of each host apart", func() {
+		redirectMap, err := NewRedirectMap(mapPath, NginxRedirectMap)
+		Expect(err).To(BeNil())
+
+		Expect(redirectMap.Add([]Redirect{redirect("https://www.gov.uk/a", "https://www.gov.uk/b")})).To(BeNil())
+		Expect(redirectMap.Add([]Redirect{redirect("https://Assets.gov.uk:8443/a", "https://Assets.gov.uk:8443/c")})).To(BeNil())
+
+		Expect(readMap()).To(Equal("www.gov.uk/a /b;\nassets.gov.uk/a /c;\n"))
+	})
+
+	It("drops entries without a host when it rewrites the file", func() {
+		Expect(ioutil.WriteFile(mapPath, []byte("/a /b;\nwww.gov.uk/a /b;\n"), 0644)).To(BeNil())
+
+		redirectMap, err := NewRedirectMap(mapPath, NginxRedirectMap)
+		Expect(err).To(BeNil())
+
+		Expect(redirectMap.Add([]Redirect{redirect("https://www.gov.uk/a", "https://www.gov.uk/c")})).To(BeNil())
+
+		Expect(readMap()).To(Equal("www.gov.uk/a /c;\n"))
+	})
+
+	It("leaves out URLs that can't be written safely", func() {
+		redirectMap, err := NewRedirectMap(mapPath, NginxRedirectMap)
+		Expect(err).To(BeNil())
+
+		Expect(redirectMap.Add([]Redirect{redirect("https://www.gov.uk/a?b=c;d", "https://www.gov.uk/e")})).To(BeNil())
+
+		_, err = os.Stat(mapPath)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("returns an error for unknown formats", func() {
+		_, err := NewRedirectMap(mapPath, "lighttpd")
+		Expect(err).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,150 @@
+package http_crawler
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// ResponseMetadata describes the response a CrawlerResponse was built from
+// and how long it took to fetch.
+type ResponseMetadata struct {
+	StatusCode int         `json:"status_code"`
+	Proto      string      `json:"proto,omitempty"`
+	Header     http.Header `json:"header"`
+	// RequestHeader is what was sent with the request, which includes
+	// credentials and so is never serialised.
+	RequestHeader http.Header `json:"-"`
+	// StartedAt is when the request was made.
+	StartedAt time.Time `json:"started_at"`
+	// ContentLength is the number of bytes of body received, or -1 if
+	// the body wasn't read and the server didn't give a length.
+	ContentLength int64  `json:"content_length"`
+	RemoteIP      string `json:"remote_ip,omitempty"`
+	TLSVersion    string `json:"tls_version,omitempty"`
+	Timing        Timing `json:"timing"`
+
+	// DeclaredContentType is the Content-Type header sent by the server
+	// and SniffedContentType is what the body looks like.
+	// ContentTypeMismatch is true when the body contradicts the header.
+	DeclaredContentType string `json:"declared_content_type,omitempty"`
+	SniffedContentType  string `json:"sniffed_content_type,omitempty"`
+	ContentTypeMismatch bool   `json:"content_type_mismatch,omitempty"`
+}
+
+// Timing breaks down the time taken by a request. Phases which didn't
+// happen, such as connecting when a kept-alive connection was reused, are
+// zero.
+type Timing struct {
+	DNSLookup time.Duration `json:"dns_lookup"`
+	Connect   time.Duration `json:"connect"`
+	// FirstByte is the time from starting the request to receiving the
+	// first byte of the response.
+	FirstByte time.Duration `json:"first_byte"`
+	Total     time.Duration `json:"total"`
+}
+
+// requestTimer collects ResponseMetadata for a request using the hooks
+// provided by net/http/httptrace.
+type requestTimer struct {
+	start time.Time
+
+	mutex        sync.Mutex
+	dnsStart     time.Time
+	connectStart time.Time
+	timing       Timing
+	remoteIP     string
+}
+
+func newRequestTimer() *requestTimer {
+	return &requestTimer{start: time.Now()}
+}
+
+// trace adds the timer's hooks to a request.
+func (t *requestTimer) trace(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mutex.Lock()
+			t.dnsStart = time.Now()
+			t.mutex.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mutex.Lock()
+			t.timing.DNSLookup = time.Since(t.dnsStart)
+			t.mutex.Unlock()
+		},
+		ConnectStart: func(network, addr string) {
+			t.mutex.Lock()
+			t.connectStart = time.Now()
+			t.mutex.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mutex.Lock()
+			t.timing.Connect = time.Since(t.connectStart)
+			t.mutex.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			host, _, err := net.SplitHostPort(info.Conn.RemoteAddr().String())
+			if err != nil {
+				return
+			}
+
+			t.mutex.Lock()
+			t.remoteIP = host
+			t.mutex.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mutex.Lock()
+			t.timing.FirstByte = time.Since(t.start)
+			t.mutex.Unlock()
+		},
+	}
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// metadata returns the metadata of resp, which should be called once its
+// body has been read so that the total time includes reading it.
+func (t *requestTimer) metadata(resp *http.Response, contentLength int64) ResponseMetadata {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	metadata := ResponseMetadata{
+		StatusCode:    resp.StatusCode,
+		Proto:         resp.Proto,
+		Header:        resp.Header,
+		RequestHeader: resp.Request.Header,
+		StartedAt:     t.start,
+		ContentLength: contentLength,
+		RemoteIP:      t.remoteIP,
+		Timing:        t.timing,
+	}
+	metadata.Timing.Total = time.Since(t.start)
+
+	if resp.TLS != nil {
+		metadata.TLSVersion = tlsVersionName(resp.TLS.Version)
+	}
+
+	return metadata
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionSSL30:
+		return "SSL 3.0"
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+
+	return fmt.Sprintf("0x%04x", version)
+}
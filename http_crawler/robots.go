@@ -0,0 +1,183 @@
+package http_crawler
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RobotsTxtTTL is how long a parsed robots.txt file is cached before it's
+// fetched again.
+const RobotsTxtTTL = 24 * time.Hour
+
+type robotsRule struct {
+	allow   bool
+	length  int
+	pattern *regexp.Regexp
+}
+
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// RobotsTxt holds the rules of a robots.txt file that apply to a single user
+// agent.
+type RobotsTxt struct {
+	CrawlDelay time.Duration
+
+	rules []robotsRule
+}
+
+// ParseRobotsTxt parses the body of a robots.txt file and returns the rules
+// for the group that best matches userAgent. Groups naming the product
+// token of userAgent, the part before any version, take precedence over the
+// `*` group. Product tokens are compared case-insensitively, as RFC 9309
+// asks. An error is returned rather than rules being dropped if the body
+// can't be read in full.
+func ParseRobotsTxt(body []byte, userAgent string) (*RobotsTxt, error) {
+	var groups []*robotsGroup
+	var current *robotsGroup
+	inAgentLines := false
+
+	// Lines may be as long as the whole body, rather than bufio's default
+	// limit of 64KB.
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, len(body)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		switch key {
+		case "user-agent":
+			if !inAgentLines {
+				current = &robotsGroup{}
+				groups = append(groups, current)
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+			inAgentLines = true
+		case "allow", "disallow":
+			inAgentLines = false
+			if current == nil || value == "" {
+				continue
+			}
+			current.rules = append(current.rules, robotsRule{
+				allow:   key == "allow",
+				length:  len(value),
+				pattern: compileRobotsPattern(value),
+			})
+		case "crawl-delay":
+			inAgentLines = false
+			if current == nil {
+				continue
+			}
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err == nil && seconds > 0 {
+				current.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return selectRobotsGroups(groups, userAgent), nil
+}
+
+// Allowed reports whether the path (including any query string) may be
+// crawled. The longest matching pattern wins, and an Allow rule wins a tie
+// with a Disallow rule of the same length.
+func (r *RobotsTxt) Allowed(path string) bool {
+	if path == "/robots.txt" {
+		return true
+	}
+
+	allowed := true
+	matchLength := -1
+
+	for _, rule := range r.rules {
+		if !rule.pattern.MatchString(path) {
+			continue
+		}
+
+		if rule.length > matchLength || (rule.length == matchLength && rule.allow) {
+			allowed = rule.allow
+			matchLength = rule.length
+		}
+	}
+
+	return allowed
+}
+
+func selectRobotsGroups(groups []*robotsGroup, userAgent string) *RobotsTxt {
+	product := robotsProductToken(userAgent)
+
+	var specific, wildcard []*robotsGroup
+	for _, group := range groups {
+		for _, agent := range group.agents {
+			if agent == "*" {
+				wildcard = append(wildcard, group)
+				break
+			}
+			if agent != "" && agent == product {
+				specific = append(specific, group)
+				break
+			}
+		}
+	}
+
+	matched := wildcard
+	if len(specific) > 0 {
+		matched = specific
+	}
+
+	robots := &RobotsTxt{}
+	for _, group := range matched {
+		robots.rules = append(robots.rules, group.rules...)
+		if group.crawlDelay > robots.CrawlDelay {
+			robots.CrawlDelay = group.crawlDelay
+		}
+	}
+
+	return robots
+}
+
+// robotsProductToken returns the lower case product token of a User-Agent
+// header, such as `googlebot` for `Googlebot/2.1 (+http://www.google.com/bot.html)`.
+func robotsProductToken(userAgent string) string {
+	return strings.ToLower(strings.TrimSpace(strings.SplitN(userAgent, "/", 2)[0]))
+}
+
+// Robots.txt patterns are path prefixes where `*` matches any sequence of
+// characters and a trailing `$` anchors the match to the end of the path.
+func compileRobotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	pieces := strings.Split(pattern, "*")
+	for i, piece := range pieces {
+		pieces[i] = regexp.QuoteMeta(piece)
+	}
+
+	expr := "^" + strings.Join(pieces, ".*")
+	if anchored {
+		expr += "$"
+	}
+
+	return regexp.MustCompile(expr)
+}
@@ -0,0 +1,134 @@
+package http_crawler_test
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/alphagov/govuk_crawler_worker/http_crawler"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RobotsTxt", func() {
+	userAgent := "GOVUKCrawlerWorker/0.0.0 (GOV.UK Crawler Worker on host 'foo')"
+
+	Describe("ParseRobotsTxt", func() {
+		It("allows everything when there are no rules", func() {
+			robots, err := ParseRobotsTxt([]byte(""), userAgent)
+			Expect(err).To(BeNil())
+
+			Expect(robots.Allowed("/")).To(BeTrue())
+			Expect(robots.Allowed("/foo/bar")).To(BeTrue())
+			Expect(robots.CrawlDelay).To(BeZero())
+		})
+
+		It("uses the wildcard group when no group names our user agent", func() {
+			robots, err := ParseRobotsTxt([]byte(`
+User-agent: SomeOtherBot
+Disallow: /
+
+User-agent: *
+Disallow: /private # comments are ignored
+`), userAgent)
+			Expect(err).To(BeNil())
+
+			Expect(robots.Allowed("/")).To(BeTrue())
+			Expect(robots.Allowed("/private/page")).To(BeFalse())
+		})
+
+		It("prefers a group naming our user agent over the wildcard group", func() {
+			robots, err := ParseRobotsTxt([]byte(`
+User-agent: *
+Disallow: /
+
+User-agent: Googlebot
+User-agent: govukcrawlerworker
+Disallow: /search
+Crawl-delay: 1.5
+`), userAgent)
+			Expect(err).To(BeNil())
+
+			Expect(robots.Allowed("/")).To(BeTrue())
+			Expect(robots.Allowed("/search?q=tax")).To(BeFalse())
+			Expect(robots.CrawlDelay).To(Equal(1500 * time.Millisecond))
+		})
+
+		It("only applies groups naming our whole product token", func() {
+			robots, err := ParseRobotsTxt([]byte(`
+User-agent: GOVUK
+User-agent: CrawlerWorker
+User-agent: GOVUKCrawler
+Disallow: /
+
+User-agent: *
+Disallow: /private
+`), userAgent)
+			Expect(err).To(BeNil())
+
+			Expect(robots.Allowed("/")).To(BeTrue())
+			Expect(robots.Allowed("/private")).To(BeFalse())
+		})
+
+		It("lets the longest matching rule win, with Allow winning ties", func() {
+			robots, err := ParseRobotsTxt([]byte(`
+User-agent: *
+Disallow: /government/uploads
+Allow: /government/uploads/system
+Disallow: /tie
+Allow: /tie
+`), userAgent)
+			Expect(err).To(BeNil())
+
+			Expect(robots.Allowed("/government/uploads/foo")).To(BeFalse())
+			Expect(robots.Allowed("/government/uploads/system/foo.pdf")).To(BeTrue())
+			Expect(robots.Allowed("/tie")).To(BeTrue())
+		})
+
+		It("supports * wildcards and $ anchors", func() {
+			robots, err := ParseRobotsTxt([]byte(`
+User-agent: *
+Disallow: /*.csv$
+Disallow: /*/print
+`), userAgent)
+			Expect(err).To(BeNil())
+
+			Expect(robots.Allowed("/data/file.csv")).To(BeFalse())
+			Expect(robots.Allowed("/data/file.csv?preview=1")).To(BeTrue())
+			Expect(robots.Allowed("/guidance/foo/print")).To(BeFalse())
+			Expect(robots.Allowed("/print")).To(BeTrue())
+		})
+
+		It("ignores empty Disallow lines", func() {
+			robots, err := ParseRobotsTxt([]byte(`
+User-agent: *
+Disallow:
+`), userAgent)
+			Expect(err).To(BeNil())
+
+			Expect(robots.Allowed("/foo")).To(BeTrue())
+		})
+
+		It("keeps the rules after a line longer than 64KB", func() {
+			robots, err := ParseRobotsTxt([]byte(`
+User-agent: *
+Disallow: /`+strings.Repeat("a", 70*1024)+`
+Disallow: /private
+`), userAgent)
+			Expect(err).To(BeNil())
+
+			Expect(robots.Allowed("/private")).To(BeFalse())
+		})
+
+		It("always allows robots.txt itself", func() {
+			robots, err := ParseRobotsTxt([]byte(`
+User-agent: *
+Disallow: /
+`), userAgent)
+			Expect(err).To(BeNil())
+
+			Expect(robots.Allowed("/robots.txt")).To(BeTrue())
+			Expect(robots.Allowed("/foo")).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,258 @@
+package http_crawler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultInitialBackoff = 5 * time.Second
+	DefaultMaxBackoff     = 10 * time.Minute
+)
+
+// HostLimit is the politeness policy applied to requests to a single host.
+type HostLimit struct {
+	// MaxInFlight is the maximum number of concurrent requests to the
+	// host. Zero means there is no per-host limit.
+	MaxInFlight int
+	// Delay is the minimum time between the start of consecutive requests
+	// to the host.
+	Delay time.Duration
+}
+
+// Scheduler limits how many requests are made at once, both overall and to
+// each host, and spaces out requests to the same host. Waiting on a busy
+// host doesn't take up one of the overall slots, so a slow host can't
+// starve the others.
+type Scheduler struct {
+	// InitialBackoff is how long a host is paused for the first time it
+	// asks us to back off without saying for how long.
+	InitialBackoff time.Duration
+	// MaxBackoff is the longest a host will be paused for.
+	MaxBackoff time.Duration
+
+	inFlight     chan struct{}
+	defaultLimit HostLimit
+	hostLimits   map[string]HostLimit
+
+	mutex sync.Mutex
+	hosts map[string]*hostSchedule
+}
+
+type hostSchedule struct {
+	inFlight chan struct{}
+	delay    time.Duration
+
+	mutex       sync.Mutex
+	nextRequest time.Time
+	pausedUntil time.Time
+	backoffs    uint
+}
+
+// NewScheduler returns a Scheduler allowing at most maxInFlight concurrent
+// requests in total (zero means no limit). Hosts in hostLimits use their own
+// limit, all other hosts use defaultLimit.
+func NewScheduler(maxInFlight int, defaultLimit HostLimit, hostLimits map[string]HostLimit) *Scheduler {
+	scheduler := &Scheduler{
+		InitialBackoff: DefaultInitialBackoff,
+		MaxBackoff:     DefaultMaxBackoff,
+
+		defaultLimit: defaultLimit,
+		hostLimits:   make(map[string]HostLimit),
+		hosts:        make(map[string]*hostSchedule),
+	}
+
+	if maxInFlight > 0 {
+		scheduler.inFlight = make(chan struct{}, maxInFlight)
+	}
+
+	for host, limit := range hostLimits {
+		scheduler.hostLimits[strings.ToLower(host)] = limit
+	}
+
+	return scheduler
+}
+
+// Acquire blocks until a request may be made to host without breaking any
+// limits, and returns a function which must be called once the request has
+// finished, and which does nothing if it's called again. minDelay raises
+// the host's Delay for this request, which is how a robots.txt Crawl-delay
+// is honoured.
+func (s *Scheduler) Acquire(host string, minDelay time.Duration) (release func()) {
+	schedule := s.hostSchedule(host)
+
+	if schedule.inFlight != nil {
+		schedule.inFlight <- struct{}{}
+	}
+
+	delay := schedule.delay
+	if minDelay > delay {
+		delay = minDelay
+	}
+
+	for {
+		// Reserve the next slot before sleeping so that concurrent
+		// callers queue up behind each other.
+		schedule.mutex.Lock()
+		now := time.Now()
+		start := now
+		if schedule.pausedUntil.After(start) {
+			start = schedule.pausedUntil
+		}
+		if schedule.nextRequest.After(start) {
+			start = schedule.nextRequest
+		}
+		schedule.nextRequest = start.Add(delay)
+		schedule.mutex.Unlock()
+
+		time.Sleep(start.Sub(now))
+
+		// The host may have been paused while we were waiting.
+		schedule.mutex.Lock()
+		paused := schedule.pausedUntil.After(time.Now())
+		schedule.mutex.Unlock()
+
+		if !paused {
+			break
+		}
+	}
+
+	if s.inFlight != nil {
+		s.inFlight <- struct{}{}
+	}
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			if s.inFlight != nil {
+				<-s.inFlight
+			}
+			if schedule.inFlight != nil {
+				<-schedule.inFlight
+			}
+		})
+	}
+}
+
+// Backoff pauses all requests to host for retryAfter or, if that's zero, for
+// a period starting at InitialBackoff and doubling each time the host asks
+// us to back off again. Returns how long the host is paused for.
+func (s *Scheduler) Backoff(host string, retryAfter time.Duration) time.Duration {
+	schedule := s.hostSchedule(host)
+
+	schedule.mutex.Lock()
+	defer schedule.mutex.Unlock()
+
+	now := time.Now()
+	pause := retryAfter
+
+	if pause <= 0 {
+		// Requests already in flight when the host was paused don't
+		// increase the backoff further.
+		if schedule.pausedUntil.After(now) {
+			return schedule.pausedUntil.Sub(now)
+		}
+
+		pause = s.MaxBackoff
+		if schedule.backoffs < 16 {
+			pause = s.InitialBackoff << schedule.backoffs
+		}
+		schedule.backoffs++
+	}
+
+	if pause > s.MaxBackoff {
+		pause = s.MaxBackoff
+	}
+
+	if until := now.Add(pause); until.After(schedule.pausedUntil) {
+		schedule.pausedUntil = until
+	}
+
+	return schedule.pausedUntil.Sub(now)
+}
+
+// ResetBackoff resets the exponential backoff for host, and should be called
+// once it responds normally again.
+func (s *Scheduler) ResetBackoff(host string) {
+	schedule := s.hostSchedule(host)
+
+	schedule.mutex.Lock()
+	schedule.backoffs = 0
+	schedule.mutex.Unlock()
+}
+
+// Hosts are scheduled separately per port, but a limit configured for a
+// hostname without a port applies to every port on that host.
+func (s *Scheduler) hostSchedule(host string) *hostSchedule {
+	key := strings.ToLower(host)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	schedule, ok := s.hosts[key]
+	if !ok {
+		limit, ok := s.hostLimits[key]
+		if !ok {
+			hostname, _ := HostOnly(key)
+			limit, ok = s.hostLimits[hostname]
+		}
+		if !ok {
+			limit = s.defaultLimit
+		}
+
+		schedule = &hostSchedule{delay: limit.Delay}
+		if limit.MaxInFlight > 0 {
+			schedule.inFlight = make(chan struct{}, limit.MaxInFlight)
+		}
+
+		s.hosts[key] = schedule
+	}
+
+	return schedule
+}
+
+// ParseHostLimits parses a comma separated list of per-host limits in the
+// form `host=maxInFlight:delay`, for example:
+//
+//	www.gov.uk=4:250ms,assets.publishing.service.gov.uk=8:0s
+func ParseHostLimits(limits string) (map[string]HostLimit, error) {
+	hostLimits := make(map[string]HostLimit)
+
+	for _, entry := range strings.Split(limits, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		hostAndLimit := strings.SplitN(entry, "=", 2)
+		if len(hostAndLimit) != 2 {
+			return nil, fmt.Errorf("Invalid host limit (expected host=maxInFlight:delay): %s", entry)
+		}
+
+		maxAndDelay := strings.SplitN(hostAndLimit[1], ":", 2)
+		if len(maxAndDelay) != 2 {
+			return nil, fmt.Errorf("Invalid host limit (expected host=maxInFlight:delay): %s", entry)
+		}
+
+		maxInFlight, err := strconv.Atoi(maxAndDelay[0])
+		if err != nil || maxInFlight < 0 {
+			return nil, fmt.Errorf("Invalid maximum in-flight requests for host %s: %s", hostAndLimit[0], maxAndDelay[0])
+		}
+
+		delay, err := time.ParseDuration(maxAndDelay[1])
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf("Invalid delay for host %s: %s", hostAndLimit[0], maxAndDelay[1])
+		}
+
+		hostLimits[hostAndLimit[0]] = HostLimit{
+			MaxInFlight: maxInFlight,
+			Delay:       delay,
+		}
+	}
+
+	return hostLimits, nil
+}
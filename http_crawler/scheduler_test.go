@@ -0,0 +1,207 @@
+package http_crawler_test
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/alphagov/govuk_crawler_worker/http_crawler"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scheduler", func() {
+	// Runs n concurrent requests to host through the scheduler, each
+	// taking the given duration, and returns the peak number in flight.
+	peakInFlight := func(scheduler *Scheduler, hosts []string, duration time.Duration) int {
+		var mutex sync.Mutex
+		var wg sync.WaitGroup
+		inFlight, peak := 0, 0
+
+		for _, host := range hosts {
+			wg.Add(1)
+			go func(host string) {
+				defer wg.Done()
+
+				release := scheduler.Acquire(host, 0)
+				defer release()
+
+				mutex.Lock()
+				inFlight++
+				if inFlight > peak {
+					peak = inFlight
+				}
+				mutex.Unlock()
+
+				time.Sleep(duration)
+
+				mutex.Lock()
+				inFlight--
+				mutex.Unlock()
+			}(host)
+		}
+
+		wg.Wait()
+
+		return peak
+	}
+
+	It("doesn't limit anything by default", func() {
+		scheduler := NewScheduler(0, HostLimit{}, nil)
+		hosts := []string{"a", "a", "a", "a", "a"}
+
+		Expect(peakInFlight(scheduler, hosts, 50*time.Millisecond)).To(Equal(5))
+	})
+
+	It("limits the number of requests in flight to a host", func() {
+		scheduler := NewScheduler(0, HostLimit{MaxInFlight: 2}, nil)
+		hosts := []string{"a", "a", "a", "a", "a"}
+
+		Expect(peakInFlight(scheduler, hosts, 20*time.Millisecond)).To(Equal(2))
+	})
+
+	It("limits the total number of requests in flight", func() {
+		scheduler := NewScheduler(3, HostLimit{}, nil)
+		hosts := []string{"a", "b", "c", "d", "e"}
+
+		Expect(peakInFlight(scheduler, hosts, 20*time.Millisecond)).To(Equal(3))
+	})
+
+	It("only releases a slot once however often it's released", func() {
+		scheduler := NewScheduler(1, HostLimit{}, nil)
+
+		release := scheduler.Acquire("a", 0)
+		release()
+		release()
+
+		scheduler.Acquire("b", 0)
+		acquired := make(chan bool)
+		go func() {
+			scheduler.Acquire("c", 0)
+			acquired <- true
+		}()
+
+		Consistently(acquired, 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("applies host specific limits in preference to the default", func() {
+		scheduler := NewScheduler(0, HostLimit{MaxInFlight: 1}, map[string]HostLimit{
+			"WWW.GOV.UK": {MaxInFlight: 3},
+		})
+
+		Expect(peakInFlight(scheduler, []string{"www.gov.uk", "www.gov.uk", "www.gov.uk"}, 20*time.Millisecond)).To(Equal(3))
+		Expect(peakInFlight(scheduler, []string{"www.gov.uk:443", "www.gov.uk:443", "www.gov.uk:443"}, 20*time.Millisecond)).To(Equal(3))
+		Expect(peakInFlight(scheduler, []string{"other", "other", "other"}, 20*time.Millisecond)).To(Equal(1))
+	})
+
+	It("spaces out requests to the same host by the delay", func() {
+		scheduler := NewScheduler(0, HostLimit{Delay: 50 * time.Millisecond}, nil)
+
+		start := time.Now()
+		peakInFlight(scheduler, []string{"a", "a", "a"}, 0)
+
+		Expect(time.Since(start)).To(BeNumerically(">=", 100*time.Millisecond))
+	})
+
+	It("uses a larger minimum delay when one is requested", func() {
+		scheduler := NewScheduler(0, HostLimit{Delay: time.Millisecond}, nil)
+
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			scheduler.Acquire("a", 50*time.Millisecond)()
+		}
+
+		Expect(time.Since(start)).To(BeNumerically(">=", 100*time.Millisecond))
+	})
+
+	It("doesn't delay requests to other hosts", func() {
+		scheduler := NewScheduler(0, HostLimit{Delay: time.Second}, nil)
+
+		scheduler.Acquire("a", 0)()
+
+		start := time.Now()
+		scheduler.Acquire("b", 0)()
+
+		Expect(time.Since(start)).To(BeNumerically("<", 100*time.Millisecond))
+	})
+
+	Describe("Backoff", func() {
+		It("pauses requests to the host for the Retry-After delay", func() {
+			scheduler := NewScheduler(0, HostLimit{}, nil)
+
+			Expect(scheduler.Backoff("a", 100*time.Millisecond)).To(BeNumerically("~", 100*time.Millisecond, 10*time.Millisecond))
+
+			start := time.Now()
+			scheduler.Acquire("a", 0)()
+			Expect(time.Since(start)).To(BeNumerically(">=", 90*time.Millisecond))
+		})
+
+		It("doesn't pause requests to other hosts", func() {
+			scheduler := NewScheduler(0, HostLimit{}, nil)
+			scheduler.Backoff("a", time.Minute)
+
+			start := time.Now()
+			scheduler.Acquire("b", 0)()
+			Expect(time.Since(start)).To(BeNumerically("<", 100*time.Millisecond))
+		})
+
+		It("backs off exponentially when there's no Retry-After delay", func() {
+			scheduler := NewScheduler(0, HostLimit{}, nil)
+			scheduler.InitialBackoff = 20 * time.Millisecond
+
+			Expect(scheduler.Backoff("a", 0)).To(BeNumerically("~", 20*time.Millisecond, 5*time.Millisecond))
+
+			// Backing off again while paused doesn't extend the pause.
+			Expect(scheduler.Backoff("a", 0)).To(BeNumerically("<=", 20*time.Millisecond))
+
+			scheduler.Acquire("a", 0)()
+			Expect(scheduler.Backoff("a", 0)).To(BeNumerically("~", 40*time.Millisecond, 5*time.Millisecond))
+
+			scheduler.Acquire("a", 0)()
+			Expect(scheduler.Backoff("a", 0)).To(BeNumerically("~", 80*time.Millisecond, 5*time.Millisecond))
+		})
+
+		It("starts backing off from InitialBackoff again once reset", func() {
+			scheduler := NewScheduler(0, HostLimit{}, nil)
+			scheduler.InitialBackoff = 20 * time.Millisecond
+
+			scheduler.Backoff("a", 0)
+			scheduler.Acquire("a", 0)()
+			scheduler.ResetBackoff("a")
+
+			Expect(scheduler.Backoff("a", 0)).To(BeNumerically("~", 20*time.Millisecond, 5*time.Millisecond))
+		})
+
+		It("never pauses for longer than MaxBackoff", func() {
+			scheduler := NewScheduler(0, HostLimit{}, nil)
+
+			Expect(scheduler.Backoff("a", 24*time.Hour)).To(BeNumerically("~", DefaultMaxBackoff, 10*time.Millisecond))
+		})
+	})
+
+	Describe("ParseHostLimits", func() {
+		It("parses a list of host limits", func() {
+			limits, err := ParseHostLimits("www.gov.uk=4:250ms, assets.publishing.service.gov.uk=8:0s")
+
+			Expect(err).To(BeNil())
+			Expect(limits).To(Equal(map[string]HostLimit{
+				"www.gov.uk":                       {MaxInFlight: 4, Delay: 250 * time.Millisecond},
+				"assets.publishing.service.gov.uk": {MaxInFlight: 8, Delay: 0},
+			}))
+		})
+
+		It("returns no limits for an empty string", func() {
+			limits, err := ParseHostLimits("")
+
+			Expect(err).To(BeNil())
+			Expect(limits).To(BeEmpty())
+		})
+
+		It("returns an error for malformed limits", func() {
+			for _, limits := range []string{"www.gov.uk", "www.gov.uk=4", "www.gov.uk=x:1s", "www.gov.uk=4:soon"} {
+				_, err := ParseHostLimits(limits)
+				Expect(err).ToNot(BeNil())
+			}
+		})
+	})
+})
@@ -0,0 +1,140 @@
+package http_crawler
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/alphagov/govuk_crawler_worker/util"
+)
+
+// Where the content type of a response is taken from when the sources
+// disagree, see CrawlerOptions.ContentTypeSource.
+const (
+	ContentTypeFromHeader    = "header"
+	ContentTypeFromSniffing  = "sniff"
+	ContentTypeFromExtension = "extension"
+)
+
+// The most bytes of a body that http.DetectContentType looks at.
+const sniffLen = 512
+
+// Extensions of the types we crawl which the mime package doesn't know
+// about on every system.
+var extensionContentTypes = map[string]string{
+	".atom": ATOM,
+	".css":  CSS,
+	".csv":  CSV,
+	".docx": DOCX,
+	".ico":  ICO,
+	".ics":  ICS,
+	".js":   JAVASCRIPT,
+	".json": JSON,
+	".odp":  ODP,
+	".ods":  ODS,
+	".odt":  ODT,
+	".xls":  XLS,
+	".xlsx": XLSX,
+}
+
+// Types that http.DetectContentType returns when it can't tell what
+// something is, which say nothing about whether a declared type is right.
+var inconclusiveSniffedTypes = []string{"text/plain", "application/octet-stream"}
+
+// Types that http.DetectContentType only recognises by their container.
+var zipContentTypes = []string{DOCX, ODP, ODS, ODT, XLSX}
+
+func isValidContentTypeSource(source string) bool {
+	switch source {
+	case ContentTypeFromHeader, ContentTypeFromSniffing, ContentTypeFromExtension:
+		return true
+	}
+
+	return false
+}
+
+// ResolveContentType works out the content type of a response from its
+// declared Content-Type header, the extension of its URL and the start of
+// its body. The type from source is preferred, falling back on the other
+// two in turn when it's missing or inconclusive. Also returns the sniffed
+// type, and whether it contradicts the declared one.
+func ResolveContentType(source string, declared string, u *url.URL, content []byte) (contentType string, sniffed string, mismatch bool) {
+	sniffed = http.DetectContentType(content)
+	sniffedType := mediaType(sniffed)
+	declaredType := mediaType(declared)
+
+	if declaredType == "" {
+		declared = ""
+	}
+	if util.ContainsString(inconclusiveSniffedTypes, sniffedType) {
+		sniffedType = ""
+	}
+
+	mismatch = declaredType != "" && sniffedType != "" && !sniffedTypeAgrees(declaredType, sniffedType)
+
+	conclusiveSniffed := ""
+	if sniffedType != "" {
+		conclusiveSniffed = sniffed
+	}
+
+	var candidates []string
+	switch source {
+	case ContentTypeFromSniffing:
+		candidates = []string{conclusiveSniffed, declared, extensionContentType(u)}
+	case ContentTypeFromExtension:
+		candidates = []string{extensionContentType(u), declared, conclusiveSniffed}
+	default:
+		candidates = []string{declared, extensionContentType(u), conclusiveSniffed}
+	}
+
+	for _, candidate := range candidates {
+		if candidate != "" {
+			return candidate, sniffed, mismatch
+		}
+	}
+
+	return sniffed, sniffed, mismatch
+}
+
+func sniffedTypeAgrees(declaredType string, sniffedType string) bool {
+	switch {
+	case declaredType == sniffedType:
+		return true
+	case sniffedType == "application/zip":
+		return util.ContainsString(zipContentTypes, declaredType) || strings.HasSuffix(declaredType, "+zip")
+	case sniffedType == "text/xml":
+		return declaredType == "application/xml" || strings.HasSuffix(declaredType, "+xml")
+	}
+
+	return false
+}
+
+func extensionContentType(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+
+	extension := strings.ToLower(path.Ext(u.Path))
+	if extension == "" {
+		return ""
+	}
+
+	if contentType, ok := extensionContentTypes[extension]; ok {
+		return contentType
+	}
+
+	return mime.TypeByExtension(extension)
+}
+
+// mediaType returns the media type of a Content-Type value without its
+// parameters, or an empty string if it can't be parsed.
+func mediaType(contentType string) string {
+	mimeType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	return mimeType
+}
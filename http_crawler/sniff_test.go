@@ -0,0 +1,71 @@
+package http_crawler_test
+
+import (
+	"net/url"
+
+	. "github.com/alphagov/govuk_crawler_worker/http_crawler"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ResolveContentType", func() {
+	htmlBody := []byte("<!DOCTYPE html><html><body>Hello</body></html>")
+	pageURL, _ := url.Parse("https://www.gov.uk/page")
+	csvURL, _ := url.Parse("https://www.gov.uk/data.csv")
+
+	It("uses the declared type when it agrees with the body", func() {
+		contentType, sniffed, mismatch := ResolveContentType(ContentTypeFromHeader, "text/html; charset=utf-8", pageURL, htmlBody)
+
+		Expect(contentType).To(Equal("text/html; charset=utf-8"))
+		Expect(sniffed).To(Equal("text/html; charset=utf-8"))
+		Expect(mismatch).To(BeFalse())
+	})
+
+	It("sniffs the body when the declared type is missing or malformed", func() {
+		for _, declared := range []string{"", "text/html; charset"} {
+			contentType, _, mismatch := ResolveContentType(ContentTypeFromHeader, declared, pageURL, htmlBody)
+
+			Expect(contentType).To(Equal("text/html; charset=utf-8"))
+			Expect(mismatch).To(BeFalse())
+		}
+	})
+
+	It("prefers the extension to sniffing when the declared type is missing", func() {
+		contentType, _, _ := ResolveContentType(ContentTypeFromHeader, "", csvURL, []byte("a,b\n1,2\n"))
+
+		Expect(contentType).To(Equal(CSV))
+	})
+
+	It("records a mismatch when the body contradicts the declared type", func() {
+		contentType, sniffed, mismatch := ResolveContentType(ContentTypeFromHeader, PDF, pageURL, htmlBody)
+
+		Expect(contentType).To(Equal(PDF))
+		Expect(sniffed).To(Equal("text/html; charset=utf-8"))
+		Expect(mismatch).To(BeTrue())
+	})
+
+	It("doesn't record a mismatch when sniffing is inconclusive", func() {
+		_, _, mismatch := ResolveContentType(ContentTypeFromHeader, CSS, pageURL, []byte("body { color: red; }"))
+		Expect(mismatch).To(BeFalse())
+
+		_, _, mismatch = ResolveContentType(ContentTypeFromHeader, XLSX, pageURL, []byte("PK\x03\x04"))
+		Expect(mismatch).To(BeFalse())
+	})
+
+	It("uses the sniffed type when told to trust it", func() {
+		contentType, _, _ := ResolveContentType(ContentTypeFromSniffing, PDF, pageURL, htmlBody)
+		Expect(contentType).To(Equal("text/html; charset=utf-8"))
+
+		contentType, _, _ = ResolveContentType(ContentTypeFromSniffing, CSS, pageURL, []byte("body { color: red; }"))
+		Expect(contentType).To(Equal(CSS))
+	})
+
+	It("uses the extension when told to trust it", func() {
+		contentType, _, _ := ResolveContentType(ContentTypeFromExtension, "text/plain", csvURL, []byte("a,b\n1,2\n"))
+		Expect(contentType).To(Equal(CSV))
+
+		contentType, _, _ = ResolveContentType(ContentTypeFromExtension, "text/html", pageURL, htmlBody)
+		Expect(contentType).To(Equal("text/html"))
+	})
+})
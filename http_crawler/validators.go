@@ -0,0 +1,88 @@
+package http_crawler
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// Validators are the cache validators of a previously crawled response.
+// They're sent with the next request for the same URL to make it
+// conditional. ContentType is kept so that an unchanged response can be
+// handled without its body.
+type Validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	ContentType  string `json:"content_type"`
+}
+
+// ValidatorStore persists Validators between crawls. Get returns nil if
+// there are no validators stored for a URL.
+type ValidatorStore interface {
+	Get(u *url.URL) (*Validators, error)
+	Set(u *url.URL, validators *Validators) error
+	Delete(u *url.URL) error
+}
+
+// FileValidatorStore is a ValidatorStore that keeps one JSON file per URL
+// under a root directory.
+type FileValidatorStore struct {
+	root string
+}
+
+func NewFileValidatorStore(root string) *FileValidatorStore {
+	return &FileValidatorStore{root: root}
+}
+
+func (f *FileValidatorStore) Get(u *url.URL) (*Validators, error) {
+	content, err := ioutil.ReadFile(f.path(u))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	validators := &Validators{}
+	if err = json.Unmarshal(content, validators); err != nil {
+		return nil, err
+	}
+
+	return validators, nil
+}
+
+func (f *FileValidatorStore) Set(u *url.URL, validators *Validators) error {
+	content, err := json.Marshal(validators)
+	if err != nil {
+		return err
+	}
+
+	filePath := f.path(u)
+	if err = os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filePath, content, 0644)
+}
+
+func (f *FileValidatorStore) Delete(u *url.URL) error {
+	err := os.Remove(f.path(u))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Files are named after a digest of the URL, and spread across
+// subdirectories to keep each directory a manageable size.
+func (f *FileValidatorStore) path(u *url.URL) string {
+	digest := fmt.Sprintf("%x", md5.Sum([]byte(u.String())))
+
+	return filepath.Join(f.root, digest[0:2], digest+".json")
+}
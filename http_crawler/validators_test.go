@@ -0,0 +1,61 @@
+package http_crawler_test
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+
+	. "github.com/alphagov/govuk_crawler_worker/http_crawler"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FileValidatorStore", func() {
+	var root string
+	var store *FileValidatorStore
+	var testURL *url.URL
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "validators_test")
+		Expect(err).To(BeNil())
+
+		store = NewFileValidatorStore(root)
+		testURL, _ = url.Parse("https://www.gov.uk/bank-holidays")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
+	})
+
+	It("returns nil for URLs without stored validators", func() {
+		validators, err := store.Get(testURL)
+
+		Expect(err).To(BeNil())
+		Expect(validators).To(BeNil())
+	})
+
+	It("stores and retrieves validators for a URL", func() {
+		validators := &Validators{
+			ETag:         `"abc123"`,
+			LastModified: "Wed, 21 Oct 2015 07:28:00 GMT",
+			ContentType:  HTML,
+		}
+
+		Expect(store.Set(testURL, validators)).To(BeNil())
+		Expect(store.Get(testURL)).To(Equal(validators))
+
+		otherURL, _ := url.Parse("https://www.gov.uk/other")
+		Expect(store.Get(otherURL)).To(BeNil())
+	})
+
+	It("deletes validators for a URL", func() {
+		Expect(store.Set(testURL, &Validators{ETag: `"abc123"`})).To(BeNil())
+		Expect(store.Delete(testURL)).To(BeNil())
+		Expect(store.Get(testURL)).To(BeNil())
+
+		// Deleting validators which don't exist isn't an error.
+		Expect(store.Delete(testURL)).To(BeNil())
+	})
+})
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Elements and the attribute of each which holds a single URL. Those
+// which can have a rel attribute are skipped if it has a type listed in
+// CrawlerMessageItem.SkipRels.
+var urlElementAttributes = [][]string{
+	{"a", "href"},
+	{"area", "href"},
+	{"audio", "src"},
+	{"embed", "src"},
+	{"iframe", "src"},
+	{"img", "src"},
+	{"input", "src"},
+	{"link", "href"},
+	{"object", "data"},
+	{"script", "src"},
+	{"source", "src"},
+	{"track", "src"},
+	{"video", "poster"},
+	{"video", "src"},
+}
+
+// Elements and the attribute of each which holds a list of image
+// candidates, as used for responsive images.
+var srcsetElementAttributes = [][]string{
+	{"img", "srcset"},
+	{"source", "srcset"},
+}
+
+var (
+	cssCommentRegexp = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	cssImportRegexp  = regexp.MustCompile(`(?i)@import\s+(?:"([^"]*)"|'([^']*)')`)
+	cssURLRegexp     = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)"'\s]*))\s*\)`)
+
+	metaRefreshRegexp = regexp.MustCompile(`(?i)^\s*[0-9.]*\s*[;,]?\s*(?:url\s*=\s*)?(.*)$`)
+)
+
+// documentBaseURL returns the URL that links in an HTML document are
+// relative to: the first <base href> resolved against the URL of the
+// document, or the document URL itself if it has no base element.
+func documentBaseURL(document *goquery.Document, documentURL *url.URL) *url.URL {
+	href, ok := document.Find("base[href]").First().Attr("href")
+	if !ok {
+		return documentURL
+	}
+
+	base, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		log.Debugln("Ignoring malformed <base href>:", documentURL, href)
+		return documentURL
+	}
+
+	return documentURL.ResolveReference(base)
+}
+
+// extractHTMLURLs returns the URLs referenced by an HTML document, as they
+// appear in it, leaving out links with any of the types in skipRels.
+func extractHTMLURLs(document *goquery.Document, skipRels []string) []string {
+	hrefs := []string{}
+
+	for _, match := range urlElementAttributes {
+		element, attr := match[0], match[1]
+
+		document.Find(element + "[" + attr + "]").Each(func(_ int, selection *goquery.Selection) {
+			if hasRel(selection, skipRels) {
+				return
+			}
+
+			href, _ := selection.Attr(attr)
+			hrefs = append(hrefs, strings.TrimSpace(href))
+		})
+	}
+
+	for _, match := range srcsetElementAttributes {
+		for _, srcset := range findHrefsByElementAttribute(document, match[0]+"["+match[1]+"]", match[1]) {
+			hrefs = append(hrefs, parseSrcset(srcset)...)
+		}
+	}
+
+	document.Find("meta[http-equiv][content]").Each(func(_ int, element *goquery.Selection) {
+		httpEquiv, _ := element.Attr("http-equiv")
+		if !strings.EqualFold(strings.TrimSpace(httpEquiv), "refresh") {
+			return
+		}
+
+		content, _ := element.Attr("content")
+		if target := parseMetaRefresh(content); target != "" {
+			hrefs = append(hrefs, target)
+		}
+	})
+
+	document.Find("style").Each(func(_ int, element *goquery.Selection) {
+		hrefs = append(hrefs, extractCSSURLs(element.Text())...)
+	})
+
+	for _, style := range findHrefsByElementAttribute(document, "[style]", "style") {
+		hrefs = append(hrefs, extractCSSURLs(style)...)
+	}
+
+	return hrefs
+}
+
+// hasRel reports whether an element's rel attribute includes any of the
+// link types in rels. Link types are case insensitive.
+func hasRel(selection *goquery.Selection, rels []string) bool {
+	rel, ok := selection.Attr("rel")
+	if !ok {
+		return false
+	}
+
+	for _, linkType := range strings.Fields(rel) {
+		for _, skip := range rels {
+			if strings.EqualFold(linkType, skip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// extractCSSURLs returns the URLs referenced by url() and @import in a
+// stylesheet, or in the value of a style attribute.
+func extractCSSURLs(css string) []string {
+	hrefs := []string{}
+	css = cssCommentRegexp.ReplaceAllString(css, "")
+
+	for _, pattern := range []*regexp.Regexp{cssImportRegexp, cssURLRegexp} {
+		for _, match := range pattern.FindAllStringSubmatch(css, -1) {
+			for _, href := range match[1:] {
+				if href = strings.TrimSpace(href); href != "" {
+					hrefs = append(hrefs, href)
+					break
+				}
+			}
+		}
+	}
+
+	return hrefs
+}
+
+// parseSrcset returns the URLs of the image candidates in a srcset
+// attribute, such as `small.png 480w, large.png 1024w`. URLs may contain
+// commas, so candidates are separated by a comma followed by whitespace
+// or by their descriptors.
+func parseSrcset(srcset string) []string {
+	hrefs := []string{}
+
+	for remaining := srcset; ; {
+		remaining = strings.TrimLeft(remaining, " \t\n\r\f,")
+		if remaining == "" {
+			return hrefs
+		}
+
+		end := strings.IndexAny(remaining, " \t\n\r\f")
+		if end == -1 {
+			end = len(remaining)
+		}
+
+		href := remaining[:end]
+		remaining = remaining[end:]
+
+		if strings.HasSuffix(href, ",") {
+			href = strings.TrimRight(href, ",")
+		} else if comma := strings.Index(remaining, ","); comma != -1 {
+			// Skip the descriptors.
+			remaining = remaining[comma+1:]
+		} else {
+			remaining = ""
+		}
+
+		if href != "" {
+			hrefs = append(hrefs, href)
+		}
+	}
+}
+
+// parseMetaRefresh returns the URL in the content of a meta refresh, such
+// as `5; url=/new-page`, or an empty string if it only reloads the page.
+func parseMetaRefresh(content string) string {
+	match := metaRefreshRegexp.FindStringSubmatch(content)
+	if match == nil {
+		return ""
+	}
+
+	target := strings.TrimSpace(match[1])
+	if len(target) > 0 && (target[0] == '"' || target[0] == '\'') {
+		if end := strings.IndexByte(target[1:], target[0]); end != -1 {
+			target = target[1 : end+1]
+		} else {
+			target = target[1:]
+		}
+	}
+
+	return strings.TrimSpace(target)
+}
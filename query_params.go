@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/alphagov/govuk_crawler_worker/util"
+)
+
+// DefaultQueryParams allows pagination of every path, so that every page
+// of search results and finders is crawled.
+const DefaultQueryParams = "/=page"
+
+// QueryParamAllowList decides which query parameters URLs may have, by
+// path prefix. URLs with any other parameters aren't crawled. A nil
+// *QueryParamAllowList allows no parameters.
+type QueryParamAllowList struct {
+	prefixes []string
+	params   map[string][]string
+}
+
+// ParseQueryParamAllowList parses a comma separated list of the parameters
+// allowed under each path prefix in the form `prefix=param:param`, for
+// example:
+//
+//	/search=page,/government/statistics=page:year
+//
+// Where more than one prefix matches a path, the longest one is used.
+func ParseQueryParamAllowList(allowList string) (*QueryParamAllowList, error) {
+	q := &QueryParamAllowList{params: make(map[string][]string)}
+
+	for _, entry := range strings.Split(allowList, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		prefixAndParams := strings.SplitN(entry, "=", 2)
+		if len(prefixAndParams) != 2 || !strings.HasPrefix(prefixAndParams[0], "/") {
+			return nil, fmt.Errorf("Invalid query params (expected /prefix=param:param): %s", entry)
+		}
+
+		prefix := prefixAndParams[0]
+		if _, ok := q.params[prefix]; !ok {
+			q.prefixes = append(q.prefixes, prefix)
+		}
+
+		for _, param := range strings.Split(prefixAndParams[1], ":") {
+			if param = strings.TrimSpace(param); param != "" {
+				q.params[prefix] = append(q.params[prefix], param)
+			}
+		}
+	}
+
+	return q, nil
+}
+
+// Allows reports whether every query parameter of u is allowed on its
+// path. URLs without a query are always allowed.
+func (q *QueryParamAllowList) Allows(u *url.URL) bool {
+	if u.RawQuery == "" {
+		return true
+	}
+
+	values, err := url.ParseQuery(u.RawQuery)
+	if err != nil || q == nil {
+		return false
+	}
+
+	allowed := q.allowedParams(u.Path)
+	for param := range values {
+		if !util.ContainsString(allowed, param) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (q *QueryParamAllowList) allowedParams(path string) []string {
+	if prefix, ok := longestMatchingPrefix(q.prefixes, path); ok {
+		return q.params[prefix]
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package main_test
+
+import (
+	"net/url"
+
+	. "github.com/alphagov/govuk_crawler_worker"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QueryParamAllowList", func() {
+	allows := func(allowList *QueryParamAllowList, rawURL string) bool {
+		u, err := url.Parse(rawURL)
+		Expect(err).To(BeNil())
+
+		return allowList.Allows(u)
+	}
+
+	It("returns an error for invalid entries", func() {
+		_, err := ParseQueryParamAllowList("/search=page,page")
+		Expect(err).To(MatchError("Invalid query params (expected /prefix=param:param): page"))
+
+		_, err = ParseQueryParamAllowList("search=page")
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("always allows URLs without a query", func() {
+		var nilAllowList *QueryParamAllowList
+
+		Expect(allows(nilAllowList, "https://www.gov.uk/search")).To(BeTrue())
+	})
+
+	It("allows no query params when it's nil", func() {
+		var nilAllowList *QueryParamAllowList
+
+		Expect(allows(nilAllowList, "https://www.gov.uk/search?page=2")).To(BeFalse())
+	})
+
+	It("allows pagination everywhere by default", func() {
+		allowList, err := ParseQueryParamAllowList(DefaultQueryParams)
+		Expect(err).To(BeNil())
+
+		Expect(allows(allowList, "https://www.gov.uk/government/foo?page=2")).To(BeTrue())
+		Expect(allows(allowList, "https://www.gov.uk/government/foo?page=2&q=tax")).To(BeFalse())
+	})
+
+	It("allows the params of the longest matching prefix", func() {
+		allowList, err := ParseQueryParamAllowList("/search=page, /government/statistics=page:year,/government=order")
+		Expect(err).To(BeNil())
+
+		Expect(allows(allowList, "https://www.gov.uk/search?page=2")).To(BeTrue())
+		Expect(allows(allowList, "https://www.gov.uk/search?year=2015")).To(BeFalse())
+		Expect(allows(allowList, "https://www.gov.uk/government/statistics?year=2015&page=2")).To(BeTrue())
+		Expect(allows(allowList, "https://www.gov.uk/government/statistics?order=asc")).To(BeFalse())
+		Expect(allows(allowList, "https://www.gov.uk/government/news?order=asc")).To(BeTrue())
+		Expect(allows(allowList, "https://www.gov.uk/browse?page=2")).To(BeFalse())
+	})
+
+	It("doesn't allow queries it can't parse", func() {
+		allowList, err := ParseQueryParamAllowList(DefaultQueryParams)
+		Expect(err).To(BeNil())
+
+		Expect(allows(allowList, "https://www.gov.uk/search?page=%zz")).To(BeFalse())
+	})
+})
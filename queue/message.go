@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// MessageVersion is the version of the Message format published. Messages
+// with a newer version can't be decoded.
+const MessageVersion = 1
+
+// The content types of the message formats, plain text being a URL alone.
+const (
+	JSONContentType      = "application/json"
+	PlainTextContentType = "text/plain"
+)
+
+// The highest priority AMQP supports, which queues are declared with.
+const maxPriority = 9
+
+var ErrMessageMissingURL = errors.New("Message has no URL")
+
+// Message is the body of a queued message, describing a URL to crawl and
+// how it was found.
+type Message struct {
+	Version int    `json:"version"`
+	URL     string `json:"url"`
+	// Referrer is the URL of the page the URL was found on, which is
+	// empty for seed URLs.
+	Referrer string `json:"referrer,omitempty"`
+	// Depth is the number of links between a seed URL and the URL.
+	Depth        int       `json:"depth"`
+	DiscoveredAt time.Time `json:"discovered_at"`
+	// SeedID identifies the seed URL the URL was found from.
+	SeedID   string `json:"seed_id,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	// Attempts is the number of times crawling the URL has already
+	// been attempted.
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// Encode returns the message as JSON, at the current MessageVersion.
+func (m *Message) Encode() ([]byte, error) {
+	encoded := *m
+	encoded.Version = MessageVersion
+
+	return json.Marshal(encoded)
+}
+
+// DecodeMessage reads the Message in a delivery. Plain text bodies, which
+// are a URL alone, are accepted for compatibility with older publishers.
+func DecodeMessage(delivery amqp.Delivery) (*Message, error) {
+	if !isJSONMessage(delivery) {
+		return &Message{URL: strings.TrimSpace(string(delivery.Body))}, nil
+	}
+
+	message := &Message{}
+	if err := json.Unmarshal(delivery.Body, message); err != nil {
+		return nil, err
+	}
+
+	if message.Version > MessageVersion {
+		return nil, fmt.Errorf("Unsupported message version: %d", message.Version)
+	}
+
+	if message.URL == "" {
+		return nil, ErrMessageMissingURL
+	}
+
+	return message, nil
+}
+
+func (h *Manager) PublishMessage(routingKey string, message *Message) error {
+	return h.Producer.PublishMessage(h.ExchangeName, routingKey, message)
+}
+
+// PublishMessage publishes message as JSON, with its priority as the AMQP
+// priority of the message.
+func (c *Connection) PublishMessage(exchangeName string, routingKey string, message *Message) error {
+	body, err := message.Encode()
+	if err != nil {
+		return err
+	}
+
+	priority := message.Priority
+	if priority < 0 {
+		priority = 0
+	} else if priority > maxPriority {
+		priority = maxPriority
+	}
+
+	return c.Channel.Publish(
+		exchangeName, // publish to an exchange
+		routingKey,   // routing to 0 or more queues
+		false,        // mandatory
+		false,        // immediate
+		amqp.Publishing{
+			Headers:      amqp.Table{},
+			ContentType:  JSONContentType,
+			Body:         body,
+			DeliveryMode: amqp.Persistent,
+			Priority:     uint8(priority),
+		})
+}
+
+// Messages are JSON if they say so, or if they look like it when they
+// don't say what they are.
+func isJSONMessage(delivery amqp.Delivery) bool {
+	if delivery.ContentType != "" {
+		mediaType, _, err := mime.ParseMediaType(delivery.ContentType)
+		if err == nil {
+			return mediaType == JSONContentType
+		}
+	}
+
+	return bytes.HasPrefix(bytes.TrimSpace(delivery.Body), []byte("{"))
+}
@@ -0,0 +1,94 @@
+package queue_test
+
+import (
+	"time"
+
+	. "github.com/alphagov/govuk_crawler_worker/queue"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/streadway/amqp"
+)
+
+var _ = Describe("Message", func() {
+	discoveredAt := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	It("encodes messages as JSON at the current version", func() {
+		message := &Message{
+			URL:          "https://www.gov.uk/foo",
+			Referrer:     "https://www.gov.uk/",
+			Depth:        1,
+			DiscoveredAt: discoveredAt,
+			SeedID:       "https://www.gov.uk/",
+		}
+
+		body, err := message.Encode()
+
+		Expect(err).To(BeNil())
+		Expect(body).To(MatchJSON(`{
+			"version": 1,
+			"url": "https://www.gov.uk/foo",
+			"referrer": "https://www.gov.uk/",
+			"depth": 1,
+			"discovered_at": "2015-01-02T03:04:05Z",
+			"seed_id": "https://www.gov.uk/"
+		}`))
+	})
+
+	It("decodes JSON messages", func() {
+		message := &Message{
+			URL:          "https://www.gov.uk/foo",
+			Depth:        2,
+			DiscoveredAt: discoveredAt,
+			Priority:     5,
+			Attempts:     1,
+		}
+		body, err := message.Encode()
+		Expect(err).To(BeNil())
+
+		decoded, err := DecodeMessage(amqp.Delivery{ContentType: JSONContentType, Body: body})
+
+		Expect(err).To(BeNil())
+		message.Version = MessageVersion
+		Expect(decoded).To(Equal(message))
+	})
+
+	It("decodes JSON messages without a content type", func() {
+		decoded, err := DecodeMessage(amqp.Delivery{Body: []byte(`{"version": 1, "url": "https://www.gov.uk/foo"}`)})
+
+		Expect(err).To(BeNil())
+		Expect(decoded.URL).To(Equal("https://www.gov.uk/foo"))
+	})
+
+	It("decodes plain text messages as a URL", func() {
+		decoded, err := DecodeMessage(amqp.Delivery{
+			ContentType: PlainTextContentType,
+			Body:        []byte("https://www.gov.uk/foo\n"),
+		})
+
+		Expect(err).To(BeNil())
+		Expect(decoded).To(Equal(&Message{URL: "https://www.gov.uk/foo"}))
+	})
+
+	It("returns an error for messages from a newer version", func() {
+		_, err := DecodeMessage(amqp.Delivery{
+			ContentType: JSONContentType,
+			Body:        []byte(`{"version": 2, "url": "https://www.gov.uk/foo"}`),
+		})
+
+		Expect(err).To(MatchError("Unsupported message version: 2"))
+	})
+
+	It("returns an error for messages without a URL", func() {
+		_, err := DecodeMessage(amqp.Delivery{ContentType: JSONContentType, Body: []byte(`{"version": 1}`)})
+
+		Expect(err).To(Equal(ErrMessageMissingURL))
+	})
+
+	It("returns an error for invalid JSON", func() {
+		_, err := DecodeMessage(amqp.Delivery{ContentType: JSONContentType, Body: []byte(`https://www.gov.uk/foo`)})
+
+		Expect(err).ToNot(BeNil())
+	})
+})
@@ -1,6 +1,7 @@
 package queue
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/streadway/amqp"
@@ -76,6 +77,12 @@ func (c *Connection) Consume(queueName string) (<-chan amqp.Delivery, error) {
 		nil)   // arguments
 }
 
+// SetPrefetch limits how many unacknowledged messages the broker delivers
+// to each consumer started after it's called.
+func (c *Connection) SetPrefetch(count int) error {
+	return c.Channel.Qos(count, 0, false)
+}
+
 func (c *Connection) ExchangeDeclare(exchangeName string, exchangeType string) error {
 	return c.Channel.ExchangeDeclare(
 		exchangeName, // name of the exchange
@@ -88,6 +95,10 @@ func (c *Connection) ExchangeDeclare(exchangeName string, exchangeType string) e
 	)
 }
 
+// QueueDeclare declares a durable queue which supports message
+// priorities. A queue can't be declared again with different arguments,
+// so queues declared without priorities by older versions of the worker
+// have to be replaced, as described in the README.
 func (c *Connection) QueueDeclare(queueName string) (amqp.Queue, error) {
 	queue, err := c.Channel.QueueDeclare(
 		queueName, // name of the queue
@@ -95,7 +106,10 @@ func (c *Connection) QueueDeclare(queueName string) (amqp.Queue, error) {
 		false,     // delete when usused
 		false,     // exclusive
 		false,     // noWait
-		nil)       // arguments
+		amqp.Table{"x-max-priority": int32(maxPriority)}) // arguments
+	if amqpErr, ok := err.(*amqp.Error); ok && amqpErr.Code == amqp.PreconditionFailed {
+		err = fmt.Errorf("Queue %s was declared with other arguments, it needs replacing with one declared with x-max-priority: %v", queueName, err)
+	}
 	if err != nil {
 		return amqp.Queue{
 			Name: queueName,
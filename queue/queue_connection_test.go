@@ -157,6 +157,24 @@ var _ = Describe("Connection", func() {
 			Expect(deleted).To(Equal(0))
 		})
 
+		It("explains how to replace a queue declared without priorities", func() {
+			name := "govuk_crawler_worker-unprioritised-queue"
+
+			_, err := connection.Channel.QueueDeclare(name, true, false, false, false, nil)
+			Expect(err).To(BeNil())
+
+			_, err = connection.QueueDeclare(name)
+			Expect(err).To(MatchError(ContainSubstring("needs replacing with one declared with x-max-priority")))
+
+			// The broker closes the channel after refusing the declaration.
+			connection.Connection.Close()
+			connection, err = NewConnection(amqpAddr)
+			Expect(err).To(BeNil())
+
+			_, err = connection.Channel.QueueDelete(name, false, false, false)
+			Expect(err).To(BeNil())
+		})
+
 		It("can bind a queue to an exchange", func() {
 			var err error
 
@@ -94,5 +94,24 @@ var _ = Describe("Manager", func() {
 			item.Ack(false)
 			close(done)
 		})
+
+		It("can publish and decode messages", func(done Done) {
+			deliveries, err := queueManager.Consume()
+			Expect(err).To(BeNil())
+
+			err = queueManager.PublishMessage("#", &Message{URL: "https://www.gov.uk/foo", Depth: 2})
+			Expect(err).To(BeNil())
+
+			item := <-deliveries
+			Expect(item.ContentType).To(Equal(JSONContentType))
+
+			message, err := DecodeMessage(item)
+			Expect(err).To(BeNil())
+			Expect(message.URL).To(Equal("https://www.gov.uk/foo"))
+			Expect(message.Depth).To(Equal(2))
+
+			item.Ack(false)
+			close(done)
+		})
 	})
 })
@@ -0,0 +1,105 @@
+package sidecar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/alphagov/govuk_crawler_worker/util"
+)
+
+// NginxHiddenFiles denies requests for hidden files and directories, such
+// as sidecars, streamed bodies and files still being written, other than
+// those under /.well-known/.
+const NginxHiddenFiles = `location ~ "/\.(?!well-known/)" {
+    deny all;
+}
+
+`
+
+// The headers reproduced by WriteNginxConfig, besides Content-Type.
+var nginxHeaders = []string{
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Language",
+	"Link",
+	"X-Robots-Tag",
+}
+
+// WriteNginxConfig writes an exact match `location` block for each
+// successful response in sidecars, which serves its file, sets its
+// Content-Type and adds the headers worth reproducing. It's meant to be
+// included in the `server` block serving the mirror of a single host, and
+// starts with NginxHiddenFiles so that only the mirror is served from it.
+// If fileVariable is set, the file named by that nginx variable is served
+// in preference, for mirrors whose other config finds files that way.
+//
+// URLs with query strings are left out, as a location can't match them.
+// So are header values with a `$` in them, which nginx would take to be a
+// variable. Where there's more than one sidecar for a path, the most
+// recently crawled is used.
+func WriteNginxConfig(w io.Writer, sidecars []*Metadata, fileVariable string) error {
+	latest := map[string]*Metadata{}
+
+	for _, metadata := range sidecars {
+		u, err := url.Parse(metadata.URL)
+		if err != nil || u.RawQuery != "" || metadata.StatusCode < 200 || metadata.StatusCode >= 300 {
+			continue
+		}
+
+		urlPath := u.Path
+		if urlPath == "" {
+			urlPath = "/"
+		}
+
+		if existing, ok := latest[urlPath]; !ok || metadata.CrawledAt.After(existing.CrawledAt) {
+			latest[urlPath] = metadata
+		}
+	}
+
+	paths := make([]string, 0, len(latest))
+	for urlPath := range latest {
+		paths = append(paths, urlPath)
+	}
+	sort.Strings(paths)
+
+	buffered := bufio.NewWriter(w)
+	buffered.WriteString(NginxHiddenFiles)
+
+	for _, urlPath := range paths {
+		metadata := latest[urlPath]
+
+		fmt.Fprintf(buffered, "location = %s {\n", util.NginxQuote(urlPath))
+
+		// Files are found relative to the directory of the host.
+		keyParts := strings.SplitN(metadata.Key, "/", 2)
+		if len(keyParts) == 2 && !strings.Contains(keyParts[1], "$") {
+			files := []string{util.NginxQuote("/" + keyParts[1]), "=404"}
+			if fileVariable != "" {
+				files = append([]string{fileVariable}, files...)
+			}
+
+			fmt.Fprintf(buffered, "    try_files %s;\n", strings.Join(files, " "))
+		}
+
+		if metadata.ContentType != "" && !strings.Contains(metadata.ContentType, "$") {
+			fmt.Fprintf(buffered, "    types { }\n")
+			fmt.Fprintf(buffered, "    default_type %s;\n", util.NginxQuote(metadata.ContentType))
+		}
+
+		for _, name := range nginxHeaders {
+			for _, value := range metadata.Header[name] {
+				if !strings.Contains(value, "$") {
+					fmt.Fprintf(buffered, "    add_header %s %s;\n", name, util.NginxQuote(value))
+				}
+			}
+		}
+
+		fmt.Fprintf(buffered, "}\n\n")
+	}
+
+	return buffered.Flush()
+}
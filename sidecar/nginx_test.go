@@ -0,0 +1,123 @@
+package sidecar_test
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/alphagov/govuk_crawler_worker/sidecar"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteNginxConfig", func() {
+	crawledAt := time.Date(2014, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	hiddenFiles := "location ~ \"/\\.(?!well-known/)\" {\n    deny all;\n}\n\n"
+
+	// nginxConfig returns the config written after the hidden files
+	// location.
+	nginxConfig := func(sidecars ...*Metadata) string {
+		var config bytes.Buffer
+		Expect(WriteNginxConfig(&config, sidecars, "")).To(BeNil())
+		Expect(config.String()).To(HavePrefix(hiddenFiles))
+
+		return strings.TrimPrefix(config.String(), hiddenFiles)
+	}
+
+	It("denies requests for hidden files outside /.well-known/", func() {
+		var config bytes.Buffer
+		Expect(WriteNginxConfig(&config, nil, "")).To(BeNil())
+
+		Expect(config.String()).To(Equal(`location ~ "/\.(?!well-known/)" {
+    deny all;
+}
+
+`))
+	})
+
+	It("sets the Content-Type and adds headers for each path", func() {
+		Expect(nginxConfig(&Metadata{
+			URL:        "https://www.gov.uk/foo.pdf",
+			StatusCode: http.StatusOK,
+			Header: http.Header{
+				"Cache-Control":       []string{"max-age=1800, public"},
+				"Content-Disposition": []string{`attachment; filename="foo.pdf"`},
+				"Link":                []string{"<https://www.gov.uk/foo>; rel=up", "</bar>; rel=next"},
+				"Server":              []string{"nginx"},
+			},
+			ContentType: "application/pdf",
+			CrawledAt:   crawledAt,
+			Key:         "www.gov.uk/foo.pdf",
+		}, &Metadata{
+			URL:         "https://www.gov.uk/",
+			StatusCode:  http.StatusOK,
+			ContentType: "text/html; charset=utf-8",
+			CrawledAt:   crawledAt,
+			Key:         "www.gov.uk/index.html",
+		})).To(Equal(`location = "/" {
+    try_files "/index.html" =404;
+    types { }
+    default_type "text/html; charset=utf-8";
+}
+
+location = "/foo.pdf" {
+    try_files "/foo.pdf" =404;
+    types { }
+    default_type "application/pdf";
+    add_header Cache-Control "max-age=1800, public";
+    add_header Content-Disposition "attachment; filename=\"foo.pdf\"";
+    add_header Link "<https://www.gov.uk/foo>; rel=up";
+    add_header Link "</bar>; rel=next";
+}
+
+`))
+	})
+
+	It("leaves out unsuccessful responses and URLs with query strings", func() {
+		Expect(nginxConfig(&Metadata{
+			URL:         "https://www.gov.uk/moved",
+			StatusCode:  http.StatusMovedPermanently,
+			ContentType: "text/html",
+		}, &Metadata{
+			URL:         "https://www.gov.uk/search?q=foo",
+			StatusCode:  http.StatusOK,
+			ContentType: "text/html",
+		})).To(BeEmpty())
+	})
+
+	It("leaves out values which nginx would take to contain variables", func() {
+		Expect(nginxConfig(&Metadata{
+			URL:        "https://www.gov.uk/foo",
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Link": []string{"</$foo>; rel=next"}},
+		})).To(Equal("location = \"/foo\" {\n}\n\n"))
+	})
+
+	It("serves the file named by a variable in preference", func() {
+		var config bytes.Buffer
+		Expect(WriteNginxConfig(&config, []*Metadata{{
+			URL:        "https://www.gov.uk/foo",
+			StatusCode: http.StatusOK,
+			Key:        "www.gov.uk/foo.html",
+		}}, "$mirror_file")).To(BeNil())
+
+		Expect(config.String()).To(Equal(hiddenFiles + "location = \"/foo\" {\n    try_files $mirror_file \"/foo.html\" =404;\n}\n\n"))
+	})
+
+	It("uses the most recent crawl of a path", func() {
+		Expect(nginxConfig(&Metadata{
+			URL:         "http://www.gov.uk/foo",
+			StatusCode:  http.StatusOK,
+			ContentType: "text/plain",
+			CrawledAt:   crawledAt.Add(time.Hour),
+		}, &Metadata{
+			URL:         "https://www.gov.uk/foo",
+			StatusCode:  http.StatusOK,
+			ContentType: "text/html",
+			CrawledAt:   crawledAt,
+		})).To(ContainSubstring(`default_type "text/plain";`))
+	})
+})
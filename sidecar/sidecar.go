@@ -0,0 +1,103 @@
+package sidecar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alphagov/govuk_crawler_worker/storage"
+)
+
+// Sidecars are kept under this prefix, apart from the files of the mirror.
+// It's still under MIRROR_ROOT, so whatever serves the mirror needs to deny
+// requests for hidden files, as NginxHiddenFiles does.
+const KeyPrefix = ".meta/"
+
+// Metadata describes the response a file in the mirror was written from,
+// so that a web server serving the mirror can reproduce its headers.
+type Metadata struct {
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	// ContentType is the type of the file as written, which differs
+	// from the Content-Type header if its charset was converted.
+	ContentType string    `json:"content_type"`
+	CrawledAt   time.Time `json:"crawled_at"`
+	ETag        string    `json:"etag,omitempty"`
+	// ContentHash is the `sha256:` digest of the file, see ContentHash.
+	ContentHash string `json:"content_hash"`
+
+	// Key is the key of the file, which is set when the sidecar is read.
+	Key string `json:"-"`
+}
+
+// Key returns the key of the sidecar for the file at key.
+func Key(key string) string {
+	return KeyPrefix + strings.TrimPrefix(key, "/") + ".json"
+}
+
+// ContentHash returns the hex encoded `sha256:` digest of body.
+func ContentHash(body io.Reader) (string, error) {
+	digest := sha256.New()
+	if _, err := io.Copy(digest, body); err != nil {
+		return "", err
+	}
+
+	return "sha256:" + hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// Put writes the sidecar for the file at key.
+func Put(store storage.Store, key string, metadata *Metadata) error {
+	body, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return store.Put(Key(key), bytes.NewReader(body))
+}
+
+// Get returns the sidecar for the file at key, or storage.ErrNotFound.
+func Get(store storage.Store, key string) (*Metadata, error) {
+	body, err := store.Get(Key(key))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	metadata := &Metadata{}
+	if err = json.NewDecoder(body).Decode(metadata); err != nil {
+		return nil, err
+	}
+	metadata.Key = key
+
+	return metadata, nil
+}
+
+// List returns every sidecar in the store.
+func List(store storage.Store) ([]*Metadata, error) {
+	keys, err := store.List(KeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	sidecars := make([]*Metadata, 0, len(keys))
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ".json") {
+			continue
+		}
+
+		metadata, err := Get(store, strings.TrimSuffix(strings.TrimPrefix(key, KeyPrefix), ".json"))
+		if err != nil {
+			return nil, err
+		}
+
+		sidecars = append(sidecars, metadata)
+	}
+
+	return sidecars, nil
+}
@@ -0,0 +1,77 @@
+package sidecar_test
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/alphagov/govuk_crawler_worker/sidecar"
+
+	"github.com/alphagov/govuk_crawler_worker/storage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sidecar", func() {
+	var store *storage.MemoryStore
+	var metadata *Metadata
+
+	BeforeEach(func() {
+		store = storage.NewMemoryStore()
+		metadata = &Metadata{
+			URL:        "https://www.gov.uk/foo.pdf",
+			StatusCode: http.StatusOK,
+			Header: http.Header{
+				"Content-Disposition": []string{"attachment"},
+			},
+			ContentType: "application/pdf",
+			CrawledAt:   time.Date(2014, 4, 1, 12, 0, 0, 0, time.UTC),
+			ETag:        `"abc"`,
+			ContentHash: "sha256:0123",
+		}
+	})
+
+	It("keeps sidecars apart from the mirror", func() {
+		Expect(Key("www.gov.uk/foo.pdf")).To(Equal(".meta/www.gov.uk/foo.pdf.json"))
+		Expect(Key("/www.gov.uk/foo.pdf")).To(Equal(".meta/www.gov.uk/foo.pdf.json"))
+	})
+
+	It("hashes bodies with SHA-256", func() {
+		Expect(ContentHash(strings.NewReader(""))).To(Equal(
+			"sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"))
+	})
+
+	It("writes sidecars which can be read back", func() {
+		Expect(Put(store, "www.gov.uk/foo.pdf", metadata)).To(BeNil())
+		Expect(store.Exists(".meta/www.gov.uk/foo.pdf.json")).To(BeTrue())
+
+		read, err := Get(store, "www.gov.uk/foo.pdf")
+		Expect(err).To(BeNil())
+		Expect(read.Key).To(Equal("www.gov.uk/foo.pdf"))
+
+		read.Key = ""
+		Expect(read).To(Equal(metadata))
+	})
+
+	It("returns ErrNotFound for files without a sidecar", func() {
+		_, err := Get(store, "www.gov.uk/bar.pdf")
+		Expect(err).To(Equal(storage.ErrNotFound))
+	})
+
+	It("lists every sidecar, leaving out the mirror", func() {
+		other := *metadata
+		other.URL = "https://www.gov.uk/bar"
+
+		Expect(store.Put("www.gov.uk/foo.pdf", strings.NewReader("%PDF-1.4"))).To(BeNil())
+		Expect(Put(store, "www.gov.uk/foo.pdf", metadata)).To(BeNil())
+		Expect(Put(store, "www.gov.uk/bar.html", &other)).To(BeNil())
+
+		sidecars, err := List(store)
+		Expect(err).To(BeNil())
+		Expect(sidecars).To(HaveLen(2))
+		Expect(sidecars[0].URL).To(Equal("https://www.gov.uk/bar"))
+		Expect(sidecars[0].Key).To(Equal("www.gov.uk/bar.html"))
+		Expect(sidecars[1].URL).To(Equal("https://www.gov.uk/foo.pdf"))
+		Expect(sidecars[1].Key).To(Equal("www.gov.uk/foo.pdf"))
+	})
+})
@@ -0,0 +1,158 @@
+package sitemap
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The most a sitemap can hold uncompressed, as set by the sitemap
+// protocol. Larger sitemaps are an error rather than being truncated.
+const maxSitemapSize = 50 * 1024 * 1024
+
+var (
+	ErrNotSitemap      = errors.New("Document is neither a urlset nor a sitemapindex")
+	ErrSitemapTooLarge = errors.New("Sitemap is larger than the maximum sitemap size")
+
+	gzipMagic = []byte{0x1f, 0x8b}
+
+	// The W3C Datetime formats allowed for <lastmod>, from the most to the
+	// least precise.
+	lastModFormats = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04Z07:00",
+		"2006-01-02",
+		"2006-01",
+		"2006",
+	}
+)
+
+// An Entry is a <url> or <sitemap> element, giving the location of a page
+// or of another sitemap and when it was last modified. LastMod is zero
+// when it isn't given or can't be parsed.
+type Entry struct {
+	Loc     string
+	LastMod time.Time
+}
+
+// Sitemap is a parsed sitemap, which is either a <urlset> listing pages
+// or a <sitemapindex> listing other sitemaps.
+type Sitemap struct {
+	URLs     []Entry
+	Sitemaps []Entry
+}
+
+type xmlEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+type xmlSitemap struct {
+	XMLName  xml.Name
+	URLs     []xmlEntry `xml:"url"`
+	Sitemaps []xmlEntry `xml:"sitemap"`
+}
+
+// Parse reads a sitemap or sitemap index, which may be gzip compressed.
+func Parse(r io.Reader) (*Sitemap, error) {
+	body, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+
+	limited := &io.LimitedReader{R: body, N: maxSitemapSize + 1}
+
+	parsed := &xmlSitemap{}
+	if err := xml.NewDecoder(limited).Decode(parsed); err != nil {
+		if limited.N <= 0 {
+			return nil, ErrSitemapTooLarge
+		}
+
+		return nil, err
+	}
+
+	switch parsed.XMLName.Local {
+	case "urlset":
+		return &Sitemap{URLs: parseEntries(parsed.URLs)}, nil
+	case "sitemapindex":
+		return &Sitemap{Sitemaps: parseEntries(parsed.Sitemaps)}, nil
+	}
+
+	return nil, ErrNotSitemap
+}
+
+// RobotsTxtSitemaps returns the sitemaps listed by the `Sitemap:` lines of
+// a robots.txt file, resolved against the URL it was fetched from.
+func RobotsTxtSitemaps(body []byte, robotsURL *url.URL) []*url.URL {
+	var sitemaps []*url.URL
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 || strings.ToLower(strings.TrimSpace(parts[0])) != "sitemap" {
+			continue
+		}
+
+		sitemapURL, err := robotsURL.Parse(strings.TrimSpace(parts[1]))
+		if err == nil {
+			sitemaps = append(sitemaps, sitemapURL)
+		}
+	}
+
+	return sitemaps
+}
+
+// ParseLastMod parses a <lastmod> value, returning the zero time if it
+// isn't a W3C Datetime.
+func ParseLastMod(lastMod string) time.Time {
+	lastMod = strings.TrimSpace(lastMod)
+
+	for _, format := range lastModFormats {
+		if t, err := time.Parse(format, lastMod); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// Sitemaps are often served compressed without a Content-Encoding, as
+// `.xml.gz` files, so they're recognised by their magic number.
+func decompress(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+
+	magic, err := buffered.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if bytes.Equal(magic, gzipMagic) {
+		return gzip.NewReader(buffered)
+	}
+
+	return buffered, nil
+}
+
+func parseEntries(xmlEntries []xmlEntry) []Entry {
+	entries := make([]Entry, 0, len(xmlEntries))
+
+	for _, entry := range xmlEntries {
+		loc := strings.TrimSpace(entry.Loc)
+		if loc == "" {
+			continue
+		}
+
+		entries = append(entries, Entry{
+			Loc:     loc,
+			LastMod: ParseLastMod(entry.LastMod),
+		})
+	}
+
+	return entries
+}
@@ -0,0 +1,123 @@
+package sitemap_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/url"
+	"strings"
+	"time"
+
+	. "github.com/alphagov/govuk_crawler_worker/sitemap"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const urlset = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc>https://www.gov.uk/bank-holidays</loc>
+    <lastmod>2014-04-01T12:00:00+01:00</lastmod>
+  </url>
+  <url>
+    <loc> https://www.gov.uk/vat-rates </loc>
+  </url>
+  <url>
+    <loc></loc>
+  </url>
+</urlset>`
+
+const sitemapIndex = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap>
+    <loc>https://www.gov.uk/sitemaps/sitemap_1.xml</loc>
+    <lastmod>2014-04-01</lastmod>
+  </sitemap>
+</sitemapindex>`
+
+func gzipped(body string) []byte {
+	var buffer bytes.Buffer
+
+	writer := gzip.NewWriter(&buffer)
+	writer.Write([]byte(body))
+	writer.Close()
+
+	return buffer.Bytes()
+}
+
+var _ = Describe("Sitemap", func() {
+	Describe("Parse", func() {
+		It("reads the pages listed by a urlset", func() {
+			sitemap, err := Parse(strings.NewReader(urlset))
+			Expect(err).To(BeNil())
+
+			Expect(sitemap.Sitemaps).To(BeEmpty())
+			Expect(sitemap.URLs).To(HaveLen(2))
+			Expect(sitemap.URLs[0].Loc).To(Equal("https://www.gov.uk/bank-holidays"))
+			Expect(sitemap.URLs[0].LastMod.Equal(time.Date(2014, 4, 1, 11, 0, 0, 0, time.UTC))).To(BeTrue())
+			Expect(sitemap.URLs[1]).To(Equal(Entry{Loc: "https://www.gov.uk/vat-rates"}))
+		})
+
+		It("reads the sitemaps listed by a sitemap index", func() {
+			sitemap, err := Parse(strings.NewReader(sitemapIndex))
+			Expect(err).To(BeNil())
+
+			Expect(sitemap.URLs).To(BeEmpty())
+			Expect(sitemap.Sitemaps).To(Equal([]Entry{
+				{Loc: "https://www.gov.uk/sitemaps/sitemap_1.xml", LastMod: time.Date(2014, 4, 1, 0, 0, 0, 0, time.UTC)},
+			}))
+		})
+
+		It("reads gzip compressed sitemaps", func() {
+			sitemap, err := Parse(bytes.NewReader(gzipped(urlset)))
+			Expect(err).To(BeNil())
+
+			Expect(sitemap.URLs).To(HaveLen(2))
+		})
+
+		It("returns an error for documents that aren't sitemaps", func() {
+			_, err := Parse(strings.NewReader(`<html><body>Not a sitemap</body></html>`))
+			Expect(err).To(Equal(ErrNotSitemap))
+
+			_, err = Parse(strings.NewReader(`Not XML`))
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Describe("ParseLastMod", func() {
+		It("parses each W3C Datetime format", func() {
+			Expect(ParseLastMod("2014-04-01T12:30:15.5Z")).To(Equal(time.Date(2014, 4, 1, 12, 30, 15, 500000000, time.UTC)))
+			Expect(ParseLastMod("2014-04-01T12:30:15Z")).To(Equal(time.Date(2014, 4, 1, 12, 30, 15, 0, time.UTC)))
+			Expect(ParseLastMod("2014-04-01T12:30Z")).To(Equal(time.Date(2014, 4, 1, 12, 30, 0, 0, time.UTC)))
+			Expect(ParseLastMod("2014-04-01")).To(Equal(time.Date(2014, 4, 1, 0, 0, 0, 0, time.UTC)))
+			Expect(ParseLastMod("2014-04")).To(Equal(time.Date(2014, 4, 1, 0, 0, 0, 0, time.UTC)))
+			Expect(ParseLastMod("2014")).To(Equal(time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("returns the zero time for anything else", func() {
+			Expect(ParseLastMod("")).To(BeZero())
+			Expect(ParseLastMod("yesterday")).To(BeZero())
+		})
+	})
+
+	Describe("RobotsTxtSitemaps", func() {
+		It("returns the sitemaps listed, resolved against the robots.txt URL", func() {
+			robotsURL, _ := url.Parse("https://www.gov.uk/robots.txt")
+			body := []byte("User-agent: *\nDisallow: /search\nSitemap: https://www.gov.uk/sitemap.xml\nsitemap: /sitemaps/extra.xml.gz\n")
+
+			Expect(urlStrings(RobotsTxtSitemaps(body, robotsURL))).To(Equal([]string{
+				"https://www.gov.uk/sitemap.xml",
+				"https://www.gov.uk/sitemaps/extra.xml.gz",
+			}))
+		})
+	})
+})
+
+func urlStrings(urls []*url.URL) []string {
+	strings := make([]string, len(urls))
+	for i, u := range urls {
+		strings[i] = u.String()
+	}
+
+	return strings
+}
@@ -0,0 +1,101 @@
+package sitemap
+
+import (
+	"bytes"
+	"net/url"
+	"time"
+)
+
+// The most sitemaps read in one walk, which stops a sitemap index that
+// lists itself, or an endless chain of them, from being read forever.
+const maxSitemaps = 50000
+
+// A Fetcher returns the body of a URL, such as a sitemap or robots.txt.
+type Fetcher interface {
+	Fetch(u *url.URL) ([]byte, error)
+}
+
+// WalkFunc is called by Walk with each page listed by a sitemap, and with
+// the error for each sitemap that can't be read, in which case entry is
+// nil.
+type WalkFunc func(sitemapURL *url.URL, entry *Entry, err error)
+
+// FromRobotsTxt returns the sitemaps listed by the robots.txt file of the
+// host of rootURL.
+func FromRobotsTxt(fetcher Fetcher, rootURL *url.URL) ([]*url.URL, error) {
+	robotsURL := &url.URL{
+		Scheme: rootURL.Scheme,
+		Host:   rootURL.Host,
+		Path:   "/robots.txt",
+	}
+
+	body, err := fetcher.Fetch(robotsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return RobotsTxtSitemaps(body, robotsURL), nil
+}
+
+// Walk reads each of sitemapURLs, and the sitemaps listed by any sitemap
+// indexes among them, calling walkFn for every page they list. Each
+// sitemap is only read once.
+func Walk(fetcher Fetcher, sitemapURLs []*url.URL, walkFn WalkFunc) {
+	pending := append([]*url.URL{}, sitemapURLs...)
+	seen := make(map[string]bool)
+
+	for len(pending) > 0 && len(seen) < maxSitemaps {
+		sitemapURL := pending[0]
+		pending = pending[1:]
+
+		if seen[sitemapURL.String()] {
+			continue
+		}
+		seen[sitemapURL.String()] = true
+
+		body, err := fetcher.Fetch(sitemapURL)
+		if err != nil {
+			walkFn(sitemapURL, nil, err)
+			continue
+		}
+
+		sitemap, err := Parse(bytes.NewReader(body))
+		if err != nil {
+			walkFn(sitemapURL, nil, err)
+			continue
+		}
+
+		for _, entry := range sitemap.Sitemaps {
+			if child, err := sitemapURL.Parse(entry.Loc); err == nil {
+				pending = append(pending, child)
+			}
+		}
+
+		for i := range sitemap.URLs {
+			walkFn(sitemapURL, &sitemap.URLs[i], nil)
+		}
+	}
+}
+
+// LastModPriority returns a queue priority for a page last modified at
+// lastMod, so that recently changed pages are crawled first: 3 for pages
+// changed in the last day, 2 in the last week, 1 in the last 30 days and
+// 0 otherwise, or when it isn't known.
+func LastModPriority(lastMod time.Time, now time.Time) int {
+	if lastMod.IsZero() {
+		return 0
+	}
+
+	age := now.Sub(lastMod)
+
+	switch {
+	case age < 24*time.Hour:
+		return 3
+	case age < 7*24*time.Hour:
+		return 2
+	case age < 30*24*time.Hour:
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,123 @@
+package sitemap_test
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	. "github.com/alphagov/govuk_crawler_worker/sitemap"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var errMissing = errors.New("Missing")
+
+// mapFetcher is a Fetcher of the bodies in a map, recording the URLs it's
+// asked for.
+type mapFetcher struct {
+	bodies  map[string][]byte
+	fetched []string
+}
+
+func (f *mapFetcher) Fetch(u *url.URL) ([]byte, error) {
+	f.fetched = append(f.fetched, u.String())
+
+	body, ok := f.bodies[u.String()]
+	if !ok {
+		return nil, errMissing
+	}
+
+	return body, nil
+}
+
+var _ = Describe("Walk", func() {
+	var fetcher *mapFetcher
+
+	type visit struct {
+		sitemap string
+		loc     string
+		err     error
+	}
+
+	walk := func(sitemapURLs ...string) []visit {
+		var urls []*url.URL
+		for _, u := range sitemapURLs {
+			parsed, _ := url.Parse(u)
+			urls = append(urls, parsed)
+		}
+
+		var visits []visit
+		Walk(fetcher, urls, func(sitemapURL *url.URL, entry *Entry, err error) {
+			v := visit{sitemap: sitemapURL.String(), err: err}
+			if entry != nil {
+				v.loc = entry.Loc
+			}
+
+			visits = append(visits, v)
+		})
+
+		return visits
+	}
+
+	BeforeEach(func() {
+		fetcher = &mapFetcher{bodies: map[string][]byte{
+			"https://www.gov.uk/sitemap.xml": []byte(`<sitemapindex>
+				<sitemap><loc>https://www.gov.uk/sitemaps/1.xml</loc></sitemap>
+				<sitemap><loc>/sitemaps/2.xml.gz</loc></sitemap>
+				<sitemap><loc>https://www.gov.uk/sitemaps/missing.xml</loc></sitemap>
+				<sitemap><loc>https://www.gov.uk/sitemap.xml</loc></sitemap>
+			</sitemapindex>`),
+			"https://www.gov.uk/sitemaps/1.xml":    []byte(`<urlset><url><loc>https://www.gov.uk/one</loc></url></urlset>`),
+			"https://www.gov.uk/sitemaps/2.xml.gz": gzipped(`<urlset><url><loc>https://www.gov.uk/two</loc></url></urlset>`),
+			"https://www.gov.uk/robots.txt":        []byte("User-agent: *\nSitemap: https://www.gov.uk/sitemap.xml\n"),
+		}}
+	})
+
+	It("visits the pages of every sitemap in an index, and reports those it can't read", func() {
+		Expect(walk("https://www.gov.uk/sitemap.xml")).To(Equal([]visit{
+			{sitemap: "https://www.gov.uk/sitemaps/1.xml", loc: "https://www.gov.uk/one"},
+			{sitemap: "https://www.gov.uk/sitemaps/2.xml.gz", loc: "https://www.gov.uk/two"},
+			{sitemap: "https://www.gov.uk/sitemaps/missing.xml", err: errMissing},
+		}))
+	})
+
+	It("only reads each sitemap once", func() {
+		walk("https://www.gov.uk/sitemap.xml", "https://www.gov.uk/sitemaps/1.xml")
+
+		Expect(fetcher.fetched).To(Equal([]string{
+			"https://www.gov.uk/sitemap.xml",
+			"https://www.gov.uk/sitemaps/1.xml",
+			"https://www.gov.uk/sitemaps/2.xml.gz",
+			"https://www.gov.uk/sitemaps/missing.xml",
+		}))
+	})
+
+	It("reads the sitemaps listed by robots.txt", func() {
+		rootURL, _ := url.Parse("https://www.gov.uk/")
+
+		sitemaps, err := FromRobotsTxt(fetcher, rootURL)
+		Expect(err).To(BeNil())
+		Expect(urlStrings(sitemaps)).To(Equal([]string{"https://www.gov.uk/sitemap.xml"}))
+
+		rootURL, _ = url.Parse("https://assets.publishing.service.gov.uk/")
+
+		_, err = FromRobotsTxt(fetcher, rootURL)
+		Expect(err).To(Equal(errMissing))
+	})
+})
+
+var _ = Describe("LastModPriority", func() {
+	now := time.Date(2014, 4, 30, 12, 0, 0, 0, time.UTC)
+
+	It("prioritises recently modified pages", func() {
+		Expect(LastModPriority(now.Add(-time.Hour), now)).To(Equal(3))
+		Expect(LastModPriority(now.Add(-3*24*time.Hour), now)).To(Equal(2))
+		Expect(LastModPriority(now.Add(-10*24*time.Hour), now)).To(Equal(1))
+		Expect(LastModPriority(now.Add(-60*24*time.Hour), now)).To(Equal(0))
+	})
+
+	It("doesn't prioritise pages when it isn't known when they were modified", func() {
+		Expect(LastModPriority(time.Time{}, now)).To(Equal(0))
+	})
+})
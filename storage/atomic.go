@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Files being written are kept alongside the files they'll replace under
+// names starting with this.
+const tempFilePrefix = ".tmp-"
+
+// WriteFileAtomically writes body to filePath so that anything reading
+// filePath sees either its old contents or the whole of body, never part
+// of it. body is written to a temporary file in the same directory, synced
+// to disk and then renamed over filePath.
+func WriteFileAtomically(filePath string, body io.Reader, perm os.FileMode) error {
+	file, err := ioutil.TempFile(filepath.Dir(filePath), tempFilePrefix+filepath.Base(filePath)+"-")
+	if err != nil {
+		return err
+	}
+
+	err = file.Chmod(perm)
+	if err == nil {
+		_, err = io.Copy(file, body)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filePath)
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
+
+// IsTempFile reports whether filePath is a temporary file written by
+// WriteFileAtomically.
+func IsTempFile(filePath string) bool {
+	return strings.HasPrefix(filepath.Base(filePath), tempFilePrefix)
+}
+
+// RemoveStaleFiles removes the files under root for which match returns
+// true and which haven't been modified for olderThan, returning how many
+// were removed. Recently modified files are left alone, as they may still
+// be being written by another worker.
+func RemoveStaleFiles(root string, olderThan time.Duration, match func(filePath string) bool) (int, error) {
+	removed := 0
+	cutoff := time.Now().Add(-olderThan)
+
+	err := filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if info.IsDir() || !match(filePath) || info.ModTime().After(cutoff) {
+			return nil
+		}
+
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removed++
+
+		return nil
+	})
+
+	return removed, err
+}
@@ -0,0 +1,116 @@
+package storage_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/alphagov/govuk_crawler_worker/storage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// failingReader returns some of a body and then an error, as a body cut
+// short would.
+type failingReader struct {
+	read bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.read {
+		return 0, errors.New("Connection reset")
+	}
+	r.read = true
+
+	return copy(p, "half"), nil
+}
+
+var _ = Describe("Atomic writes", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "atomic_test")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	fileNames := func() []string {
+		infos, err := ioutil.ReadDir(dir)
+		Expect(err).To(BeNil())
+
+		var names []string
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+
+		return names
+	}
+
+	Describe("WriteFileAtomically", func() {
+		It("replaces the file, leaving nothing else behind", func() {
+			filePath := filepath.Join(dir, "page.html")
+			Expect(ioutil.WriteFile(filePath, []byte("old"), 0644)).To(BeNil())
+
+			Expect(WriteFileAtomically(filePath, strings.NewReader("new"), 0644)).To(BeNil())
+
+			Expect(ioutil.ReadFile(filePath)).To(Equal([]byte("new")))
+			Expect(fileNames()).To(Equal([]string{"page.html"}))
+
+			info, err := os.Stat(filePath)
+			Expect(err).To(BeNil())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0644)))
+		})
+
+		It("leaves the old file in place if the write fails", func() {
+			filePath := filepath.Join(dir, "page.html")
+			Expect(ioutil.WriteFile(filePath, []byte("old"), 0644)).To(BeNil())
+
+			Expect(WriteFileAtomically(filePath, &failingReader{}, 0644)).To(MatchError("Connection reset"))
+
+			Expect(ioutil.ReadFile(filePath)).To(Equal([]byte("old")))
+			Expect(fileNames()).To(Equal([]string{"page.html"}))
+		})
+	})
+
+	Describe("RemoveStaleFiles", func() {
+		It("removes matching files that haven't been modified recently", func() {
+			Expect(os.MkdirAll(filepath.Join(dir, "www.gov.uk"), 0755)).To(BeNil())
+
+			stale := filepath.Join(dir, "www.gov.uk", ".tmp-page.html-123")
+			recent := filepath.Join(dir, "www.gov.uk", ".tmp-page.html-456")
+			page := filepath.Join(dir, "www.gov.uk", "page.html")
+
+			for _, filePath := range []string{stale, recent, page} {
+				Expect(ioutil.WriteFile(filePath, []byte("body"), 0644)).To(BeNil())
+			}
+
+			anHourAgo := time.Now().Add(-time.Hour)
+			Expect(os.Chtimes(stale, anHourAgo, anHourAgo)).To(BeNil())
+			Expect(os.Chtimes(page, anHourAgo, anHourAgo)).To(BeNil())
+
+			removed, err := RemoveStaleFiles(dir, time.Minute, IsTempFile)
+			Expect(err).To(BeNil())
+			Expect(removed).To(Equal(1))
+
+			_, err = os.Stat(stale)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+			Expect(recent).To(BeAnExistingFile())
+			Expect(page).To(BeAnExistingFile())
+		})
+
+		It("removes nothing when the root doesn't exist", func() {
+			removed, err := RemoveStaleFiles(filepath.Join(dir, "missing"), time.Minute, IsTempFile)
+
+			Expect(err).To(BeNil())
+			Expect(removed).To(Equal(0))
+		})
+	})
+})
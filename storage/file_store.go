@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileStore keeps bodies as files under a root directory, so that the
+// directory can be served as the mirror.
+type FileStore struct {
+	root string
+}
+
+func NewFileStore(root string) *FileStore {
+	return &FileStore{root: root}
+}
+
+// Put writes body atomically, so that a web server serving the root never
+// serves part of it.
+func (f *FileStore) Put(key string, body io.Reader) error {
+	filePath, err := f.createPath(key)
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomically(filePath, body, 0644)
+}
+
+// PutFile moves the file at filePath into place, which needs it to be on
+// the same filesystem as the store. Renaming is atomic, so as long as the
+// file has been synced it never appears part written.
+func (f *FileStore) PutFile(key string, filePath string) error {
+	destination, err := f.createPath(key)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(filePath, destination)
+}
+
+func (f *FileStore) Get(key string) (io.ReadCloser, error) {
+	filePath, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return file, err
+}
+
+func (f *FileStore) Exists(key string) (bool, error) {
+	filePath, err := f.path(key)
+	if err != nil {
+		return false, err
+	}
+
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return !info.IsDir(), nil
+}
+
+func (f *FileStore) Delete(key string) error {
+	filePath, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (f *FileStore) List(prefix string) ([]string, error) {
+	var keys []string
+
+	err := filepath.Walk(f.root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && filePath == f.root {
+				return filepath.SkipDir
+			}
+
+			return err
+		}
+
+		if info.IsDir() || IsTempFile(filePath) {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(f.root, filePath)
+		if err != nil {
+			return err
+		}
+
+		if key := filepath.ToSlash(relativePath); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+
+	// Walking visits `a/b` before `a.b`, which sorts first.
+	sort.Strings(keys)
+
+	return keys, err
+}
+
+func (f *FileStore) path(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(f.root, filepath.FromSlash(key)), nil
+}
+
+// createPath returns the path of key, creating the directories it's in.
+func (f *FileStore) createPath(key string) (string, error) {
+	filePath, err := f.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	return filePath, os.MkdirAll(filepath.Dir(filePath), 0755)
+}
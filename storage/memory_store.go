@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStore keeps bodies in memory, for tests and dry runs which don't
+// need to keep the mirror.
+type MemoryStore struct {
+	mutex  sync.RWMutex
+	bodies map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{bodies: make(map[string][]byte)}
+}
+
+func (m *MemoryStore) Put(key string, body io.Reader) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+
+	contents, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.bodies[key] = contents
+
+	return nil
+}
+
+func (m *MemoryStore) Get(key string) (io.ReadCloser, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	contents, ok := m.bodies[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(contents)), nil
+}
+
+func (m *MemoryStore) Exists(key string) (bool, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return false, err
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	_, ok := m.bodies[key]
+
+	return ok, nil
+}
+
+func (m *MemoryStore) Delete(key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.bodies, key)
+
+	return nil
+}
+
+func (m *MemoryStore) List(prefix string) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var keys []string
+	for key := range m.bodies {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
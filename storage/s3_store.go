@@ -0,0 +1,391 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	amzDateFormat   = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+var emptyPayloadHash = sha256Hex(nil)
+
+type S3Options struct {
+	// Endpoint is the base URL of the service, such as
+	// `https://s3.eu-west-2.amazonaws.com`.
+	Endpoint string
+	// Bucket is added to the path of the endpoint. It can be left empty
+	// when the endpoint names the bucket itself, as in
+	// `https://mirror.s3.eu-west-2.amazonaws.com`.
+	Bucket string
+	Region string
+	// Prefix is added to every key, to keep the mirror in part of a
+	// bucket.
+	Prefix string
+
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// S3Store keeps bodies as objects in an S3 compatible object store, signing
+// requests with AWS Signature Version 4.
+type S3Store struct {
+	endpoint *url.URL
+	region   string
+	prefix   string
+
+	accessKeyID     string
+	secretAccessKey string
+
+	client *http.Client
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func NewS3Store(options S3Options) (*S3Store, error) {
+	endpoint, err := url.Parse(options.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid S3 endpoint: %s", options.Endpoint)
+	}
+
+	if options.Region == "" {
+		return nil, fmt.Errorf("No region given for S3 endpoint: %s", options.Endpoint)
+	}
+
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/")
+	if options.Bucket != "" {
+		endpoint.Path += "/" + options.Bucket
+	}
+
+	prefix := strings.Trim(options.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &S3Store{
+		endpoint: endpoint,
+		region:   options.Region,
+		prefix:   prefix,
+
+		accessKeyID:     options.AccessKeyID,
+		secretAccessKey: options.SecretAccessKey,
+
+		client: &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// Put uploads body as the object at key, with a Content-Type guessed from
+// the extension of the key. Files are streamed without signing their
+// contents, everything else is read into memory first.
+func (s *S3Store) Put(key string, body io.Reader) error {
+	req, err := s.newRequest("PUT", key)
+	if err != nil {
+		return err
+	}
+
+	payloadHash := unsignedPayload
+
+	if file, ok := body.(*os.File); ok {
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+
+		// The file is closed by the caller, not by the transport.
+		req.Body = ioutil.NopCloser(file)
+		req.ContentLength = info.Size()
+	} else {
+		contents, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+
+		req.Body = ioutil.NopCloser(bytes.NewReader(contents))
+		req.ContentLength = int64(len(contents))
+		payloadHash = sha256Hex(contents)
+	}
+
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := s.do(req, payloadHash)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func (s *S3Store) Get(key string) (io.ReadCloser, error) {
+	req, err := s.newRequest("GET", key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *S3Store) Exists(key string) (bool, error) {
+	req, err := s.newRequest("HEAD", key)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := s.do(req, emptyPayloadHash)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, resp.Body.Close()
+}
+
+func (s *S3Store) Delete(key string) error {
+	req, err := s.newRequest("DELETE", key)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req, emptyPayloadHash)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// List pages through ListObjectsV2 until every key has been returned.
+func (s *S3Store) List(prefix string) ([]string, error) {
+	var keys []string
+	continuationToken := ""
+
+	for {
+		query := url.Values{
+			"list-type": []string{"2"},
+			"prefix":    []string{s.prefix + prefix},
+		}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		req, err := s.newBucketRequest("GET", "", query)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := s.do(req, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+
+		result := &s3ListResult{}
+		err = xml.NewDecoder(resp.Body).Decode(result)
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(object.Key, s.prefix))
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// newRequest returns a request for the object at key.
+func (s *S3Store) newRequest(method string, key string) (*http.Request, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.newBucketRequest(method, s.prefix+key, nil)
+}
+
+// newBucketRequest returns a request for objectPath within the bucket, which
+// is the bucket itself if it's empty.
+func (s *S3Store) newBucketRequest(method string, objectPath string, query url.Values) (*http.Request, error) {
+	requestURL := *s.endpoint
+	requestURL.Path += "/" + objectPath
+	requestURL.RawPath = uriEncode(requestURL.Path, false)
+	requestURL.RawQuery = canonicalQuery(query)
+
+	return http.NewRequest(method, requestURL.String(), nil)
+}
+
+// do signs and sends req, returning ErrNotFound for a 404 and an error for
+// any other unsuccessful response.
+func (s *S3Store) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	s.sign(req, payloadHash, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	return nil, fmt.Errorf("S3 %s %s failed: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(message)))
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req, signing
+// the host and every header already set.
+func (s *S3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format(amzDateFormat)
+	scope := strings.Join([]string{amzDate[:8], s.region, "s3", "aws4_request"}, "/")
+
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), amzDate[:8])
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
+
+// canonicalQuery encodes a query as Signature Version 4 expects, sorted by
+// name with every reserved character escaped.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var params []string
+	for _, name := range names {
+		values := append([]string{}, query[name]...)
+		sort.Strings(values)
+
+		for _, value := range values {
+			params = append(params, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+// uriEncode escapes everything but unreserved characters, and slashes
+// unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var encoded strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			encoded.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			encoded.WriteByte(c)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+
+	return encoded.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,173 @@
+package storage_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	. "github.com/alphagov/govuk_crawler_worker/storage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeS3 is a stand-in for an S3 compatible object store, which keeps
+// objects of a single bucket in memory and lists them two at a time.
+type fakeS3 struct {
+	bucket string
+
+	mutex        sync.Mutex
+	objects      map[string][]byte
+	contentTypes map[string]string
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{
+		bucket:       bucket,
+		objects:      make(map[string][]byte),
+		contentTypes: make(map[string]string),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access-key/") ||
+		!strings.Contains(r.Header.Get("Authorization"), "/eu-west-2/s3/aws4_request, SignedHeaders=") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/"+f.bucket) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+f.bucket), "/")
+
+	switch {
+	case key == "" && r.Method == "GET" && r.URL.Query().Get("list-type") == "2":
+		f.list(w, r.URL.Query().Get("prefix"), r.URL.Query().Get("continuation-token"))
+	case r.Method == "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+
+		if payloadHash := r.Header.Get("X-Amz-Content-Sha256"); payloadHash != "UNSIGNED-PAYLOAD" {
+			sum := sha256.Sum256(body)
+			if payloadHash != hex.EncodeToString(sum[:]) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		f.objects[key] = body
+		f.contentTypes[key] = r.Header.Get("Content-Type")
+	case r.Method == "GET" || r.Method == "HEAD":
+		body, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Write(body)
+	case r.Method == "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string, continuationToken string) {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start, _ := strconv.Atoi(continuationToken)
+	end := start + 2
+	if end > len(keys) {
+		end = len(keys)
+	}
+
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult>`)
+	for _, key := range keys[start:end] {
+		fmt.Fprint(w, "<Contents><Key>")
+		xml.EscapeText(w, []byte(key))
+		fmt.Fprint(w, "</Key></Contents>")
+	}
+	if end < len(keys) {
+		fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>", end)
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+var _ = Describe("S3Store", func() {
+	var (
+		fake   *fakeS3
+		server *httptest.Server
+	)
+
+	newStore := func(prefix string) *S3Store {
+		store, err := NewS3Store(S3Options{
+			Endpoint:        server.URL,
+			Bucket:          "mirror",
+			Region:          "eu-west-2",
+			Prefix:          prefix,
+			AccessKeyID:     "access-key",
+			SecretAccessKey: "secret-key",
+		})
+		Expect(err).To(BeNil())
+
+		return store
+	}
+
+	BeforeEach(func() {
+		fake = newFakeS3("mirror")
+		server = httptest.NewServer(fake)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	behavesLikeAStore(func() Store {
+		return newStore("")
+	})
+
+	It("keeps objects under its prefix, with a Content-Type for their extension", func() {
+		store := newStore("/crawls/latest/")
+		Expect(store.Put("www.gov.uk/foo.html", strings.NewReader("foo"))).To(BeNil())
+
+		Expect(fake.objects).To(HaveKey("crawls/latest/www.gov.uk/foo.html"))
+		Expect(fake.contentTypes["crawls/latest/www.gov.uk/foo.html"]).To(HavePrefix("text/html"))
+		Expect(store.List("www.gov.uk/")).To(Equal([]string{"www.gov.uk/foo.html"}))
+	})
+
+	It("returns an error for unsuccessful requests", func() {
+		store, err := NewS3Store(S3Options{Endpoint: server.URL, Bucket: "other", Region: "eu-west-2"})
+		Expect(err).To(BeNil())
+
+		err = store.Put("www.gov.uk/foo.html", strings.NewReader("foo"))
+		Expect(err).To(MatchError(ContainSubstring("403 Forbidden")))
+	})
+
+	It("needs an HTTP endpoint and a region", func() {
+		_, err := NewS3Store(S3Options{Endpoint: "s3.eu-west-2.amazonaws.com", Region: "eu-west-2"})
+		Expect(err).ToNot(BeNil())
+
+		_, err = NewS3Store(S3Options{Endpoint: "https://s3.eu-west-2.amazonaws.com"})
+		Expect(err).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// The storage backends which can be configured.
+const (
+	FileBackend   = "filesystem"
+	MemoryBackend = "memory"
+	S3Backend     = "s3"
+)
+
+var (
+	ErrInvalidKey = errors.New("Key is empty or refers outside of the store")
+	ErrNotFound   = errors.New("Key not found in store")
+)
+
+// Store keeps the files of the mirror under keys, which are slash
+// separated paths relative to the root of the mirror, such as
+// `www.gov.uk/bank-holidays.html`.
+type Store interface {
+	// Put writes body to key, replacing anything already there.
+	Put(key string, body io.Reader) error
+	// Get returns a reader of the body at key, or ErrNotFound.
+	Get(key string) (io.ReadCloser, error)
+	// Exists reports whether there's a body at key.
+	Exists(key string) (bool, error)
+	// Delete removes the body at key. Deleting a missing key isn't an
+	// error.
+	Delete(key string) error
+	// List returns every key starting with prefix, in lexical order.
+	List(prefix string) ([]string, error)
+}
+
+// FilePutter is implemented by stores which can take ownership of a local
+// file more cheaply than by reading it, for example by renaming it.
+type FilePutter interface {
+	PutFile(key string, filePath string) error
+}
+
+// PutFile writes the file at filePath to key and removes it, using the
+// store's own PutFile if it has one.
+func PutFile(store Store, key string, filePath string) error {
+	if putter, ok := store.(FilePutter); ok {
+		return putter.PutFile(key, filePath)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+
+	err = store.Put(key, file)
+	file.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(filePath)
+}
+
+// cleanKey returns key in a canonical form, or ErrInvalidKey if it's empty
+// or has `..` segments which could take it outside of the store.
+func cleanKey(key string) (string, error) {
+	key = strings.TrimPrefix(key, "/")
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return "", ErrInvalidKey
+		}
+	}
+
+	cleaned := path.Clean(key)
+	if cleaned == "." {
+		return "", ErrInvalidKey
+	}
+
+	return cleaned, nil
+}
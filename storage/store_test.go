@@ -0,0 +1,152 @@
+package storage_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/alphagov/govuk_crawler_worker/storage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func readKey(store Store, key string) string {
+	body, err := store.Get(key)
+	Expect(err).To(BeNil())
+	defer body.Close()
+
+	contents, err := ioutil.ReadAll(body)
+	Expect(err).To(BeNil())
+
+	return string(contents)
+}
+
+// behavesLikeAStore describes what every Store does, for the store
+// returned by newStore.
+func behavesLikeAStore(newStore func() Store) {
+	var store Store
+
+	BeforeEach(func() {
+		store = newStore()
+	})
+
+	It("gets what was put", func() {
+		Expect(store.Put("www.gov.uk/foo.html", strings.NewReader("foo"))).To(BeNil())
+		Expect(readKey(store, "www.gov.uk/foo.html")).To(Equal("foo"))
+
+		Expect(store.Put("www.gov.uk/foo.html", strings.NewReader("bar"))).To(BeNil())
+		Expect(readKey(store, "www.gov.uk/foo.html")).To(Equal("bar"))
+	})
+
+	It("keeps keys with characters that need escaping", func() {
+		key := "www.gov.uk/search@page=2&q=a+b.html"
+
+		Expect(store.Put(key, strings.NewReader("results"))).To(BeNil())
+		Expect(readKey(store, key)).To(Equal("results"))
+		Expect(store.List("www.gov.uk/search")).To(Equal([]string{key}))
+	})
+
+	It("returns ErrNotFound for missing keys", func() {
+		_, err := store.Get("www.gov.uk/missing.html")
+		Expect(err).To(Equal(ErrNotFound))
+	})
+
+	It("reports whether keys exist", func() {
+		Expect(store.Put("www.gov.uk/foo.html", strings.NewReader("foo"))).To(BeNil())
+
+		Expect(store.Exists("www.gov.uk/foo.html")).To(BeTrue())
+		Expect(store.Exists("www.gov.uk/missing.html")).To(BeFalse())
+	})
+
+	It("deletes keys, whether or not they exist", func() {
+		Expect(store.Put("www.gov.uk/foo.html", strings.NewReader("foo"))).To(BeNil())
+
+		Expect(store.Delete("www.gov.uk/foo.html")).To(BeNil())
+		Expect(store.Exists("www.gov.uk/foo.html")).To(BeFalse())
+		Expect(store.Delete("www.gov.uk/foo.html")).To(BeNil())
+	})
+
+	It("lists keys by prefix in lexical order", func() {
+		for _, key := range []string{"www.gov.uk/b.html", "www.gov.uk/a/c.html", "www.gov.uk/a.html", "assets.gov.uk/a.css"} {
+			Expect(store.Put(key, strings.NewReader(key))).To(BeNil())
+		}
+
+		Expect(store.List("www.gov.uk/")).To(Equal([]string{
+			"www.gov.uk/a.html",
+			"www.gov.uk/a/c.html",
+			"www.gov.uk/b.html",
+		}))
+		Expect(store.List("www.gov.uk/a")).To(HaveLen(2))
+		Expect(store.List("nothing/")).To(BeEmpty())
+	})
+
+	It("refuses keys outside of the store", func() {
+		Expect(store.Put("../escaped.html", strings.NewReader("foo"))).To(Equal(ErrInvalidKey))
+		Expect(store.Put("", strings.NewReader("foo"))).To(Equal(ErrInvalidKey))
+
+		_, err := store.Get("www.gov.uk/../../escaped.html")
+		Expect(err).To(Equal(ErrInvalidKey))
+	})
+
+	It("puts files, removing them afterwards", func() {
+		file, err := ioutil.TempFile("", "storage_test")
+		Expect(err).To(BeNil())
+		file.WriteString("streamed")
+		file.Close()
+
+		Expect(PutFile(store, "www.gov.uk/streamed.pdf", file.Name())).To(BeNil())
+
+		Expect(readKey(store, "www.gov.uk/streamed.pdf")).To(Equal("streamed"))
+		_, err = os.Stat(file.Name())
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+}
+
+var _ = Describe("MemoryStore", func() {
+	behavesLikeAStore(func() Store {
+		return NewMemoryStore()
+	})
+})
+
+var _ = Describe("FileStore", func() {
+	var root string
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "file_store_test")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
+	})
+
+	behavesLikeAStore(func() Store {
+		return NewFileStore(root)
+	})
+
+	It("keeps bodies as files under its root", func() {
+		store := NewFileStore(root)
+		Expect(store.Put("www.gov.uk/foo/bar.html", strings.NewReader("bar"))).To(BeNil())
+
+		contents, err := ioutil.ReadFile(filepath.Join(root, "www.gov.uk", "foo", "bar.html"))
+		Expect(err).To(BeNil())
+		Expect(string(contents)).To(Equal("bar"))
+	})
+
+	It("doesn't list files that are still being written", func() {
+		store := NewFileStore(root)
+		Expect(store.Put("www.gov.uk/foo.html", strings.NewReader("foo"))).To(BeNil())
+		Expect(ioutil.WriteFile(filepath.Join(root, "www.gov.uk", ".tmp-bar.html-123"), []byte("ba"), 0644)).To(BeNil())
+
+		Expect(store.List("")).To(Equal([]string{"www.gov.uk/foo.html"}))
+	})
+
+	It("lists nothing when its root doesn't exist yet", func() {
+		store := NewFileStore(filepath.Join(root, "missing"))
+
+		Expect(store.List("")).To(BeEmpty())
+	})
+})
@@ -0,0 +1,292 @@
+package url_normaliser
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// The rules a Normaliser can apply, as named in the list passed to New.
+const (
+	// Lower case the scheme and host, which are case insensitive.
+	LowercaseRule = "lowercase"
+	// Remove the default port for the scheme, and give an empty path
+	// of an http(s) URL as `/`.
+	SchemeDefaultsRule = "scheme-defaults"
+	// Resolve `.` and `..` segments of the path.
+	DotSegmentsRule = "dot-segments"
+	// Decode percent-encoded unreserved characters, and upper case the
+	// hex digits of all other percent-encodings.
+	PercentEncodingRule = "percent-encoding"
+	// Sort query parameters by name, keeping the order of repeated ones.
+	SortQueryRule = "sort-query"
+)
+
+// What a Normaliser does with a trailing slash at the end of a path.
+const (
+	TrailingSlashKeep   = "keep"
+	TrailingSlashAdd    = "add"
+	TrailingSlashRemove = "remove"
+)
+
+// DefaultRules are the rules applied when no others are configured.
+var DefaultRules = []string{
+	LowercaseRule,
+	SchemeDefaultsRule,
+	DotSegmentsRule,
+	PercentEncodingRule,
+	SortQueryRule,
+}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Normaliser rewrites URLs into a canonical form, so that URLs which refer
+// to the same page are only stored and crawled once. A nil *Normaliser
+// leaves URLs as they are.
+type Normaliser struct {
+	lowercase       bool
+	schemeDefaults  bool
+	dotSegments     bool
+	percentEncoding bool
+	sortQuery       bool
+	trailingSlash   string
+}
+
+// New returns a Normaliser applying the named rules. trailingSlash is one
+// of TrailingSlashKeep, TrailingSlashAdd and TrailingSlashRemove, with an
+// empty string meaning keep.
+func New(rules []string, trailingSlash string) (*Normaliser, error) {
+	n := &Normaliser{trailingSlash: trailingSlash}
+
+	for _, rule := range rules {
+		switch strings.TrimSpace(rule) {
+		case LowercaseRule:
+			n.lowercase = true
+		case SchemeDefaultsRule:
+			n.schemeDefaults = true
+		case DotSegmentsRule:
+			n.dotSegments = true
+		case PercentEncodingRule:
+			n.percentEncoding = true
+		case SortQueryRule:
+			n.sortQuery = true
+		case "":
+		default:
+			return nil, fmt.Errorf("Unknown URL normalisation rule: %s", rule)
+		}
+	}
+
+	switch trailingSlash {
+	case "":
+		n.trailingSlash = TrailingSlashKeep
+	case TrailingSlashKeep, TrailingSlashAdd, TrailingSlashRemove:
+	default:
+		return nil, fmt.Errorf("Unknown trailing slash policy: %s", trailingSlash)
+	}
+
+	return n, nil
+}
+
+// Normalise returns a normalised copy of u.
+func (n *Normaliser) Normalise(u *url.URL) *url.URL {
+	if n == nil || u == nil {
+		return u
+	}
+
+	normalised := *u
+	if u.User != nil {
+		user := *u.User
+		normalised.User = &user
+	}
+
+	if n.lowercase {
+		normalised.Scheme = strings.ToLower(normalised.Scheme)
+		normalised.Host = strings.ToLower(normalised.Host)
+	}
+
+	scheme := strings.ToLower(normalised.Scheme)
+
+	if n.schemeDefaults {
+		if port, ok := defaultPorts[scheme]; ok {
+			normalised.Host = strings.TrimSuffix(normalised.Host, ":"+port)
+
+			if normalised.Path == "" && normalised.Opaque == "" {
+				normalised.Path = "/"
+			}
+		}
+	}
+
+	if normalised.Opaque == "" {
+		escapedPath := normalised.EscapedPath()
+
+		if n.percentEncoding {
+			escapedPath = normalisePercentEncoding(escapedPath)
+		}
+		if n.dotSegments {
+			escapedPath = removeDotSegments(escapedPath)
+		}
+
+		escapedPath = n.applyTrailingSlash(escapedPath)
+		setEscapedPath(&normalised, escapedPath)
+	}
+
+	if n.percentEncoding {
+		normalised.RawQuery = normalisePercentEncoding(normalised.RawQuery)
+	}
+	if n.sortQuery {
+		normalised.RawQuery = sortQuery(normalised.RawQuery)
+	}
+
+	return &normalised
+}
+
+// NormaliseString parses and normalises a URL, returning it as a string.
+func (n *Normaliser) NormaliseString(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	return n.Normalise(u).String(), nil
+}
+
+// Paths whose last segment looks like a file name are left as they are
+// when adding a trailing slash.
+func (n *Normaliser) applyTrailingSlash(escapedPath string) string {
+	switch n.trailingSlash {
+	case TrailingSlashAdd:
+		if !strings.HasSuffix(escapedPath, "/") && path.Ext(escapedPath) == "" {
+			return escapedPath + "/"
+		}
+	case TrailingSlashRemove:
+		if len(escapedPath) > 1 {
+			return strings.TrimRight(escapedPath, "/")
+		}
+	}
+
+	return escapedPath
+}
+
+func setEscapedPath(u *url.URL, escapedPath string) {
+	unescaped, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		// Leave paths with invalid escapes alone.
+		return
+	}
+
+	u.Path = unescaped
+	u.RawPath = escapedPath
+}
+
+// removeDotSegments implements the algorithm of the same name from
+// section 5.2.4 of RFC 3986, keeping a trailing slash where the last
+// segment was a dot segment.
+func removeDotSegments(escapedPath string) string {
+	if !strings.HasPrefix(escapedPath, "/") {
+		return escapedPath
+	}
+
+	segments := strings.Split(escapedPath, "/")
+	output := make([]string, 0, len(segments))
+	last := len(segments) - 1
+
+	for i, segment := range segments {
+		switch segment {
+		case ".":
+		case "..":
+			if len(output) > 1 {
+				output = output[:len(output)-1]
+			}
+		default:
+			output = append(output, segment)
+			continue
+		}
+
+		if i == last {
+			output = append(output, "")
+		}
+	}
+
+	return strings.Join(output, "/")
+}
+
+// normalisePercentEncoding decodes percent-encoded unreserved characters
+// and upper cases the hex digits of every other percent-encoding, as in
+// section 6.2.2 of RFC 3986. Invalid percent-encodings are left alone.
+func normalisePercentEncoding(escaped string) string {
+	if !strings.Contains(escaped, "%") {
+		return escaped
+	}
+
+	normalised := make([]byte, 0, len(escaped))
+
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] != '%' || i+2 >= len(escaped) || !isHex(escaped[i+1]) || !isHex(escaped[i+2]) {
+			normalised = append(normalised, escaped[i])
+			continue
+		}
+
+		decoded := unhex(escaped[i+1])<<4 | unhex(escaped[i+2])
+		if isUnreserved(decoded) {
+			normalised = append(normalised, decoded)
+		} else {
+			normalised = append(normalised, '%', upper(escaped[i+1]), upper(escaped[i+2]))
+		}
+
+		i += 2
+	}
+
+	return string(normalised)
+}
+
+// sortQuery sorts the parameters of a query by name without decoding and
+// re-encoding them, so that the query otherwise stays as it was.
+func sortQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+
+	params := strings.Split(rawQuery, "&")
+	sort.SliceStable(params, func(i, j int) bool {
+		return queryParamName(params[i]) < queryParamName(params[j])
+	})
+
+	return strings.Join(params, "&")
+}
+
+func queryParamName(param string) string {
+	return strings.SplitN(param, "=", 2)[0]
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+
+	return c - 'A' + 10
+}
+
+func upper(c byte) byte {
+	if 'a' <= c && c <= 'f' {
+		return c - 'a' + 'A'
+	}
+
+	return c
+}
@@ -0,0 +1,13 @@
+package url_normaliser_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestURLNormaliser(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "URLNormaliser Suite")
+}
@@ -0,0 +1,127 @@
+package url_normaliser_test
+
+import (
+	"net/url"
+
+	. "github.com/alphagov/govuk_crawler_worker/url_normaliser"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Normaliser", func() {
+	var normaliser *Normaliser
+
+	BeforeEach(func() {
+		var err error
+
+		normaliser, err = New(DefaultRules, TrailingSlashKeep)
+		Expect(err).To(BeNil())
+	})
+
+	normalise := func(n *Normaliser, rawURL string) string {
+		normalised, err := n.NormaliseString(rawURL)
+		Expect(err).To(BeNil())
+
+		return normalised
+	}
+
+	It("returns an error for unknown rules", func() {
+		_, err := New([]string{"lowercase", "shout"}, "")
+		Expect(err).To(MatchError("Unknown URL normalisation rule: shout"))
+	})
+
+	It("returns an error for unknown trailing slash policies", func() {
+		_, err := New(DefaultRules, "sometimes")
+		Expect(err).To(MatchError("Unknown trailing slash policy: sometimes"))
+	})
+
+	It("leaves URLs alone when it's nil", func() {
+		var nilNormaliser *Normaliser
+		u, _ := url.Parse("HTTPS://WWW.GOV.UK:443/a/../b")
+
+		Expect(nilNormaliser.Normalise(u)).To(BeIdenticalTo(u))
+	})
+
+	It("doesn't change the URL it's given", func() {
+		u, _ := url.Parse("https://WWW.GOV.UK:443/a/../b")
+		normaliser.Normalise(u)
+
+		Expect(u.String()).To(Equal("https://WWW.GOV.UK:443/a/../b"))
+	})
+
+	It("lower cases the scheme and host but not the path", func() {
+		Expect(normalise(normaliser, "HTTPS://WWW.GOV.UK/Foo")).To(Equal("https://www.gov.uk/Foo"))
+	})
+
+	It("removes default ports", func() {
+		Expect(normalise(normaliser, "https://www.gov.uk:443/foo")).To(Equal("https://www.gov.uk/foo"))
+		Expect(normalise(normaliser, "http://www.gov.uk:80/foo")).To(Equal("http://www.gov.uk/foo"))
+		Expect(normalise(normaliser, "http://www.gov.uk:443/foo")).To(Equal("http://www.gov.uk:443/foo"))
+		Expect(normalise(normaliser, "https://www.gov.uk:8443/foo")).To(Equal("https://www.gov.uk:8443/foo"))
+	})
+
+	It("gives an empty path as /", func() {
+		Expect(normalise(normaliser, "https://www.gov.uk")).To(Equal("https://www.gov.uk/"))
+	})
+
+	It("removes dot segments", func() {
+		Expect(normalise(normaliser, "https://www.gov.uk/a/b/../c/./d")).To(Equal("https://www.gov.uk/a/c/d"))
+		Expect(normalise(normaliser, "https://www.gov.uk/a/b/..")).To(Equal("https://www.gov.uk/a/"))
+		Expect(normalise(normaliser, "https://www.gov.uk/../../a")).To(Equal("https://www.gov.uk/a"))
+	})
+
+	It("normalises percent-encoding", func() {
+		Expect(normalise(normaliser, "https://www.gov.uk/%7Efoo/%41%62c")).To(Equal("https://www.gov.uk/~foo/Abc"))
+		Expect(normalise(normaliser, "https://www.gov.uk/a%2fb%c3%a9")).To(Equal("https://www.gov.uk/a%2Fb%C3%A9"))
+		Expect(normalise(normaliser, "https://www.gov.uk/search?q=%7efoo%2b")).To(Equal("https://www.gov.uk/search?q=~foo%2B"))
+	})
+
+	It("sorts query parameters by name, keeping repeated ones in order", func() {
+		Expect(normalise(normaliser, "https://www.gov.uk/search?q=tax&b=2&a=1&b=1")).
+			To(Equal("https://www.gov.uk/search?a=1&b=2&b=1&q=tax"))
+	})
+
+	It("treats differently written URLs of the same page as the same", func() {
+		variants := []string{
+			"https://www.gov.uk/foo",
+			"HTTPS://WWW.GOV.UK/foo",
+			"https://www.gov.uk:443/foo",
+			"https://www.gov.uk/bar/../foo",
+			"https://www.gov.uk/%66oo",
+		}
+
+		for _, variant := range variants {
+			Expect(normalise(normaliser, variant)).To(Equal("https://www.gov.uk/foo"))
+		}
+	})
+
+	It("only applies the rules it's given", func() {
+		n, err := New([]string{LowercaseRule}, "")
+		Expect(err).To(BeNil())
+
+		Expect(normalise(n, "HTTPS://WWW.GOV.UK:443/a/../%7e?b=1&a=1")).To(Equal("https://www.gov.uk:443/a/../%7e?b=1&a=1"))
+	})
+
+	Describe("trailing slashes", func() {
+		It("keeps them by default", func() {
+			Expect(normalise(normaliser, "https://www.gov.uk/foo/")).To(Equal("https://www.gov.uk/foo/"))
+			Expect(normalise(normaliser, "https://www.gov.uk/foo")).To(Equal("https://www.gov.uk/foo"))
+		})
+
+		It("adds them to paths which don't look like files", func() {
+			n, _ := New(DefaultRules, TrailingSlashAdd)
+
+			Expect(normalise(n, "https://www.gov.uk/foo")).To(Equal("https://www.gov.uk/foo/"))
+			Expect(normalise(n, "https://www.gov.uk/foo/")).To(Equal("https://www.gov.uk/foo/"))
+			Expect(normalise(n, "https://www.gov.uk/style.css")).To(Equal("https://www.gov.uk/style.css"))
+		})
+
+		It("removes them from everything but the root", func() {
+			n, _ := New(DefaultRules, TrailingSlashRemove)
+
+			Expect(normalise(n, "https://www.gov.uk/foo/")).To(Equal("https://www.gov.uk/foo"))
+			Expect(normalise(n, "https://www.gov.uk/")).To(Equal("https://www.gov.uk/"))
+		})
+	})
+})
@@ -0,0 +1,236 @@
+package url_rules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/alphagov/govuk_crawler_worker/util"
+)
+
+// Rules is an ordered list of include and exclude rules deciding which
+// URLs are crawled. The first rule matching a URL decides whether it's
+// excluded, and URLs which match no rule are included. A nil *Rules
+// excludes nothing.
+//
+// Rules are written one per line, as `include` or `exclude` followed by
+// the conditions a URL must all meet for the rule to match:
+//
+//	# Attachments are crawled, but nothing else under uploads.
+//	include prefix=/government/uploads/system/uploads/attachment_data ext=pdf,csv
+//	exclude prefix=/government/uploads
+//	exclude host=www.gov.uk glob=/government/**/print
+//	exclude regex=^/search(/|$)
+//
+// The conditions are:
+//
+//	host=   the host, which may start with `*.` to match any subdomain
+//	prefix= the start of the path
+//	glob=   the whole path, where `*` and `?` match within a segment and
+//	        `**` matches across segments
+//	regex=  a regular expression matched against the path
+//	ext=    a comma separated list of file extensions of the path
+//
+// Blank lines and lines starting with `#` are ignored.
+type Rules struct {
+	rules []*rule
+}
+
+type rule struct {
+	include    bool
+	host       string
+	prefix     string
+	pattern    *regexp.Regexp
+	extensions []string
+}
+
+// Parse reads rules from r.
+func Parse(r io.Reader) (*Rules, error) {
+	rules := &Rules{}
+	scanner := bufio.NewScanner(r)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule, err := parseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid URL rule on line %d: %s", lineNumber, err)
+		}
+
+		rules.rules = append(rules.rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// LoadFile reads rules from the file at filePath.
+func LoadFile(filePath string) (*Rules, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// ExcludePrefixes returns rules excluding every URL whose path starts with
+// one of prefixes.
+func ExcludePrefixes(prefixes []string) *Rules {
+	rules := &Rules{}
+
+	for _, prefix := range prefixes {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			rules.rules = append(rules.rules, &rule{prefix: prefix})
+		}
+	}
+
+	return rules
+}
+
+// Combine returns the rules of each of rulesets in turn, so that those
+// given first take precedence.
+func Combine(rulesets ...*Rules) *Rules {
+	combined := &Rules{}
+
+	for _, rules := range rulesets {
+		if rules != nil {
+			combined.rules = append(combined.rules, rules.rules...)
+		}
+	}
+
+	return combined
+}
+
+// Excludes reports whether u shouldn't be crawled.
+func (r *Rules) Excludes(u *url.URL) bool {
+	if r == nil {
+		return false
+	}
+
+	for _, rule := range r.rules {
+		if rule.matches(u) {
+			return !rule.include
+		}
+	}
+
+	return false
+}
+
+func parseRule(line string) (*rule, error) {
+	fields := strings.Fields(line)
+	r := &rule{}
+
+	switch fields[0] {
+	case "include":
+		r.include = true
+	case "exclude":
+	default:
+		return nil, fmt.Errorf("expected include or exclude: %s", fields[0])
+	}
+
+	if len(fields) == 1 {
+		return nil, fmt.Errorf("no conditions: %s", line)
+	}
+
+	for _, field := range fields[1:] {
+		keyAndValue := strings.SplitN(field, "=", 2)
+		if len(keyAndValue) != 2 || keyAndValue[1] == "" {
+			return nil, fmt.Errorf("expected condition=value: %s", field)
+		}
+
+		key, value := keyAndValue[0], keyAndValue[1]
+		var err error
+
+		switch key {
+		case "host":
+			r.host = strings.ToLower(value)
+		case "prefix":
+			r.prefix = value
+		case "glob":
+			r.pattern, err = compileGlob(value)
+		case "regex":
+			r.pattern, err = regexp.Compile(value)
+		case "ext":
+			for _, extension := range strings.Split(value, ",") {
+				r.extensions = append(r.extensions, "."+strings.ToLower(strings.TrimPrefix(extension, ".")))
+			}
+		default:
+			return nil, fmt.Errorf("unknown condition: %s", key)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+func (r *rule) matches(u *url.URL) bool {
+	if r.host != "" && !hostMatches(r.host, u.Hostname()) {
+		return false
+	}
+
+	if r.prefix != "" && !strings.HasPrefix(u.Path, r.prefix) {
+		return false
+	}
+
+	if r.pattern != nil && !r.pattern.MatchString(u.Path) {
+		return false
+	}
+
+	if len(r.extensions) > 0 && !util.ContainsString(r.extensions, strings.ToLower(path.Ext(u.Path))) {
+		return false
+	}
+
+	return true
+}
+
+func hostMatches(pattern string, host string) bool {
+	host = strings.ToLower(host)
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+
+	return host == pattern
+}
+
+// compileGlob converts a glob into an anchored regular expression.
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	var expression strings.Builder
+	expression.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				expression.WriteString(".*")
+				i++
+			} else {
+				expression.WriteString("[^/]*")
+			}
+		case '?':
+			expression.WriteString("[^/]")
+		default:
+			expression.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	expression.WriteString("$")
+
+	return regexp.Compile(expression.String())
+}
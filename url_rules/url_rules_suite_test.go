@@ -0,0 +1,13 @@
+package url_rules_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestURLRules(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "URLRules Suite")
+}
@@ -0,0 +1,130 @@
+package url_rules_test
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	. "github.com/alphagov/govuk_crawler_worker/url_rules"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rules", func() {
+	excludes := func(rules *Rules, rawURL string) bool {
+		u, err := url.Parse(rawURL)
+		Expect(err).To(BeNil())
+
+		return rules.Excludes(u)
+	}
+
+	parse := func(rules string) *Rules {
+		parsed, err := Parse(strings.NewReader(rules))
+		Expect(err).To(BeNil())
+
+		return parsed
+	}
+
+	It("excludes nothing when it's nil", func() {
+		var rules *Rules
+
+		Expect(excludes(rules, "https://www.gov.uk/search")).To(BeFalse())
+	})
+
+	It("returns errors for invalid rules", func() {
+		_, err := Parse(strings.NewReader("include prefix=/foo\nignore prefix=/bar"))
+		Expect(err).To(MatchError("Invalid URL rule on line 2: expected include or exclude: ignore"))
+
+		_, err = Parse(strings.NewReader("exclude"))
+		Expect(err).ToNot(BeNil())
+
+		_, err = Parse(strings.NewReader("exclude colour=red"))
+		Expect(err).To(MatchError("Invalid URL rule on line 1: unknown condition: colour"))
+
+		_, err = Parse(strings.NewReader("exclude prefix"))
+		Expect(err).ToNot(BeNil())
+
+		_, err = Parse(strings.NewReader("exclude regex=(unclosed"))
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("ignores blank lines and comments", func() {
+		rules := parse("\n# exclude prefix=/foo\n\nexclude prefix=/bar\n")
+
+		Expect(excludes(rules, "https://www.gov.uk/foo")).To(BeFalse())
+		Expect(excludes(rules, "https://www.gov.uk/bar")).To(BeTrue())
+	})
+
+	It("uses the first matching rule", func() {
+		rules := parse(`
+include prefix=/government/uploads/system/uploads/attachment_data ext=pdf
+exclude prefix=/government/uploads
+include prefix=/government/uploads/other
+`)
+
+		Expect(excludes(rules, "https://www.gov.uk/government/uploads/system/uploads/attachment_data/file/1/report.PDF")).To(BeFalse())
+		Expect(excludes(rules, "https://www.gov.uk/government/uploads/system/uploads/attachment_data/file/1/report.odt")).To(BeTrue())
+		Expect(excludes(rules, "https://www.gov.uk/government/uploads/other/thing")).To(BeTrue())
+		Expect(excludes(rules, "https://www.gov.uk/government/publications")).To(BeFalse())
+	})
+
+	It("matches globs against the whole path", func() {
+		rules := parse("exclude glob=/government/*/print\nexclude glob=/guidance/**.pdf\nexclude glob=/a?c")
+
+		Expect(excludes(rules, "https://www.gov.uk/government/news/print")).To(BeTrue())
+		Expect(excludes(rules, "https://www.gov.uk/government/news/story/print")).To(BeFalse())
+		Expect(excludes(rules, "https://www.gov.uk/government/news/print/more")).To(BeFalse())
+		Expect(excludes(rules, "https://www.gov.uk/guidance/a/b/c.pdf")).To(BeTrue())
+		Expect(excludes(rules, "https://www.gov.uk/abc")).To(BeTrue())
+		Expect(excludes(rules, "https://www.gov.uk/a/c")).To(BeFalse())
+	})
+
+	It("matches regular expressions against the path", func() {
+		rules := parse("exclude regex=^/search(/|$)")
+
+		Expect(excludes(rules, "https://www.gov.uk/search")).To(BeTrue())
+		Expect(excludes(rules, "https://www.gov.uk/search/all")).To(BeTrue())
+		Expect(excludes(rules, "https://www.gov.uk/searching")).To(BeFalse())
+	})
+
+	It("scopes rules to hosts", func() {
+		rules := parse("exclude host=www.gov.uk prefix=/media\nexclude host=*.example.com prefix=/")
+
+		Expect(excludes(rules, "https://WWW.GOV.UK:443/media/foo")).To(BeTrue())
+		Expect(excludes(rules, "https://assets.publishing.service.gov.uk/media/foo")).To(BeFalse())
+		Expect(excludes(rules, "https://assets.example.com/foo")).To(BeTrue())
+		Expect(excludes(rules, "https://example.com/foo")).To(BeFalse())
+	})
+
+	It("builds exclude rules from path prefixes", func() {
+		rules := ExcludePrefixes([]string{"/search", " ", "/government/uploads"})
+
+		Expect(excludes(rules, "https://www.gov.uk/search")).To(BeTrue())
+		Expect(excludes(rules, "https://www.gov.uk/government/uploads/foo")).To(BeTrue())
+		Expect(excludes(rules, "https://www.gov.uk/government")).To(BeFalse())
+	})
+
+	It("combines rules in order of precedence", func() {
+		rules := Combine(parse("include prefix=/search/all"), nil, ExcludePrefixes([]string{"/search"}))
+
+		Expect(excludes(rules, "https://www.gov.uk/search/all")).To(BeFalse())
+		Expect(excludes(rules, "https://www.gov.uk/search")).To(BeTrue())
+	})
+
+	It("loads rules from a file", func() {
+		file, err := ioutil.TempFile("", "url_rules")
+		Expect(err).To(BeNil())
+		defer os.Remove(file.Name())
+
+		_, err = file.WriteString("exclude prefix=/search\n")
+		Expect(err).To(BeNil())
+		file.Close()
+
+		rules, err := LoadFile(file.Name())
+
+		Expect(err).To(BeNil())
+		Expect(excludes(rules, "https://www.gov.uk/search")).To(BeTrue())
+	})
+})
@@ -4,6 +4,7 @@ import (
 	"io"
 	"net"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,6 +24,22 @@ func GetEnvDefault(key string, defaultVal string) string {
 	return val
 }
 
+// ContainsString returns whether haystack contains needle.
+func ContainsString(haystack []string, needle string) bool {
+	for _, hay := range haystack {
+		if hay == needle {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NginxQuote returns s as a double quoted nginx string.
+func NginxQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
 // ProxyTCP is a basic TCP proxy which can terminate connections. It can be
 // used to test reconnect behaviour.
 type ProxyTCP struct {
@@ -103,6 +120,10 @@ func StatsDGauge(label string, value int64) {
 	statsdClient.Gauge("gauge."+label, value)
 }
 
+func StatsDIncrement(label string) {
+	statsdClient.Incr("count."+label, 1)
+}
+
 func newStatsDClient(host, prefix string) *statsd.StatsdClient {
 	statsdClient := statsd.NewStatsdClient(host, prefix)
 	statsdClient.CreateSocket()
@@ -99,4 +99,18 @@ var _ = Describe("Util", func() {
 			os.Setenv(env, "")
 		})
 	})
+
+	Describe("ContainsString", func() {
+		It("returns whether a string is in a list", func() {
+			Expect(ContainsString([]string{"a", "b"}, "b")).To(BeTrue())
+			Expect(ContainsString([]string{"a", "b"}, "c")).To(BeFalse())
+			Expect(ContainsString(nil, "")).To(BeFalse())
+		})
+	})
+
+	Describe("NginxQuote", func() {
+		It("double quotes a string, escaping quotes and backslashes", func() {
+			Expect(NginxQuote(`/say "hi"\`)).To(Equal(`"/say \"hi\"\\"`))
+		})
+	})
 })
@@ -0,0 +1,148 @@
+package warc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Record types, as given in the WARC-Type header.
+const (
+	WARCInfo = "warcinfo"
+	Request  = "request"
+	Response = "response"
+)
+
+// Content types of the blocks of request and response records.
+const (
+	HTTPRequestContentType  = "application/http;msgtype=request"
+	HTTPResponseContentType = "application/http;msgtype=response"
+)
+
+const (
+	version    = "WARC/1.0"
+	dateFormat = "2006-01-02T15:04:05Z"
+)
+
+// Record is a single WARC record. Its block is Header followed by Payload,
+// so for request and response records Header holds the HTTP request or
+// status line and headers, and Payload the body.
+type Record struct {
+	Type string
+	// ID is a URI such as NewRecordID returns. The Writer gives records
+	// without one a new ID.
+	ID string
+	// Date is when the content of the record was captured. The Writer
+	// uses the time of writing if it's zero.
+	Date      time.Time
+	TargetURI string
+	IPAddress string
+	// ConcurrentTo is the ID of a record captured at the same time, such
+	// as the request a response was for.
+	ConcurrentTo string
+	// Filename names the file a warcinfo record describes.
+	Filename    string
+	ContentType string
+
+	Header  []byte
+	Payload io.ReadSeeker
+}
+
+// NewRecordID returns a new random `urn:uuid` record ID.
+func NewRecordID() string {
+	var uuid [16]byte
+	if _, err := io.ReadFull(rand.Reader, uuid[:]); err != nil {
+		panic(err)
+	}
+
+	// Version 4, variant RFC 4122.
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
+
+// Digest returns the `sha1:` digest of data in the base32 form used for
+// WARC-Block-Digest and WARC-Payload-Digest.
+func Digest(data []byte) string {
+	digest := sha1.New()
+	digest.Write(data)
+
+	return formatDigest(digest)
+}
+
+func formatDigest(digest hash.Hash) string {
+	return "sha1:" + base32.StdEncoding.EncodeToString(digest.Sum(nil))
+}
+
+// hasPayload reports whether the record's block is an HTTP message, whose
+// body is a payload in its own right.
+func (r *Record) hasPayload() bool {
+	return r.Type == Request || r.Type == Response
+}
+
+// writeTo writes the record to w. The payload is read twice, once to find
+// its length and digest, which come before it.
+func (r *Record) writeTo(w io.Writer) error {
+	blockDigest := sha1.New()
+	payloadDigest := sha1.New()
+	blockDigest.Write(r.Header)
+
+	var payloadLength int64
+	if r.Payload != nil {
+		var err error
+		payloadLength, err = io.Copy(io.MultiWriter(blockDigest, payloadDigest), r.Payload)
+		if err != nil {
+			return err
+		}
+
+		if _, err = r.Payload.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	var header bytes.Buffer
+	header.WriteString(version + "\r\n")
+	writeField(&header, "WARC-Type", r.Type)
+	writeField(&header, "WARC-Record-ID", r.ID)
+	writeField(&header, "WARC-Date", r.Date.UTC().Format(dateFormat))
+	writeField(&header, "WARC-Target-URI", r.TargetURI)
+	writeField(&header, "WARC-IP-Address", r.IPAddress)
+	writeField(&header, "WARC-Concurrent-To", r.ConcurrentTo)
+	writeField(&header, "WARC-Filename", r.Filename)
+	writeField(&header, "Content-Type", r.ContentType)
+	if r.hasPayload() {
+		writeField(&header, "WARC-Payload-Digest", formatDigest(payloadDigest))
+	}
+	writeField(&header, "WARC-Block-Digest", formatDigest(blockDigest))
+	writeField(&header, "Content-Length", strconv.FormatInt(int64(len(r.Header))+payloadLength, 10))
+	header.WriteString("\r\n")
+	header.Write(r.Header)
+
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	if r.Payload != nil {
+		if _, err := io.CopyN(w, r.Payload, payloadLength); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, "\r\n\r\n")
+
+	return err
+}
+
+// writeField writes a header field, leaving out empty optional fields.
+func writeField(w *bytes.Buffer, name string, value string) {
+	if value != "" {
+		w.WriteString(name + ": " + value + "\r\n")
+	}
+}
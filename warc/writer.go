@@ -0,0 +1,279 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Files are named with this suffix while they're being written, and
+// renamed without it once they're complete.
+const openSuffix = ".open"
+
+// Writer writes records to gzipped WARC files in a directory, starting a
+// new file once the current one reaches a maximum size. Every record is
+// compressed as a separate gzip member, so that a record can be read
+// without decompressing the file up to it.
+type Writer struct {
+	dir      string
+	prefix   string
+	maxSize  int64
+	software string
+	hostname string
+
+	mutex  sync.Mutex
+	file   *os.File
+	name   string
+	size   int64
+	serial int
+}
+
+// NewWriter returns a Writer for files named after prefix in dir. A
+// maxSize of 0 means files are only finished by Close. software is
+// recorded in the warcinfo record at the start of every file.
+//
+// Files this host left open, because the worker stopped without closing
+// its Writer, are finished first. Any record cut short at the end of
+// them is dropped.
+func NewWriter(dir string, prefix string, maxSize int64, software string) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	openFiles, err := filepath.Glob(filepath.Join(dir, prefix+"-*-"+hostname+".warc.gz"+openSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filePath := range openFiles {
+		if err := recoverFile(filePath); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Writer{
+		dir:      dir,
+		prefix:   prefix,
+		maxSize:  maxSize,
+		software: software,
+		hostname: hostname,
+	}, nil
+}
+
+// Write writes records to the current file, one after another, so that a
+// request and its response are always in the same file. Once the file has
+// reached the maximum size it's finished, and the next Write starts a new
+// one.
+func (w *Writer) Write(records ...*Record) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+
+	for _, record := range records {
+		if record.ID == "" {
+			record.ID = NewRecordID()
+		}
+		if record.Date.IsZero() {
+			record.Date = time.Now()
+		}
+
+		if err := w.writeRecord(record); err != nil {
+			return err
+		}
+	}
+
+	if w.maxSize > 0 && w.size >= w.maxSize {
+		return w.finish()
+	}
+
+	return nil
+}
+
+// Close finishes the current file.
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	return w.finish()
+}
+
+// open starts a new file with a warcinfo record describing it. Files are
+// created exclusively, so that an existing file is never overwritten.
+func (w *Writer) open() error {
+	w.serial++
+	w.name = fmt.Sprintf("%s-%s-%05d-%s.warc.gz",
+		w.prefix, time.Now().UTC().Format("20060102150405"), w.serial, w.hostname)
+
+	file, err := os.OpenFile(filepath.Join(w.dir, w.name+openSuffix), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	w.file = file
+	w.size = 0
+
+	fields := strings.Join([]string{
+		"software: " + w.software,
+		"format: WARC File Format 1.0",
+		"hostname: " + w.hostname,
+	}, "\r\n") + "\r\n"
+
+	return w.writeRecord(&Record{
+		Type:        WARCInfo,
+		ID:          NewRecordID(),
+		Date:        time.Now(),
+		Filename:    w.name,
+		ContentType: "application/warc-fields",
+		Payload:     bytes.NewReader([]byte(fields)),
+	})
+}
+
+// writeRecord writes record as a gzip member of its own. If the record
+// can't be written in full, the file is cut back to where it started, so
+// that only complete records are kept.
+func (w *Writer) writeRecord(record *Record) error {
+	counter := &countingWriter{file: w.file}
+	gzipWriter := gzip.NewWriter(counter)
+
+	err := record.writeTo(gzipWriter)
+	if closeErr := gzipWriter.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if truncateErr := w.file.Truncate(w.size); truncateErr != nil {
+			return truncateErr
+		}
+		if _, seekErr := w.file.Seek(w.size, io.SeekStart); seekErr != nil {
+			return seekErr
+		}
+
+		return err
+	}
+	w.size += counter.count
+
+	return nil
+}
+
+// finish syncs and closes the current file, and renames it to show it's
+// complete.
+func (w *Writer) finish() error {
+	file := w.file
+	w.file = nil
+
+	err := file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.Join(w.dir, w.name))
+	}
+
+	return err
+}
+
+// recoverFile finishes a file which was left open, truncating it after
+// its last complete record. A file without one is removed.
+func recoverFile(filePath string) error {
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+
+	complete := completeLength(file)
+
+	err = file.Truncate(complete)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if complete == 0 {
+		return os.Remove(filePath)
+	}
+
+	return os.Rename(filePath, strings.TrimSuffix(filePath, openSuffix))
+}
+
+// completeLength returns the length of the gzip members at the start of
+// file which can be read in full.
+func completeLength(file *os.File) int64 {
+	// gzip only reads as far as the end of a member from an
+	// io.ByteReader, so the count is where each member ends.
+	reader := &countingReader{reader: bufio.NewReader(file)}
+	var complete int64
+
+	for {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return complete
+		}
+		gzipReader.Multistream(false)
+
+		if _, err := io.Copy(ioutil.Discard, gzipReader); err != nil {
+			return complete
+		}
+		complete = reader.count
+	}
+}
+
+// countingReader counts the bytes read from a bufio.Reader.
+type countingReader struct {
+	reader *bufio.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.reader.ReadByte()
+	if err == nil {
+		c.count++
+	}
+
+	return b, err
+}
+
+// countingWriter counts the bytes written to a file.
+type countingWriter struct {
+	file  *os.File
+	count int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.file.Write(p)
+	c.count += int64(n)
+
+	return n, err
+}
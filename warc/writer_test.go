@@ -0,0 +1,282 @@
+package warc_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/alphagov/govuk_crawler_worker/warc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// readRecords returns each gzip member of a WARC file, which should be one
+// record apiece.
+func readRecords(filePath string) []string {
+	file, err := os.Open(filePath)
+	Expect(err).To(BeNil())
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	records := []string{}
+
+	for {
+		gzipReader, err := gzip.NewReader(reader)
+		if err == io.EOF {
+			return records
+		}
+		Expect(err).To(BeNil())
+		gzipReader.Multistream(false)
+
+		record, err := ioutil.ReadAll(gzipReader)
+		Expect(err).To(BeNil())
+
+		records = append(records, string(record))
+	}
+}
+
+// failingPayload fails once more than limit bytes have been read from it,
+// which can be on the second pass, when it's written.
+type failingPayload struct {
+	reader *strings.Reader
+	limit  int
+}
+
+func (f *failingPayload) Read(p []byte) (int, error) {
+	if f.limit <= 0 {
+		return 0, errors.New("Payload couldn't be read")
+	}
+	if len(p) > f.limit {
+		p = p[:f.limit]
+	}
+
+	n, err := f.reader.Read(p)
+	f.limit -= n
+
+	return n, err
+}
+
+func (f *failingPayload) Seek(offset int64, whence int) (int64, error) {
+	return f.reader.Seek(offset, whence)
+}
+
+func warcFiles(dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	Expect(err).To(BeNil())
+
+	return files
+}
+
+var _ = Describe("Digest", func() {
+	It("is the base32 SHA-1 of the data", func() {
+		Expect(Digest(nil)).To(Equal("sha1:3I42H3S6NNFQ2MSVX7XZKYAYSCX5QBYJ"))
+	})
+})
+
+var _ = Describe("NewRecordID", func() {
+	It("returns a different UUID URN every time", func() {
+		id := NewRecordID()
+
+		Expect(id).To(MatchRegexp(`^<urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}>$`))
+		Expect(NewRecordID()).ToNot(Equal(id))
+	})
+})
+
+var _ = Describe("Writer", func() {
+	var dir string
+	var writer *Writer
+
+	header := "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 11\r\n\r\n"
+	payload := "Hello world"
+
+	response := func() *Record {
+		return &Record{
+			Type:        Response,
+			Date:        time.Date(2014, 4, 1, 12, 0, 0, 0, time.UTC),
+			TargetURI:   "https://www.gov.uk/bank-holidays",
+			IPAddress:   "127.0.0.1",
+			ContentType: HTTPResponseContentType,
+			Header:      []byte(header),
+			Payload:     strings.NewReader(payload),
+		}
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "warc_test")
+		Expect(err).To(BeNil())
+
+		writer, err = NewWriter(dir, "crawl", 0, "GOV.UK Crawler Worker/0.0.0")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("starts each file with a warcinfo record", func() {
+		Expect(writer.Write(response())).To(BeNil())
+		Expect(writer.Close()).To(BeNil())
+
+		files := warcFiles(dir)
+		Expect(files).To(HaveLen(1))
+		Expect(filepath.Base(files[0])).To(MatchRegexp(`^crawl-\d{14}-00001-.+\.warc\.gz$`))
+
+		records := readRecords(files[0])
+		Expect(records).To(HaveLen(2))
+		Expect(records[0]).To(HavePrefix("WARC/1.0\r\nWARC-Type: warcinfo\r\n"))
+		Expect(records[0]).To(ContainSubstring("WARC-Filename: " + filepath.Base(files[0]) + "\r\n"))
+		Expect(records[0]).To(ContainSubstring("software: GOV.UK Crawler Worker/0.0.0\r\n"))
+	})
+
+	It("writes records with their digests and target URI", func() {
+		record := response()
+		Expect(writer.Write(record)).To(BeNil())
+		Expect(writer.Close()).To(BeNil())
+
+		records := readRecords(warcFiles(dir)[0])
+		Expect(records[1]).To(Equal("WARC/1.0\r\n" +
+			"WARC-Type: response\r\n" +
+			"WARC-Record-ID: " + record.ID + "\r\n" +
+			"WARC-Date: 2014-04-01T12:00:00Z\r\n" +
+			"WARC-Target-URI: https://www.gov.uk/bank-holidays\r\n" +
+			"WARC-IP-Address: 127.0.0.1\r\n" +
+			"Content-Type: application/http;msgtype=response\r\n" +
+			"WARC-Payload-Digest: " + Digest([]byte(payload)) + "\r\n" +
+			"WARC-Block-Digest: " + Digest([]byte(header+payload)) + "\r\n" +
+			"Content-Length: 76\r\n" +
+			"\r\n" +
+			header + payload + "\r\n\r\n"))
+	})
+
+	It("gives records without an ID or date one", func() {
+		record := &Record{Type: Request, TargetURI: "https://www.gov.uk/"}
+		Expect(writer.Write(record)).To(BeNil())
+
+		Expect(record.ID).To(HavePrefix("<urn:uuid:"))
+		Expect(record.Date).To(BeTemporally("~", time.Now(), time.Second))
+	})
+
+	It("names files as open until they're closed", func() {
+		Expect(writer.Write(response())).To(BeNil())
+
+		files := warcFiles(dir)
+		Expect(files).To(HaveLen(1))
+		Expect(files[0]).To(HaveSuffix(".warc.gz.open"))
+
+		Expect(writer.Close()).To(BeNil())
+
+		files = warcFiles(dir)
+		Expect(files).To(HaveLen(1))
+		Expect(files[0]).To(HaveSuffix(".warc.gz"))
+	})
+
+	It("starts a new file once the current one reaches the maximum size", func() {
+		rotatingWriter, err := NewWriter(dir, "crawl", 1, "GOV.UK Crawler Worker/0.0.0")
+		Expect(err).To(BeNil())
+
+		Expect(rotatingWriter.Write(response(), response())).To(BeNil())
+		Expect(rotatingWriter.Write(response())).To(BeNil())
+
+		files := warcFiles(dir)
+		Expect(files).To(HaveLen(2))
+
+		for _, file := range files {
+			Expect(file).To(HaveSuffix(".warc.gz"))
+		}
+		Expect(readRecords(files[0])).To(HaveLen(3))
+		Expect(readRecords(files[1])).To(HaveLen(2))
+	})
+
+	It("drops a record whose payload can't be read in full", func() {
+		Expect(writer.Write(response())).To(BeNil())
+
+		record := response()
+		record.Payload = &failingPayload{reader: strings.NewReader(payload), limit: len(payload) + 5}
+		Expect(writer.Write(record)).ToNot(BeNil())
+
+		Expect(writer.Write(response())).To(BeNil())
+		Expect(writer.Close()).To(BeNil())
+
+		records := readRecords(warcFiles(dir)[0])
+		Expect(records).To(HaveLen(3))
+		Expect(records[2]).To(HaveSuffix(header + payload + "\r\n\r\n"))
+	})
+
+	It("doesn't create a file until there's something to write", func() {
+		Expect(writer.Close()).To(BeNil())
+		Expect(warcFiles(dir)).To(BeEmpty())
+	})
+
+	Describe("files left open", func() {
+		var hostname string
+
+		BeforeEach(func() {
+			var err error
+			hostname, err = os.Hostname()
+			Expect(err).To(BeNil())
+		})
+
+		It("finishes them", func() {
+			Expect(writer.Write(response())).To(BeNil())
+
+			_, err := NewWriter(dir, "crawl", 0, "GOV.UK Crawler Worker/0.0.0")
+			Expect(err).To(BeNil())
+
+			files := warcFiles(dir)
+			Expect(files).To(HaveLen(1))
+			Expect(files[0]).To(HaveSuffix(".warc.gz"))
+			Expect(readRecords(files[0])).To(HaveLen(2))
+		})
+
+		It("drops a record which was cut short", func() {
+			Expect(writer.Write(response())).To(BeNil())
+			openFile := warcFiles(dir)[0]
+
+			info, err := os.Stat(openFile)
+			Expect(err).To(BeNil())
+
+			Expect(writer.Write(response())).To(BeNil())
+			Expect(os.Truncate(openFile, info.Size()+20)).To(BeNil())
+
+			_, err = NewWriter(dir, "crawl", 0, "GOV.UK Crawler Worker/0.0.0")
+			Expect(err).To(BeNil())
+
+			files := warcFiles(dir)
+			Expect(files).To(Equal([]string{strings.TrimSuffix(openFile, ".open")}))
+			Expect(readRecords(files[0])).To(HaveLen(2))
+		})
+
+		It("removes them if they don't have a complete record", func() {
+			openFile := filepath.Join(dir, "crawl-20140401120000-00001-"+hostname+".warc.gz.open")
+			Expect(ioutil.WriteFile(openFile, []byte("\x1f\x8b"), 0644)).To(BeNil())
+
+			_, err := NewWriter(dir, "crawl", 0, "GOV.UK Crawler Worker/0.0.0")
+			Expect(err).To(BeNil())
+
+			Expect(warcFiles(dir)).To(BeEmpty())
+		})
+
+		It("leaves the files of other hosts and prefixes alone", func() {
+			otherFiles := []string{
+				filepath.Join(dir, "crawl-20140401120000-00001-"+hostname+"-other.warc.gz.open"),
+				filepath.Join(dir, "other-20140401120000-00001-"+hostname+".warc.gz.open"),
+			}
+			for _, otherFile := range otherFiles {
+				Expect(ioutil.WriteFile(otherFile, nil, 0644)).To(BeNil())
+			}
+
+			_, err := NewWriter(dir, "crawl", 0, "GOV.UK Crawler Worker/0.0.0")
+			Expect(err).To(BeNil())
+
+			Expect(warcFiles(dir)).To(ConsistOf(otherFiles))
+		})
+	})
+})
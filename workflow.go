@@ -1,28 +1,47 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/alphagov/govuk_crawler_worker/file_paths"
 	"github.com/alphagov/govuk_crawler_worker/http_crawler"
 	"github.com/alphagov/govuk_crawler_worker/queue"
+	"github.com/alphagov/govuk_crawler_worker/sidecar"
+	"github.com/alphagov/govuk_crawler_worker/sitemap"
+	"github.com/alphagov/govuk_crawler_worker/storage"
 	"github.com/alphagov/govuk_crawler_worker/ttl_hash_set"
+	"github.com/alphagov/govuk_crawler_worker/url_normaliser"
+	"github.com/alphagov/govuk_crawler_worker/url_rules"
 	"github.com/alphagov/govuk_crawler_worker/util"
+	"github.com/alphagov/govuk_crawler_worker/warc"
 	"github.com/streadway/amqp"
 )
 
 const ReadyToEnqueue int = 0
 const Enqueued int = 1
 
+// Page budget counts are kept under this prefix, which can't be mistaken
+// for a URL.
+const pageBudgetKeyPrefix = "page_budget:"
+
 func ReadFromQueue(
 	inboundChannel <-chan amqp.Delivery,
 	rootURLs []*url.URL,
 	ttlHashSet *ttl_hash_set.TTLHashSet,
-	blacklistPaths []string,
+	urlRules *url_rules.Rules,
+	normaliser *url_normaliser.Normaliser,
 	crawlerThreads int,
 ) chan *CrawlerMessageItem {
 	outboundChannel := make(chan *CrawlerMessageItem, crawlerThreads)
@@ -31,11 +50,24 @@ func ReadFromQueue(
 		inbound <-chan amqp.Delivery,
 		outbound chan<- *CrawlerMessageItem,
 		ttlHashSet *ttl_hash_set.TTLHashSet,
-		blacklistPaths []string,
+		urlRules *url_rules.Rules,
 	) {
 		for item := range inbound {
 			start := time.Now()
-			message := NewCrawlerMessageItem(item, rootURLs, blacklistPaths)
+			message := NewCrawlerMessageItem(item, rootURLs, urlRules)
+			message.Normaliser = normaliser
+
+			if err := message.DecodeError(); err != nil {
+				item.Reject(false)
+				log.Errorln("Couldn't decode message (rejecting):", string(item.Body), err)
+				continue
+			}
+
+			// Crawl, and store the state of, each URL in its canonical
+			// form however it was queued.
+			if normalised, err := normaliser.NormaliseString(message.URL()); err == nil {
+				message.Message.URL = normalised
+			}
 
 			if message.IsBlacklisted() {
 				item.Ack(false)
@@ -49,108 +81,316 @@ func ReadFromQueue(
 		}
 	}
 
-	go readLoop(inboundChannel, outboundChannel, ttlHashSet, blacklistPaths)
+	go readLoop(inboundChannel, outboundChannel, ttlHashSet, urlRules)
 
 	return outboundChannel
 }
 
 func CrawlURL(
 	ttlHashSet *ttl_hash_set.TTLHashSet,
+	queueManager *queue.Manager,
 	crawlChannel <-chan *CrawlerMessageItem,
 	crawler *http_crawler.Crawler,
-	crawlerThreads int,
+	maxPendingItems int,
 	maxCrawlRetries int,
 ) <-chan *CrawlerMessageItem {
-	if crawlerThreads < 1 {
-		panic("cannot start a negative or zero number of crawler threads")
+	if maxPendingItems < 1 {
+		panic("cannot crawl a negative or zero number of items at once")
 	}
 
 	extractChannel := make(chan *CrawlerMessageItem, 2)
 
-	crawlLoop := func(
+	crawlItem := func(
 		ttlHashSet *ttl_hash_set.TTLHashSet,
-		crawl <-chan *CrawlerMessageItem,
+		item *CrawlerMessageItem,
 		extract chan<- *CrawlerMessageItem,
 		crawler *http_crawler.Crawler,
 		maxCrawlRetries int,
 	) {
-		for item := range crawl {
-			start := time.Now()
-			u, err := url.Parse(item.URL())
-			if err != nil {
-				item.Reject(false)
-				log.Warningln("Couldn't crawl, invalid URL (rejecting):", item.URL(), err)
-				continue
+		start := time.Now()
+		u, err := url.Parse(item.URL())
+		if err != nil {
+			item.Reject(false)
+			log.Warningln("Couldn't crawl, invalid URL (rejecting):", item.URL(), err)
+			return
+		}
+
+		crawlCount, err := ttlHashSet.Get(u.String())
+		if err != nil {
+			item.Reject(false)
+			log.Errorln("Couldn't confirm existence of URL (rejecting):", u.String(), err)
+			return
+		}
+
+		if crawlCount > maxCrawlRetries {
+			item.Reject(false)
+			log.Errorf("Aborting crawl of URL which has been retried %d times (rejecting): %s", maxCrawlRetries, u.String())
+
+			return
+		}
+
+		log.Debugln("Starting crawl of URL:", u)
+		response, err := crawler.Crawl(u)
+		if err != nil {
+			if retryErr, ok := err.(*http_crawler.RetryError); ok {
+				if retryErr.Err == http_crawler.ErrRetryRequest5XX {
+					ttlHashSet.Incr(u.String())
+				}
+
+				if retryErr.BackOff() {
+					// Pause all crawling of this host, not just this item.
+					pause := crawler.Scheduler.Backoff(u.Host, retryErr.RetryAfter)
+					log.Warningf("Pausing crawling of %s for: %v. Received %d HTTP status", u.Host, pause, retryErr.StatusCode)
+				}
+
+				log.Warningln("Couldn't crawl (retrying):", u.String(), err)
+				retryItem(queueManager, item)
+				return
 			}
 
-			crawlCount, err := ttlHashSet.Get(u.String())
-			if err != nil {
+			switch err {
+			case http_crawler.ErrBodyTooLarge:
 				item.Reject(false)
-				log.Errorln("Couldn't confirm existence of URL (rejecting):", u.String(), err)
-				continue
-			}
+				log.Warningln("Response body is too large (rejecting):", u.String())
+			case http_crawler.ErrDisallowedByRobots:
+				if err = item.Ack(false); err != nil {
+					log.Errorln("Ack failed (CrawlURL): ", item.URL())
+				}
 
-			if crawlCount > maxCrawlRetries {
+				log.Debugln("URL is disallowed by robots.txt (acknowledging):", u.String())
+			default:
 				item.Reject(false)
-				log.Errorf("Aborting crawl of URL which has been retried %d times (rejecting): %s", maxCrawlRetries, u.String())
+				log.Warningln("Couldn't crawl (rejecting):", u.String(), err)
+			}
 
-				continue
+			return
+		}
+
+		crawler.Scheduler.ResetBackoff(u.Host)
+
+		log.Debugf("Crawled %s: %d (%d bytes from %s) in %v, first byte after %v",
+			u.String(), response.StatusCode, response.ContentLength,
+			response.RemoteIP, response.Timing.Total, response.Timing.FirstByte)
+		util.StatsDTiming("time_to_first_byte", start, start.Add(response.Timing.FirstByte))
+
+		if response.ContentTypeMismatch {
+			log.Infof("Content-Type of %s is %q but its body looks like %q, treating it as %q",
+				u.String(), response.DeclaredContentType, response.SniffedContentType, response.ContentType)
+			util.StatsDIncrement("content_type_mismatch")
+		}
+
+		item.Response = response
+
+		if crawler.ContentTypes.Accepts(item.Response.ContentType) {
+			extract <- item
+		} else {
+			removeBodyFile(item)
+
+			if err = item.Ack(false); err != nil {
+				log.Errorln("Ack failed (CrawlURL): ", item.URL())
 			}
 
-			log.Debugln("Starting crawl of URL:", u)
-			response, err := crawler.Crawl(u)
+			err = ttlHashSet.Set(item.URL(), ReadyToEnqueue)
 			if err != nil {
-				switch err {
-				case http_crawler.ErrRetryRequest5XX, http_crawler.ErrRetryRequest429:
-					switch err {
-					case http_crawler.ErrRetryRequest5XX:
-						ttlHashSet.Incr(u.String())
-					case http_crawler.ErrRetryRequest429:
-						sleepTime := 5 * time.Second
-
-						// Back off from crawling for a few seconds.
-						log.Warningf("Sleeping for: %v. Received 429 HTTP status", sleepTime)
-						time.Sleep(sleepTime)
-					}
+				log.Errorln("Couldn't mark item as already crawled:", item.URL(), err)
+			}
+		}
 
-					item.Reject(true)
+		util.StatsDTiming("crawl_url", start, time.Now())
+	}
 
-					log.Warningln("Couldn't crawl (requeueing):", u.String(), err)
-				default:
-					item.Reject(false)
-					log.Warningln("Couldn't crawl (rejecting):", u.String(), err)
-				}
+	// Every item is crawled in its own goroutine so that items waiting on
+	// a busy host don't hold up items for other hosts. The number of
+	// requests in flight is limited by the crawler's Scheduler, and the
+	// number of items waiting for it by maxPendingItems, which should be
+	// well above the Scheduler's limit.
+	crawlLoop := func(
+		ttlHashSet *ttl_hash_set.TTLHashSet,
+		crawl <-chan *CrawlerMessageItem,
+		extract chan<- *CrawlerMessageItem,
+		crawler *http_crawler.Crawler,
+		maxCrawlRetries int,
+	) {
+		pending := make(chan struct{}, maxPendingItems)
 
-				continue
-			}
+		for item := range crawl {
+			pending <- struct{}{}
 
-			item.Response = response
+			go func(item *CrawlerMessageItem) {
+				defer func() { <-pending }()
+				crawlItem(ttlHashSet, item, extract, crawler, maxCrawlRetries)
+			}(item)
+		}
+	}
 
-			if item.Response.AcceptedContentType() {
-				extract <- item
-			} else {
-				if err = item.Ack(false); err != nil {
-					log.Errorln("Ack failed (CrawlURL): ", item.URL())
-				}
+	go crawlLoop(ttlHashSet, crawlChannel, extractChannel, crawler, maxCrawlRetries)
 
-				err = ttlHashSet.Set(item.URL(), ReadyToEnqueue)
-				if err != nil {
-					log.Errorln("Couldn't mark item as already crawled:", item.URL(), err)
+	return extractChannel
+}
+
+// retryItem queues the item's URL again with its attempt count raised,
+// then acknowledges the item. If it can't be queued again, the item is
+// requeued as it is.
+func retryItem(queueManager *queue.Manager, item *CrawlerMessageItem) {
+	retry := *item.Message
+	retry.Attempts++
+
+	if err := queueManager.PublishMessage("#", &retry); err != nil {
+		item.Reject(true)
+		log.Errorln("Couldn't queue item again to retry it (requeueing):", item.URL(), err)
+		return
+	}
+
+	if err := item.Ack(false); err != nil {
+		log.Errorln("Ack failed (CrawlURL): ", item.URL())
+	}
+}
+
+// WriteWARC records the request and response of each crawled item in WARC
+// files, before passing it on to be written to the mirror. Items which
+// haven't changed since they were last crawled have nothing new to record.
+func WriteWARC(writer *warc.Writer, crawlChannel <-chan *CrawlerMessageItem) <-chan *CrawlerMessageItem {
+	persistChannel := make(chan *CrawlerMessageItem, 2)
+
+	writeLoop := func(
+		crawl <-chan *CrawlerMessageItem,
+		persist chan<- *CrawlerMessageItem,
+	) {
+		for item := range crawl {
+			start := time.Now()
+
+			if !item.Response.NotModified {
+				// The mirror is still written if the archive can't be,
+				// so this isn't a reason to reject the item.
+				if err := writeWARCRecords(writer, item.Response); err != nil {
+					log.Errorln("Couldn't write item to WARC:", item.URL(), err)
+				} else {
+					log.Debugln("Wrote WARC records for:", item.URL())
 				}
 			}
 
-			util.StatsDTiming("crawl_url", start, time.Now())
+			persist <- item
+
+			util.StatsDTiming("write_warc", start, time.Now())
 		}
 	}
 
-	for i := 1; i <= crawlerThreads; i++ {
-		go crawlLoop(ttlHashSet, crawlChannel, extractChannel, crawler, maxCrawlRetries)
+	go writeLoop(crawlChannel, persistChannel)
+
+	return persistChannel
+}
+
+// Request headers which are replaced in WARC records, so that the archive
+// can be shared without sharing credentials.
+var redactedRequestHeaders = []string{"Authorization", "Rate-Limit-Token"}
+
+func writeWARCRecords(writer *warc.Writer, response *http_crawler.CrawlerResponse) error {
+	var payload io.ReadSeeker
+	var payloadLength int64
+
+	switch {
+	case len(response.Redirects) > 0:
+		// Body is the generated redirect page, not what was received,
+		// so the redirect is recorded without one.
+		payload = bytes.NewReader(nil)
+	case response.BodyFile != "":
+		file, err := os.Open(response.BodyFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+
+		payload = file
+		payloadLength = info.Size()
+	default:
+		payload = bytes.NewReader(response.Body)
+		payloadLength = int64(len(response.Body))
 	}
 
-	return extractChannel
+	requestID := warc.NewRecordID()
+	responseID := warc.NewRecordID()
+	targetURI := response.URL.String()
+
+	return writer.Write(&warc.Record{
+		Type:         warc.Request,
+		ID:           requestID,
+		Date:         response.StartedAt,
+		TargetURI:    targetURI,
+		ConcurrentTo: responseID,
+		ContentType:  warc.HTTPRequestContentType,
+		Header:       warcRequestHeader(response),
+	}, &warc.Record{
+		Type:         warc.Response,
+		ID:           responseID,
+		Date:         response.StartedAt,
+		TargetURI:    targetURI,
+		IPAddress:    response.RemoteIP,
+		ConcurrentTo: requestID,
+		ContentType:  warc.HTTPResponseContentType,
+		Header:       warcResponseHeader(response, payloadLength),
+		Payload:      payload,
+	})
+}
+
+func warcRequestHeader(response *http_crawler.CrawlerResponse) []byte {
+	header := http.Header{}
+	for name, values := range response.RequestHeader {
+		header[name] = values
+	}
+	for _, name := range redactedRequestHeaders {
+		if header.Get(name) != "" {
+			header.Set(name, "redacted")
+		}
+	}
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "GET %s HTTP/1.1\r\n", response.URL.RequestURI())
+	fmt.Fprintf(&buffer, "Host: %s\r\n", response.URL.Host)
+	header.Write(&buffer)
+	buffer.WriteString("\r\n")
+
+	return buffer.Bytes()
+}
+
+// warcResponseHeader describes the payload as it's recorded, which has
+// already been decompressed and de-chunked.
+func warcResponseHeader(response *http_crawler.CrawlerResponse, payloadLength int64) []byte {
+	header := http.Header{}
+	for name, values := range response.Header {
+		header[name] = values
+	}
+	header.Del("Content-Encoding")
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.FormatInt(payloadLength, 10))
+
+	proto := response.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "%s %d %s\r\n", proto, response.StatusCode, http.StatusText(response.StatusCode))
+	header.Write(&buffer)
+	buffer.WriteString("\r\n")
+
+	return buffer.Bytes()
 }
 
-func WriteItemToDisk(basePath string, crawlChannel <-chan *CrawlerMessageItem) <-chan *CrawlerMessageItem {
+func WriteItemToDisk(
+	store storage.Store,
+	filePaths *file_paths.Mapper,
+	validators http_crawler.ValidatorStore,
+	redirectMap *http_crawler.RedirectMap,
+	queryParams *QueryParamAllowList,
+	gzipFiles bool,
+	sidecars bool,
+	crawlChannel <-chan *CrawlerMessageItem,
+) <-chan *CrawlerMessageItem {
 	extractChannel := make(chan *CrawlerMessageItem, 2)
 
 	writeLoop := func(
@@ -159,36 +399,99 @@ func WriteItemToDisk(basePath string, crawlChannel <-chan *CrawlerMessageItem) <
 	) {
 		for item := range crawl {
 			start := time.Now()
-			relativeFilePath, err := item.RelativeFilePath()
+			relativeFilePath, err := item.FilePath(filePaths)
 
-			if item.hasParams() {
-				log.Debugln("Skipping write to disk as query params exists:", item.URL(), err)
+			if item.hasDisallowedParams(queryParams) {
+				removeBodyFile(item)
+				log.Debugln("Skipping write to disk as query params aren't allowed:", item.URL(), err)
 			} else {
 				if err != nil {
+					removeBodyFile(item)
 					item.Reject(false)
 					log.Errorln("Couldn't retrieve relative file path for item (rejecting):", item.URL(), err)
 					continue
 				}
 
-				filePath := filepath.Join(basePath, relativeFilePath)
-				dirPath := filepath.Dir(filePath)
-				err = os.MkdirAll(dirPath, 0755)
+				key := filepath.ToSlash(relativeFilePath)
+
+				if item.Response.NotModified {
+					// Keep the copy we already have. Pages and stylesheets
+					// are read back so that links can still be extracted
+					// from them, anything else is left where it is as long
+					// as it's still there.
+					if item.Response.HasLinks() {
+						item.Response.Body, err = readKey(store, key)
+					} else {
+						err = checkKeyExists(store, key)
+					}
+					if err != nil {
+						// Without the stored copy the 304 is no use, so
+						// forget the validators and crawl it again in full.
+						if validators != nil {
+							validators.Delete(item.Response.URL)
+						}
+
+						item.Reject(true)
+						log.Warningln("Couldn't read unchanged item from store (requeueing):", key, err)
+						continue
+					}
 
-				if err != nil {
-					item.Reject(false)
-					log.Errorln("Couldn't create directories for item (rejecting):", filePath, err)
-					continue
-				}
+					log.Debugln("URL unchanged since last crawl, keeping stored copy:", item.URL())
+					util.StatsDIncrement("not_modified")
+				} else {
+					// The body is hashed before it's written, as a
+					// streamed body is moved out of reach.
+					var contentHash string
+					if sidecars {
+						if contentHash, err = bodyHash(item.Response); err != nil {
+							log.Errorln("Couldn't hash body of item:", item.URL(), err)
+						}
+					}
 
-				err = ioutil.WriteFile(filePath, item.Response.Body, 0644)
+					if item.Response.BodyFile != "" {
+						// Streamed bodies are handed over to the store,
+						// which moves them into place if it can.
+						err = storage.PutFile(store, key, item.Response.BodyFile)
+						if err == nil {
+							item.Response.BodyFile = ""
+						}
+					} else {
+						err = store.Put(key, bytes.NewReader(item.Response.Body))
+					}
 
-				if err != nil {
-					item.Reject(false)
-					log.Errorln("Couldn't write to disk (rejecting):", filePath, err)
-					continue
+					if err != nil {
+						removeBodyFile(item)
+						item.Reject(false)
+						log.Errorln("Couldn't write to store (rejecting):", key, err)
+						continue
+					}
+
+					log.Debugln("Wrote URL body to store for:", item.URL())
+
+					// A compressed copy alongside the file lets nginx's
+					// gzip_static serve it without compressing it itself.
+					if gzipFiles && item.Response.Compressible() {
+						if err = writeGzipCopy(store, key); err != nil {
+							log.Errorln("Couldn't write compressed copy of item:", key, err)
+						}
+					}
+
+					if sidecars {
+						if err = sidecar.Put(store, key, newSidecar(item.Response, contentHash)); err != nil {
+							log.Errorln("Couldn't write sidecar of item:", key, err)
+						}
+					}
+
+					if validators != nil {
+						storeValidators(validators, item)
+					}
 				}
+			}
 
-				log.Debugln("Wrote URL body to disk for:", item.URL())
+			if redirectMap != nil && len(item.Response.Redirects) > 0 {
+				if err = redirectMap.Add(item.Response.Redirects); err != nil {
+					log.Errorln("Couldn't add item to redirect map:", item.URL(), err)
+				}
 			}
 
 			contentType, err := item.Response.ParseContentType()
@@ -198,9 +501,10 @@ func WriteItemToDisk(basePath string, crawlChannel <-chan *CrawlerMessageItem) <
 				continue
 			}
 
-			// Only send HTML pages for URL extraction. All other
-			// pages should be written directly to disk and acknowledged.
-			if contentType == http_crawler.HTML {
+			// Only send HTML pages and stylesheets for URL extraction.
+			// All other pages should be written directly to disk and
+			// acknowledged.
+			if contentType == http_crawler.HTML || contentType == http_crawler.CSS {
 				extract <- item
 			} else {
 				item.Ack(false)
@@ -215,21 +519,144 @@ func WriteItemToDisk(basePath string, crawlChannel <-chan *CrawlerMessageItem) <
 	return extractChannel
 }
 
-func ExtractURLs(extractChannel <-chan *CrawlerMessageItem) (<-chan *url.URL, <-chan *CrawlerMessageItem) {
-	publishChannel := make(chan *url.URL, 100)
+func readKey(store storage.Store, key string) ([]byte, error) {
+	body, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return ioutil.ReadAll(body)
+}
+
+// checkKeyExists returns os.ErrNotExist if there's no body at key.
+func checkKeyExists(store storage.Store, key string) error {
+	exists, err := store.Exists(key)
+	if err == nil && !exists {
+		err = os.ErrNotExist
+	}
+
+	return err
+}
+
+// writeGzipCopy writes a gzipped copy of the body at key to the same key
+// with .gz appended.
+func writeGzipCopy(store storage.Store, key string) error {
+	body, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	reader, writer := io.Pipe()
+
+	go func() {
+		gzipWriter, err := gzip.NewWriterLevel(writer, gzip.BestCompression)
+		if err == nil {
+			_, err = io.Copy(gzipWriter, body)
+		}
+		if err == nil {
+			err = gzipWriter.Close()
+		}
+
+		writer.CloseWithError(err)
+	}()
+
+	err = store.Put(key+".gz", reader)
+	reader.CloseWithError(err)
+
+	if err != nil {
+		store.Delete(key + ".gz")
+	}
+
+	return err
+}
+
+func bodyHash(response *http_crawler.CrawlerResponse) (string, error) {
+	if response.BodyFile == "" {
+		return sidecar.ContentHash(bytes.NewReader(response.Body))
+	}
+
+	file, err := os.Open(response.BodyFile)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return sidecar.ContentHash(file)
+}
+
+// newSidecar describes response for a web server serving the mirror.
+// Cookies aren't kept, as they're no use to it.
+func newSidecar(response *http_crawler.CrawlerResponse, contentHash string) *sidecar.Metadata {
+	header := http.Header{}
+	for name, values := range response.Header {
+		header[name] = values
+	}
+	header.Del("Set-Cookie")
+
+	return &sidecar.Metadata{
+		URL:         response.URL.String(),
+		StatusCode:  response.StatusCode,
+		Header:      header,
+		ContentType: response.ContentType,
+		CrawledAt:   response.StartedAt,
+		ETag:        response.ETag,
+		ContentHash: contentHash,
+	}
+}
+
+func removeBodyFile(item *CrawlerMessageItem) {
+	if err := item.Response.RemoveBodyFile(); err != nil {
+		log.Errorln("Couldn't remove streamed body of item:", item.URL(), err)
+	}
+}
+
+// Validators are only stored once the body they describe is safely on disk,
+// so that a later 304 Not Modified always has a copy to fall back on.
+func storeValidators(validators http_crawler.ValidatorStore, item *CrawlerMessageItem) {
+	var err error
+
+	if v := item.Response.Validators(); v != nil {
+		err = validators.Set(item.Response.URL, v)
+	} else {
+		err = validators.Delete(item.Response.URL)
+	}
+
+	if err != nil {
+		log.Errorln("Couldn't store validators for item:", item.URL(), err)
+	}
+}
+
+// A Link is a URL extracted from a page, which is one link deeper than the
+// page it was found on.
+type Link struct {
+	URL      *url.URL
+	Referrer string
+	Depth    int
+	SeedID   string
+	// Priority is only given to seeds from sitemaps. Links found on a
+	// page aren't given its priority, so that it isn't passed on to
+	// everything that can be reached from it.
+	Priority int
+}
+
+func ExtractURLs(skipRels []string, extractChannel <-chan *CrawlerMessageItem) (<-chan *Link, <-chan *CrawlerMessageItem) {
+	publishChannel := make(chan *Link, 100)
 	acknowledgeChannel := make(chan *CrawlerMessageItem, 1)
 
 	extractLoop := func(
 		extract <-chan *CrawlerMessageItem,
-		publish chan<- *url.URL,
+		publish chan<- *Link,
 		acknowledge chan<- *CrawlerMessageItem,
 	) {
 		for item := range extract {
 			start := time.Now()
+			item.SkipRels = skipRels
 			urls, err := item.ExtractURLs()
 			if err != nil {
 				item.Reject(false)
-				log.Errorln("ExtractURLs (rejecting):", string(item.Body), err)
+				log.Errorln("ExtractURLs (rejecting):", item.URL(), err)
 
 				continue
 			}
@@ -237,7 +664,12 @@ func ExtractURLs(extractChannel <-chan *CrawlerMessageItem) (<-chan *url.URL, <-
 			log.Debugln("Extracted URLs:", len(urls))
 
 			for _, u := range urls {
-				publish <- u
+				publish <- &Link{
+					URL:      u,
+					Referrer: item.URL(),
+					Depth:    item.Depth() + 1,
+					SeedID:   item.SeedID(),
+				}
 			}
 
 			acknowledge <- item
@@ -251,22 +683,121 @@ func ExtractURLs(extractChannel <-chan *CrawlerMessageItem) (<-chan *url.URL, <-
 	return publishChannel, acknowledgeChannel
 }
 
-func PublishURLs(ttlHashSet *ttl_hash_set.TTLHashSet, queueManager *queue.Manager, publish <-chan *url.URL) {
-	for uu := range publish {
+// SeedFromSitemaps reads sitemapURLs, along with the sitemaps listed by the
+// robots.txt files of rootURLs if fromRobotsTxt is set, and sends every
+// page they list on our hosts to be published as a seed URL. Pages are
+// prioritised by when they were last modified if prioritise is set. The
+// channel is closed once every sitemap has been read.
+func SeedFromSitemaps(
+	fetcher sitemap.Fetcher,
+	rootURLs []*url.URL,
+	sitemapURLs []*url.URL,
+	fromRobotsTxt bool,
+	urlRules *url_rules.Rules,
+	prioritise bool,
+) <-chan *Link {
+	publishChannel := make(chan *Link, 100)
+
+	seedLoop := func(publish chan<- *Link) {
+		defer close(publish)
+
+		sitemaps := sitemapURLs
+		if fromRobotsTxt {
+			for _, rootURL := range rootURLs {
+				listed, err := sitemap.FromRobotsTxt(fetcher, rootURL)
+				if err != nil {
+					log.Errorln("Couldn't read sitemaps from robots.txt:", rootURL.String(), err)
+					continue
+				}
+
+				sitemaps = append(sitemaps, listed...)
+			}
+		}
 
-		u := uu.String()
+		count := 0
+		now := time.Now()
 
-		if uu.RawQuery != "" {
-			values, err := url.ParseQuery(uu.RawQuery)
+		sitemap.Walk(fetcher, sitemaps, func(sitemapURL *url.URL, entry *sitemap.Entry, err error) {
 			if err != nil {
-				log.Debugln("Skipping URL unable to decode query params:", u)
-				continue
+				log.Errorln("Couldn't read sitemap:", sitemapURL.String(), err)
+				return
 			}
-			values.Del("page") // ignore pagination params. This ensures we process URL from every page of our search pages
-			if values.Encode() != "" {
-				log.Debugln("Skipping URL as it has query params:", u)
-				continue
+
+			u, err := sitemapURL.Parse(entry.Loc)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				log.Debugln("Skipping invalid URL in sitemap:", sitemapURL.String(), entry.Loc)
+				return
+			}
+			u.Fragment = ""
+
+			if !http_crawler.IsAllowedHost(u.Host, rootURLs) || urlRules.Excludes(u) {
+				return
+			}
+
+			link := &Link{URL: u, Referrer: sitemapURL.String()}
+			if prioritise {
+				link.Priority = sitemap.LastModPriority(entry.LastMod, now)
+			}
+
+			publish <- link
+			count++
+		})
+
+		log.Infoln("Read URLs from sitemaps:", count)
+	}
+
+	go seedLoop(publishChannel)
+
+	return publishChannel
+}
+
+// MergeLinks returns a channel of the links sent on every one of channels,
+// which is closed once they all are.
+func MergeLinks(channels ...<-chan *Link) <-chan *Link {
+	merged := make(chan *Link, 100)
+
+	var wg sync.WaitGroup
+	wg.Add(len(channels))
+
+	for _, channel := range channels {
+		go func(links <-chan *Link) {
+			defer wg.Done()
+
+			for link := range links {
+				merged <- link
 			}
+		}(channel)
+	}
+
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	return merged
+}
+
+func PublishURLs(
+	ttlHashSet *ttl_hash_set.TTLHashSet,
+	queueManager *queue.Manager,
+	normaliser *url_normaliser.Normaliser,
+	queryParams *QueryParamAllowList,
+	limits *CrawlLimits,
+	publish <-chan *Link,
+) {
+	for link := range publish {
+		uu := normaliser.Normalise(link.URL)
+
+		u := uu.String()
+
+		if !queryParams.Allows(uu) {
+			log.Debugln("Skipping URL as it has query params that aren't allowed:", u)
+			continue
+		}
+
+		if limits.ExceedsDepth(link.Depth) {
+			log.Debugf("Skipping URL as it's more than %d links deep: %s", limits.MaxDepth, u)
+			continue
 		}
 
 		start := time.Now()
@@ -281,10 +812,17 @@ func PublishURLs(ttlHashSet *ttl_hash_set.TTLHashSet, queueManager *queue.Manage
 			log.Debugln("URL is already in the queue:", u)
 		} else if queueStatus > Enqueued {
 			log.Debugln("URL is already in the queue (reporting 5XX's):", u)
-		} else {
+		} else if withinPageBudget(ttlHashSet, limits, uu) {
 			ttlHashSet.Set(u, Enqueued)
 
-			err = queueManager.Publish("#", "text/plain", u)
+			err = queueManager.PublishMessage("#", &queue.Message{
+				URL:          u,
+				Referrer:     link.Referrer,
+				Depth:        link.Depth,
+				DiscoveredAt: time.Now().UTC(),
+				SeedID:       link.SeedID,
+				Priority:     link.Priority,
+			})
 			if err != nil {
 				log.Fatalln("Delivery failed:", u, err)
 			}
@@ -295,6 +833,37 @@ func PublishURLs(ttlHashSet *ttl_hash_set.TTLHashSet, queueManager *queue.Manage
 	}
 }
 
+// withinPageBudget reports whether there's room in the page budget for u,
+// using it up if there is. Counts expire with the same TTL as the queue
+// status of URLs, so a budget is renewed once its prefix stops being
+// crawled.
+func withinPageBudget(ttlHashSet *ttl_hash_set.TTLHashSet, limits *CrawlLimits, u *url.URL) bool {
+	prefix, budget := limits.PageBudget(u.Path)
+	if budget == 0 {
+		return true
+	}
+
+	key := pageBudgetKeyPrefix + prefix
+
+	count, err := ttlHashSet.Get(key)
+	if err != nil {
+		log.Errorln("Couldn't check page budget for URL:", u.String(), err)
+		return false
+	}
+
+	if count >= budget {
+		log.Debugf("Skipping URL as the budget of %d pages under %s is used up: %s", budget, prefix, u.String())
+		util.StatsDIncrement("page_budget_exceeded")
+		return false
+	}
+
+	if err = ttlHashSet.Incr(key); err != nil {
+		log.Errorln("Couldn't update page budget for URL:", u.String(), err)
+	}
+
+	return true
+}
+
 func AcknowledgeItem(inbound <-chan *CrawlerMessageItem, ttlHashSet *ttl_hash_set.TTLHashSet) {
 	for item := range inbound {
 		start := time.Now()
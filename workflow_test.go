@@ -1,6 +1,8 @@
 package main_test
 
 import (
+	"compress/gzip"
+	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -8,6 +10,7 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	. "github.com/alphagov/govuk_crawler_worker"
@@ -17,7 +20,12 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"github.com/alphagov/govuk_crawler_worker/sidecar"
+	"github.com/alphagov/govuk_crawler_worker/storage"
+	"github.com/alphagov/govuk_crawler_worker/url_normaliser"
+	"github.com/alphagov/govuk_crawler_worker/url_rules"
 	"github.com/alphagov/govuk_crawler_worker/util"
+	"github.com/alphagov/govuk_crawler_worker/warc"
 	"github.com/streadway/amqp"
 )
 
@@ -33,6 +41,7 @@ var _ = Describe("Workflow", func() {
 			err, queueManagerErr, ttlHashSetErr error
 
 			mirrorRoot   string
+			queryParams  *QueryParamAllowList
 			queueManager *Manager
 			ttlHashSet   *TTLHashSet
 			rootURLs     []*url.URL
@@ -62,6 +71,9 @@ var _ = Describe("Workflow", func() {
 			rootURLs = []*url.URL{urlA, urlB}
 			token = "cho1coociexei7aech8Zah1rageef2SheewaiQuilaeze1lawoobahcohtheWeik"
 
+			queryParams, err = ParseQueryParamAllowList(DefaultQueryParams)
+			Expect(err).To(BeNil())
+
 			testURL = &url.URL{
 				Scheme: "https",
 				Host:   "www.gov.uk",
@@ -117,7 +129,7 @@ var _ = Describe("Workflow", func() {
 				Expect(err).To(BeNil())
 
 				for item := range deliveries {
-					outbound <- NewCrawlerMessageItem(item, rootURLs, []string{})
+					outbound <- NewCrawlerMessageItem(item, rootURLs, nil)
 					item.Ack(false)
 					break
 				}
@@ -141,7 +153,8 @@ var _ = Describe("Workflow", func() {
 				urlA, _ := url.Parse("http://127.0.0.1")
 				urlB, _ := url.Parse("http://127.0.0.2")
 				rootURLs = []*url.URL{urlA, urlB}
-				crawler = NewCrawler(rootURLs, "0.0.0", token, nil)
+				crawler, err = NewCrawler(rootURLs, "0.0.0", CrawlerOptions{RateLimitToken: token})
+				Expect(err).To(BeNil())
 				Expect(crawler).ToNot(BeNil())
 			})
 
@@ -152,9 +165,9 @@ var _ = Describe("Workflow", func() {
 				server := testServer(http.StatusOK, body)
 
 				deliveryItem := &amqp.Delivery{Body: []byte(server.URL)}
-				outbound <- NewCrawlerMessageItem(*deliveryItem, rootURLs, []string{})
+				outbound <- NewCrawlerMessageItem(*deliveryItem, rootURLs, nil)
 
-				crawled := CrawlURL(ttlHashSet, outbound, crawler, 1, 1)
+				crawled := CrawlURL(ttlHashSet, queueManager, outbound, crawler, 1, 1)
 
 				Expect((<-crawled).Response.Body[0:24]).To(Equal([]byte(body)))
 
@@ -171,7 +184,7 @@ var _ = Describe("Workflow", func() {
 				deliveries, err := queueManager.Consume()
 				Expect(err).To(BeNil())
 
-				crawlChan := ReadFromQueue(deliveries, rootURLs, ttlHashSet, []string{}, 1)
+				crawlChan := ReadFromQueue(deliveries, rootURLs, ttlHashSet, nil, nil, 1)
 				Expect(len(crawlChan)).To(Equal(0))
 
 				maxRetries := 2
@@ -180,7 +193,7 @@ var _ = Describe("Workflow", func() {
 				Expect(err).To(BeNil())
 				Eventually(crawlChan).Should(HaveLen(1))
 
-				crawled := CrawlURL(ttlHashSet, crawlChan, crawler, 1, maxRetries)
+				crawled := CrawlURL(ttlHashSet, queueManager, crawlChan, crawler, 1, maxRetries)
 				Eventually(crawlChan).Should(HaveLen(0))
 
 				Eventually(func() (int, error) {
@@ -197,6 +210,36 @@ var _ = Describe("Workflow", func() {
 				close(crawlChan)
 			})
 
+			It("queues an item it's retrying again with its attempts raised", func() {
+				server := testServer(http.StatusInternalServerError, "")
+
+				ttlHashSet.Set(server.URL, Enqueued)
+
+				err = queueManager.PublishMessage("#", &Message{URL: server.URL, Depth: 2, Attempts: 1})
+				Expect(err).To(BeNil())
+
+				deliveries, err := queueManager.Consume()
+				Expect(err).To(BeNil())
+
+				crawlChan := make(chan *CrawlerMessageItem, 1)
+				crawlChan <- NewCrawlerMessageItem(<-deliveries, rootURLs, nil)
+
+				CrawlURL(ttlHashSet, queueManager, crawlChan, crawler, 1, 4)
+
+				var retried amqp.Delivery
+				Eventually(deliveries).Should(Receive(&retried))
+
+				message, err := DecodeMessage(retried)
+				Expect(err).To(BeNil())
+				Expect(message.URL).To(Equal(server.URL))
+				Expect(message.Depth).To(Equal(2))
+				Expect(message.Attempts).To(Equal(2))
+
+				retried.Ack(false)
+				server.Close()
+				close(crawlChan)
+			})
+
 			It("resets a parsed URL so it can be added back into the queue immediately retry it", func() {
 				body := `I am not HTML. No HTML, see?`
 				server := testServer(http.StatusOK, body)
@@ -206,7 +249,7 @@ var _ = Describe("Workflow", func() {
 				deliveries, err := queueManager.Consume()
 				Expect(err).To(BeNil())
 
-				crawlChan := ReadFromQueue(deliveries, rootURLs, ttlHashSet, []string{}, 1)
+				crawlChan := ReadFromQueue(deliveries, rootURLs, ttlHashSet, nil, nil, 1)
 				Expect(len(crawlChan)).To(Equal(0))
 
 				maxRetries := 4
@@ -215,7 +258,7 @@ var _ = Describe("Workflow", func() {
 				Expect(err).To(BeNil())
 				Eventually(crawlChan).Should(HaveLen(1))
 
-				crawled := CrawlURL(ttlHashSet, crawlChan, crawler, 1, maxRetries)
+				crawled := CrawlURL(ttlHashSet, queueManager, crawlChan, crawler, 1, maxRetries)
 				Eventually(crawlChan).Should(HaveLen(0))
 
 				Eventually(func() (int, error) {
@@ -232,24 +275,93 @@ var _ = Describe("Workflow", func() {
 				close(crawlChan)
 			})
 
-			It("expects the number of goroutines to run to be a positive integer", func() {
+			It("acknowledges items that are disallowed by robots.txt without retrying them", func() {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Path == "/robots.txt" {
+						fmt.Fprintln(w, "User-agent: *\nDisallow: /private")
+						return
+					}
+
+					fmt.Fprintln(w, `<a href="gov.uk">bar</a>`)
+				}))
+				privateURL := server.URL + "/private"
+
+				ttlHashSet.Set(privateURL, Enqueued)
+
+				deliveries, err := queueManager.Consume()
+				Expect(err).To(BeNil())
+
+				crawlChan := ReadFromQueue(deliveries, rootURLs, ttlHashSet, nil, nil, 1)
+				Expect(len(crawlChan)).To(Equal(0))
+
+				err = queueManager.Publish("#", "text/plain", privateURL)
+				Expect(err).To(BeNil())
+				Eventually(crawlChan).Should(HaveLen(1))
+
+				crawled := CrawlURL(ttlHashSet, queueManager, crawlChan, crawler, 1, 4)
+				Eventually(crawlChan).Should(HaveLen(0))
+
+				Eventually(func() (int, error) {
+					queueInfo, err := queueManager.Producer.Channel.QueueInspect(queueManager.QueueName)
+					return queueInfo.Messages, err
+				}).Should(Equal(0))
+				Expect(len(crawled)).To(Equal(0))
+
+				Expect(ttlHashSet.Get(privateURL)).To(Equal(Enqueued))
+
+				server.Close()
+				close(crawlChan)
+			})
+
+			It("expects the number of items crawled at once to be a positive integer", func() {
 				outbound := make(chan *CrawlerMessageItem, 1)
 
 				Expect(func() {
-					CrawlURL(ttlHashSet, outbound, crawler, 0, 1)
+					CrawlURL(ttlHashSet, queueManager, outbound, crawler, 0, 1)
 				}).To(Panic())
 
 				Expect(func() {
-					CrawlURL(ttlHashSet, outbound, crawler, -1, 1)
+					CrawlURL(ttlHashSet, queueManager, outbound, crawler, -1, 1)
 				}).To(Panic())
 			})
+
+			It("doesn't let an item waiting on a busy host hold up other hosts", func() {
+				slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.URL.Path != "/robots.txt" {
+						time.Sleep(time.Second)
+					}
+					fmt.Fprintln(w, `<a href="gov.uk">slow</a>`)
+				}))
+				fastServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					fmt.Fprintln(w, `<a href="gov.uk">fast</a>`)
+				}))
+				slowURL, _ := url.Parse(slowServer.URL)
+				fastURL, _ := url.Parse(fastServer.URL)
+
+				crawler.Scheduler = NewScheduler(2, HostLimit{MaxInFlight: 1}, nil)
+
+				outbound := make(chan *CrawlerMessageItem, 3)
+				for _, u := range []string{slowServer.URL + "/a", slowServer.URL + "/b", fastServer.URL + "/c"} {
+					outbound <- NewCrawlerMessageItem(amqp.Delivery{Body: []byte(u)}, []*url.URL{slowURL, fastURL}, nil)
+				}
+
+				crawled := CrawlURL(ttlHashSet, queueManager, outbound, crawler, 3, 1)
+
+				var first *CrawlerMessageItem
+				Eventually(crawled, 900*time.Millisecond).Should(Receive(&first))
+				Expect(first.URL()).To(Equal(fastServer.URL + "/c"))
+
+				slowServer.Close()
+				fastServer.Close()
+				close(outbound)
+			})
 		})
 
 		Describe("WriteItemToDisk", func() {
 			It("wrote the item to disk", func() {
 				u := "https://www.gov.uk/extract-some-urls"
 				deliveryItem := &amqp.Delivery{Body: []byte(u)}
-				item := NewCrawlerMessageItem(*deliveryItem, rootURLs, []string{})
+				item := NewCrawlerMessageItem(*deliveryItem, rootURLs, nil)
 				item.Response = &CrawlerResponse{
 					Body:        []byte(`<a href="https://www.gov.uk/some-url">a link</a>`),
 					ContentType: HTML,
@@ -257,7 +369,7 @@ var _ = Describe("Workflow", func() {
 				}
 
 				outbound := make(chan *CrawlerMessageItem, 1)
-				extract := WriteItemToDisk(mirrorRoot, outbound)
+				extract := WriteItemToDisk(storage.NewFileStore(mirrorRoot), nil, nil, nil, nil, false, false, outbound)
 
 				Expect(len(extract)).To(Equal(0))
 
@@ -276,10 +388,180 @@ var _ = Describe("Workflow", func() {
 				close(outbound)
 			})
 
-			It("does not write item to disk if it has query params", func() {
+			It("moves a streamed body into place", func() {
+				body := []byte("%PDF-1.4")
+				bodyFile, err := ioutil.TempFile(mirrorRoot, "body-")
+				Expect(err).To(BeNil())
+				bodyFile.Write(body)
+				bodyFile.Close()
+
+				u := "https://www.gov.uk/attachment.pdf"
+				deliveryItem := &amqp.Delivery{Body: []byte(u)}
+				item := NewCrawlerMessageItem(*deliveryItem, rootURLs, nil)
+				item.Response = &CrawlerResponse{
+					BodyFile:    bodyFile.Name(),
+					ContentType: PDF,
+					URL:         testURL,
+				}
+
+				outbound := make(chan *CrawlerMessageItem, 1)
+				WriteItemToDisk(storage.NewFileStore(mirrorRoot), nil, nil, nil, nil, false, false, outbound)
+
+				outbound <- item
+
+				relativeFilePath, _ := item.RelativeFilePath()
+				filePath := path.Join(mirrorRoot, relativeFilePath)
+				Eventually(func() []byte {
+					content, _ := ioutil.ReadFile(filePath)
+					return content
+				}).Should(Equal(body))
+
+				_, err = os.Stat(bodyFile.Name())
+				Expect(os.IsNotExist(err)).To(BeTrue())
+
+				close(outbound)
+			})
+
+			It("adds redirected items to the redirect map", func() {
+				mapPath := path.Join(mirrorRoot, "redirects.map")
+				redirectMap, err := NewRedirectMap(mapPath, NginxRedirectMap)
+				Expect(err).To(BeNil())
+
+				u := "https://www.gov.uk/old"
+				itemURL, _ := url.Parse(u)
+				destination, _ := url.Parse("https://www.gov.uk/new")
+				deliveryItem := &amqp.Delivery{Body: []byte(u)}
+				item := NewCrawlerMessageItem(*deliveryItem, rootURLs, nil)
+				item.Response = &CrawlerResponse{
+					Body:        []byte(`<a href="https://www.gov.uk/new">a link</a>`),
+					ContentType: HTML,
+					URL:         itemURL,
+					Redirects:   []Redirect{{From: itemURL, To: destination, StatusCode: 301}},
+				}
+
+				outbound := make(chan *CrawlerMessageItem, 1)
+				extract := WriteItemToDisk(storage.NewFileStore(mirrorRoot), nil, nil, redirectMap, nil, false, false, outbound)
+
+				outbound <- item
+				Expect(<-extract).To(Equal(item))
+
+				Expect(ioutil.ReadFile(mapPath)).To(Equal([]byte("www.gov.uk/old /new;\n")))
+
+				close(outbound)
+			})
+
+			It("writes a gzipped copy of text items alongside them", func() {
+				body := []byte(`<a href="https://www.gov.uk/some-url">a link</a>`)
+				u := "https://www.gov.uk/compressed"
+				deliveryItem := &amqp.Delivery{Body: []byte(u)}
+				item := NewCrawlerMessageItem(*deliveryItem, rootURLs, nil)
+				item.Response = &CrawlerResponse{
+					Body:        body,
+					ContentType: HTML,
+					URL:         testURL,
+				}
+
+				outbound := make(chan *CrawlerMessageItem, 1)
+				extract := WriteItemToDisk(storage.NewFileStore(mirrorRoot), nil, nil, nil, nil, true, false, outbound)
+
+				outbound <- item
+				Expect(<-extract).To(Equal(item))
+
+				relativeFilePath, _ := item.RelativeFilePath()
+				gzipFile, err := os.Open(path.Join(mirrorRoot, relativeFilePath) + ".gz")
+				Expect(err).To(BeNil())
+				defer gzipFile.Close()
+
+				reader, err := gzip.NewReader(gzipFile)
+				Expect(err).To(BeNil())
+				Expect(ioutil.ReadAll(reader)).To(Equal(body))
+
+				close(outbound)
+			})
+
+			It("stores the validators of an item once it's been written to disk", func() {
+				validators := NewFileValidatorStore(path.Join(mirrorRoot, "validators"))
+
+				u := "https://www.gov.uk/validated"
+				itemURL, _ := url.Parse(u)
+				deliveryItem := &amqp.Delivery{Body: []byte(u)}
+				item := NewCrawlerMessageItem(*deliveryItem, rootURLs, nil)
+				item.Response = &CrawlerResponse{
+					Body:        []byte(`<a href="https://www.gov.uk/some-url">a link</a>`),
+					ContentType: HTML,
+					URL:         itemURL,
+					ETag:        `"v1"`,
+				}
+
+				outbound := make(chan *CrawlerMessageItem, 1)
+				extract := WriteItemToDisk(storage.NewFileStore(mirrorRoot), nil, validators, nil, nil, false, false, outbound)
+
+				outbound <- item
+				Expect(<-extract).To(Equal(item))
+
+				Expect(validators.Get(itemURL)).To(Equal(&Validators{ETag: `"v1"`, ContentType: HTML}))
+
+				close(outbound)
+			})
+
+			It("keeps the copy on disk of an unchanged item and reads it for extraction", func() {
+				u := "https://www.gov.uk/unchanged"
+				itemURL, _ := url.Parse(u)
+				deliveryItem := &amqp.Delivery{Body: []byte(u)}
+				item := NewCrawlerMessageItem(*deliveryItem, rootURLs, nil)
+				item.Response = &CrawlerResponse{
+					ContentType: HTML,
+					URL:         itemURL,
+					NotModified: true,
+				}
+
+				relativeFilePath, _ := item.RelativeFilePath()
+				filePath := path.Join(mirrorRoot, relativeFilePath)
+				storedBody := []byte(`<a href="https://www.gov.uk/some-url">a link</a>`)
+
+				Expect(os.MkdirAll(path.Dir(filePath), 0755)).To(BeNil())
+				Expect(ioutil.WriteFile(filePath, storedBody, 0644)).To(BeNil())
+
+				outbound := make(chan *CrawlerMessageItem, 1)
+				extract := WriteItemToDisk(storage.NewFileStore(mirrorRoot), nil, nil, nil, nil, false, false, outbound)
+
+				outbound <- item
+
+				Expect((<-extract).Response.Body).To(Equal(storedBody))
+				Expect(ioutil.ReadFile(filePath)).To(Equal(storedBody))
+
+				close(outbound)
+			})
+
+			It("writes an item with allowed query params to a file named after them", func() {
+				u := "https://www.gov.uk/government/statistics?year=2015&page=2"
+				deliveryItem := &amqp.Delivery{Body: []byte(u)}
+				item := NewCrawlerMessageItem(*deliveryItem, rootURLs, nil)
+				item.Response = &CrawlerResponse{
+					Body:        []byte(`<a href="https://www.gov.uk/some-url">a link</a>`),
+					ContentType: HTML,
+					URL:         testURL,
+				}
+
+				statisticsParams, err := ParseQueryParamAllowList("/government/statistics=page:year")
+				Expect(err).To(BeNil())
+
+				outbound := make(chan *CrawlerMessageItem, 1)
+				extract := WriteItemToDisk(storage.NewFileStore(mirrorRoot), nil, nil, nil, statisticsParams, false, false, outbound)
+
+				outbound <- item
+
+				Expect(<-extract).To(Equal(item))
+				Expect(ioutil.ReadFile(path.Join(mirrorRoot, "www.gov.uk/government/statistics@page=2&year=2015.html"))).
+					To(Equal(item.Response.Body))
+
+				close(outbound)
+			})
+
+			It("does not write item to disk if it has query params that aren't allowed", func() {
 				u := "https://www.gov.uk/extract-some-urls-with-params?page=1"
 				deliveryItem := &amqp.Delivery{Body: []byte(u)}
-				item := NewCrawlerMessageItem(*deliveryItem, rootURLs, []string{})
+				item := NewCrawlerMessageItem(*deliveryItem, rootURLs, nil)
 				item.Response = &CrawlerResponse{
 					Body:        []byte(`<a href="https://www.gov.uk/some-url">a link</a>`),
 					ContentType: HTML,
@@ -287,7 +569,7 @@ var _ = Describe("Workflow", func() {
 				}
 
 				outbound := make(chan *CrawlerMessageItem, 1)
-				extract := WriteItemToDisk(mirrorRoot, outbound)
+				extract := WriteItemToDisk(storage.NewFileStore(mirrorRoot), nil, nil, nil, nil, false, false, outbound)
 
 				Expect(len(extract)).To(Equal(0))
 
@@ -314,7 +596,7 @@ var _ = Describe("Workflow", func() {
 				err = queueManager.Publish("#", "text/plain", "https://www.gov.uk/extract-some-urls.json")
 				Expect(err).To(BeNil())
 
-				item := NewCrawlerMessageItem((<-deliveries), rootURLs, []string{})
+				item := NewCrawlerMessageItem((<-deliveries), rootURLs, nil)
 				item.Response = &CrawlerResponse{
 					Body:        body,
 					ContentType: JSON,
@@ -322,7 +604,7 @@ var _ = Describe("Workflow", func() {
 				}
 
 				outbound := make(chan *CrawlerMessageItem, 1)
-				extract := WriteItemToDisk(mirrorRoot, outbound)
+				extract := WriteItemToDisk(storage.NewFileStore(mirrorRoot), nil, nil, nil, nil, false, false, outbound)
 				Expect(len(extract)).To(Equal(0))
 
 				outbound <- item
@@ -342,14 +624,14 @@ var _ = Describe("Workflow", func() {
 			It("extracts URLs from the HTML body and adds them to a new channel; acknowledging item", func() {
 				u := "https://www.gov.uk/extract-some-urls"
 				deliveryItem := &amqp.Delivery{Body: []byte(u)}
-				item := NewCrawlerMessageItem(*deliveryItem, rootURLs, []string{})
+				item := NewCrawlerMessageItem(*deliveryItem, rootURLs, nil)
 				item.Response = &CrawlerResponse{
 					Body: []byte(`<a href="https://www.gov.uk/some-url">a link</a>`),
 					URL:  testURL,
 				}
 
 				outbound := make(chan *CrawlerMessageItem, 1)
-				publish, acknowledge := ExtractURLs(outbound)
+				publish, acknowledge := ExtractURLs([]string{}, outbound)
 
 				Expect(len(publish)).To(Equal(0))
 				Expect(len(acknowledge)).To(Equal(0))
@@ -357,11 +639,36 @@ var _ = Describe("Workflow", func() {
 				outbound <- item
 
 				url, _ := url.Parse("https://www.gov.uk/some-url")
-				Expect(<-publish).To(Equal(url))
+				Expect(<-publish).To(Equal(&Link{URL: url, Referrer: u, Depth: 1, SeedID: u}))
 				Expect(<-acknowledge).To(Equal(item))
 
 				close(outbound)
 			})
+
+			It("publishes URLs one link deeper than the item they were found in, with its crawl context", func() {
+				deliveryItem := &amqp.Delivery{
+					ContentType: JSONContentType,
+					Body:        []byte(`{"version": 1, "url": "https://www.gov.uk/extract-some-urls", "depth": 4, "seed_id": "https://www.gov.uk/", "priority": 9}`),
+				}
+				item := NewCrawlerMessageItem(*deliveryItem, rootURLs, nil)
+				item.Response = &CrawlerResponse{
+					Body: []byte(`<a href="https://www.gov.uk/some-url">a link</a>`),
+					URL:  testURL,
+				}
+
+				outbound := make(chan *CrawlerMessageItem, 1)
+				publish, _ := ExtractURLs([]string{}, outbound)
+
+				outbound <- item
+
+				link := <-publish
+				Expect(link.Depth).To(Equal(5))
+				Expect(link.Referrer).To(Equal("https://www.gov.uk/extract-some-urls"))
+				Expect(link.SeedID).To(Equal("https://www.gov.uk/"))
+				Expect(link.Priority).To(Equal(0))
+
+				close(outbound)
+			})
 		})
 
 		Describe("PublishURLs", func() {
@@ -372,19 +679,20 @@ var _ = Describe("Workflow", func() {
 				Expect(err).To(BeNil())
 				Expect(len(deliveries)).To(Equal(0))
 
-				publish := make(chan *url.URL, 1)
-				outbound := make(chan []byte, 1)
+				publish := make(chan *Link, 1)
+				outbound := make(chan string, 1)
 
 				go func() {
 					for item := range deliveries {
-						outbound <- item.Body
+						message, _ := DecodeMessage(item)
+						outbound <- message.URL
 						item.Ack(false)
 					}
 				}()
-				go PublishURLs(ttlHashSet, queueManager, publish)
+				go PublishURLs(ttlHashSet, queueManager, nil, queryParams, nil, publish)
 
 				url, _ := url.Parse(u)
-				publish <- url
+				publish <- &Link{URL: url}
 				Eventually(publish).Should(HaveLen(1))
 
 				Eventually(publish).Should(HaveLen(0))
@@ -402,27 +710,28 @@ var _ = Describe("Workflow", func() {
 				Expect(err).To(BeNil())
 				Expect(len(deliveries)).To(Equal(0))
 
-				publish := make(chan *url.URL, 1)
-				outbound := make(chan []byte, 1)
+				publish := make(chan *Link, 1)
+				outbound := make(chan string, 1)
 
 				go func() {
 					for item := range deliveries {
 
-						outbound <- item.Body
+						message, _ := DecodeMessage(item)
+						outbound <- message.URL
 						item.Ack(false)
 					}
 				}()
-				go PublishURLs(ttlHashSet, queueManager, publish)
+				go PublishURLs(ttlHashSet, queueManager, nil, queryParams, nil, publish)
 
 				url, _ := url.Parse(u)
-				publish <- url
+				publish <- &Link{URL: url}
 
 				Eventually(publish).Should(HaveLen(1))
 
 				Eventually(publish).Should(HaveLen(0))
 				Eventually(outbound).Should(HaveLen(1))
 
-				Expect(<-outbound).To(Equal([]byte(u)))
+				Expect(<-outbound).To(Equal(u))
 				Expect(len(publish)).To(Equal(0))
 
 				Eventually(func() (int, error) {
@@ -443,19 +752,20 @@ var _ = Describe("Workflow", func() {
 				err = ttlHashSet.Set(u, Enqueued)
 				Expect(err).To(BeNil())
 
-				publish := make(chan *url.URL, 1)
-				outbound := make(chan []byte, 1)
+				publish := make(chan *Link, 1)
+				outbound := make(chan string, 1)
 
 				go func() {
 					for item := range deliveries {
-						outbound <- item.Body
+						message, _ := DecodeMessage(item)
+						outbound <- message.URL
 						item.Ack(false)
 					}
 				}()
-				go PublishURLs(ttlHashSet, queueManager, publish)
+				go PublishURLs(ttlHashSet, queueManager, nil, queryParams, nil, publish)
 
 				url, _ := url.Parse(u)
-				publish <- url
+				publish <- &Link{URL: url}
 				Eventually(publish).Should(HaveLen(1))
 
 				Eventually(publish).Should(HaveLen(0))
@@ -476,19 +786,20 @@ var _ = Describe("Workflow", func() {
 				err = ttlHashSet.Incr(u)
 				Expect(err).To(BeNil())
 
-				publish := make(chan *url.URL, 1)
-				outbound := make(chan []byte, 1)
+				publish := make(chan *Link, 1)
+				outbound := make(chan string, 1)
 
 				go func() {
 					for item := range deliveries {
-						outbound <- item.Body
+						message, _ := DecodeMessage(item)
+						outbound <- message.URL
 						item.Ack(false)
 					}
 				}()
-				go PublishURLs(ttlHashSet, queueManager, publish)
+				go PublishURLs(ttlHashSet, queueManager, nil, queryParams, nil, publish)
 
 				url, _ := url.Parse(u)
-				publish <- url
+				publish <- &Link{URL: url}
 				Eventually(publish).Should(HaveLen(1))
 
 				Eventually(publish).Should(HaveLen(0))
@@ -506,21 +817,22 @@ var _ = Describe("Workflow", func() {
 				Expect(err).To(BeNil())
 				Expect(len(deliveries)).To(Equal(0))
 
-				publish := make(chan *url.URL, 1)
-				outbound := make(chan []byte, 1)
+				publish := make(chan *Link, 1)
+				outbound := make(chan string, 1)
 
 				go func() {
 					for item := range deliveries {
-						outbound <- item.Body
+						message, _ := DecodeMessage(item)
+						outbound <- message.URL
 						item.Ack(false)
 					}
 				}()
-				go PublishURLs(ttlHashSet, queueManager, publish)
+				go PublishURLs(ttlHashSet, queueManager, nil, queryParams, nil, publish)
 
 				url, _ := url.Parse(u)
-				publish <- url
+				publish <- &Link{URL: url}
 
-				Expect(<-outbound).To(Equal([]byte(u)))
+				Expect(<-outbound).To(Equal(u))
 				Expect(len(publish)).To(Equal(0))
 
 				Eventually(func() (int, error) {
@@ -532,12 +844,76 @@ var _ = Describe("Workflow", func() {
 			})
 		})
 
+		Describe("PublishURLs with crawl limits", func() {
+			var (
+				deliveries <-chan amqp.Delivery
+				publish    chan *Link
+			)
+
+			BeforeEach(func() {
+				deliveries, err = queueManager.Consume()
+				Expect(err).To(BeNil())
+
+				publish = make(chan *Link, 1)
+			})
+
+			AfterEach(func() {
+				close(publish)
+			})
+
+			It("publishes URLs with their depth", func() {
+				go PublishURLs(ttlHashSet, queueManager, nil, queryParams, nil, publish)
+
+				u, _ := url.Parse("https://www.gov.uk/deep")
+				publish <- &Link{URL: u, Depth: 3}
+
+				item := <-deliveries
+				item.Ack(false)
+				Expect(NewCrawlerMessageItem(item, rootURLs, nil).Depth()).To(Equal(3))
+			})
+
+			It("doesn't publish URLs deeper than the maximum depth", func() {
+				go PublishURLs(ttlHashSet, queueManager, nil, queryParams, &CrawlLimits{MaxDepth: 2}, publish)
+
+				tooDeep, _ := url.Parse("https://www.gov.uk/too-deep")
+				deepEnough, _ := url.Parse("https://www.gov.uk/deep-enough")
+				publish <- &Link{URL: tooDeep, Depth: 3}
+				publish <- &Link{URL: deepEnough, Depth: 2}
+
+				item := <-deliveries
+				item.Ack(false)
+				Expect(NewCrawlerMessageItem(item, rootURLs, nil).URL()).To(Equal(deepEnough.String()))
+
+				Expect(ttlHashSet.Get(tooDeep.String())).To(Equal(ReadyToEnqueue))
+			})
+
+			It("stops publishing URLs under a prefix once its page budget is used up", func() {
+				limits := &CrawlLimits{PageBudgets: map[string]int{"/government/statistics": 1}}
+				go PublishURLs(ttlHashSet, queueManager, nil, queryParams, limits, publish)
+
+				first, _ := url.Parse("https://www.gov.uk/government/statistics/first")
+				second, _ := url.Parse("https://www.gov.uk/government/statistics/second")
+				other, _ := url.Parse("https://www.gov.uk/government/news")
+				publish <- &Link{URL: first}
+				publish <- &Link{URL: second}
+				publish <- &Link{URL: other}
+
+				for _, expected := range []*url.URL{first, other} {
+					item := <-deliveries
+					item.Ack(false)
+					Expect(NewCrawlerMessageItem(item, rootURLs, nil).URL()).To(Equal(expected.String()))
+				}
+
+				Expect(ttlHashSet.Get(second.String())).To(Equal(ReadyToEnqueue))
+			})
+		})
+
 		Describe("ReadFromQueue", func() {
 			It("provides a way of converting AMQP bodies to CrawlerMessageItems", func() {
 				deliveries, err := queueManager.Consume()
 				Expect(err).To(BeNil())
 
-				outbound := ReadFromQueue(deliveries, rootURLs, ttlHashSet, []string{}, 1)
+				outbound := ReadFromQueue(deliveries, rootURLs, ttlHashSet, nil, nil, 1)
 				Expect(len(outbound)).To(Equal(0))
 
 				u := "https://www.gov.uk/bar"
@@ -551,6 +927,52 @@ var _ = Describe("Workflow", func() {
 				close(outbound)
 			})
 
+			It("normalises the URLs of CrawlerMessageItems", func() {
+				normaliser, err := url_normaliser.New(url_normaliser.DefaultRules, "")
+				Expect(err).To(BeNil())
+
+				deliveries := make(chan amqp.Delivery, 1)
+				deliveries <- amqp.Delivery{Body: []byte("HTTPS://WWW.GOV.UK:443/foo/../bar")}
+
+				outbound := ReadFromQueue(deliveries, rootURLs, ttlHashSet, nil, normaliser, 1)
+
+				item := <-outbound
+				Expect(item.URL()).To(Equal("https://www.gov.uk/bar"))
+				Expect(item.Normaliser).To(Equal(normaliser))
+
+				close(deliveries)
+			})
+
+			It("reads the URL and crawl context of JSON messages", func() {
+				deliveries, err := queueManager.Consume()
+				Expect(err).To(BeNil())
+
+				outbound := ReadFromQueue(deliveries, rootURLs, ttlHashSet, nil, nil, 1)
+
+				u := "https://www.gov.uk/bar"
+				err = queueManager.PublishMessage("#", &Message{URL: u, Depth: 2, SeedID: "https://www.gov.uk/"})
+				Expect(err).To(BeNil())
+
+				item := <-outbound
+				item.Ack(false)
+				Expect(item.URL()).To(Equal(u))
+				Expect(item.Depth()).To(Equal(2))
+				Expect(item.SeedID()).To(Equal("https://www.gov.uk/"))
+
+				close(outbound)
+			})
+
+			It("doesn't pass on messages it can't decode", func() {
+				deliveries := make(chan amqp.Delivery, 1)
+				deliveries <- amqp.Delivery{ContentType: JSONContentType, Body: []byte(`{"version": 99, "url": "https://www.gov.uk/bar"}`)}
+
+				outbound := ReadFromQueue(deliveries, rootURLs, ttlHashSet, nil, nil, 1)
+
+				Consistently(outbound).Should(BeEmpty())
+
+				close(deliveries)
+			})
+
 			It("drops CrawlerMessageItems containing a blacklisted URL", func() {
 				deliveries, err := queueManager.Consume()
 				Expect(err).To(BeNil())
@@ -573,7 +995,7 @@ var _ = Describe("Workflow", func() {
 				}()
 				Eventually(deliveriesBuffer).Should(HaveLen(1))
 
-				ReadFromQueue(deliveriesBuffer, rootURLs, ttlHashSet, []string{"/blacklisted"}, 1)
+				ReadFromQueue(deliveriesBuffer, rootURLs, ttlHashSet, url_rules.ExcludePrefixes([]string{"/blacklisted"}), nil, 1)
 
 				Eventually(func() (int, error) {
 					queueInfo, err := queueManager.Producer.Channel.QueueInspect(queueManager.QueueName)
@@ -582,8 +1004,476 @@ var _ = Describe("Workflow", func() {
 			})
 		})
 	})
+
+	Describe("WriteItemToDisk with other stores", func() {
+		var rootURLs []*url.URL
+
+		BeforeEach(func() {
+			rootURL, _ := url.Parse("https://www.gov.uk/")
+			rootURLs = []*url.URL{rootURL}
+		})
+
+		readKey := func(store storage.Store, key string) string {
+			body, err := store.Get(key)
+			Expect(err).To(BeNil())
+			defer body.Close()
+
+			contents, err := ioutil.ReadAll(body)
+			Expect(err).To(BeNil())
+
+			return string(contents)
+		}
+
+		It("writes items and their gzipped copies to the store", func() {
+			store := storage.NewMemoryStore()
+
+			itemURL, _ := url.Parse("https://www.gov.uk/foo")
+			item := NewCrawlerMessageItem(amqp.Delivery{Body: []byte(itemURL.String())}, rootURLs, nil)
+			item.Response = &CrawlerResponse{
+				Body:        []byte("<p>foo</p>"),
+				ContentType: HTML,
+				URL:         itemURL,
+			}
+
+			outbound := make(chan *CrawlerMessageItem, 1)
+			extract := WriteItemToDisk(store, nil, nil, nil, nil, true, false, outbound)
+
+			outbound <- item
+			Expect(<-extract).To(Equal(item))
+
+			Expect(readKey(store, "www.gov.uk/foo.html")).To(Equal("<p>foo</p>"))
+
+			reader, err := gzip.NewReader(strings.NewReader(readKey(store, "www.gov.uk/foo.html.gz")))
+			Expect(err).To(BeNil())
+			Expect(ioutil.ReadAll(reader)).To(Equal([]byte("<p>foo</p>")))
+
+			close(outbound)
+		})
+
+		It("only reads back the stored copies of unchanged pages and stylesheets", func() {
+			store := &readCountingStore{Store: storage.NewMemoryStore()}
+			Expect(store.Put("www.gov.uk/foo.pdf", strings.NewReader("%PDF-1.4"))).To(BeNil())
+			Expect(store.Put("www.gov.uk/foo.html", strings.NewReader("<p>foo</p>"))).To(BeNil())
+
+			pdfURL, _ := url.Parse("https://www.gov.uk/foo.pdf")
+			pdf := NewCrawlerMessageItem(amqp.Delivery{Body: []byte(pdfURL.String())}, rootURLs, nil)
+			pdf.Response = &CrawlerResponse{ContentType: "application/pdf", URL: pdfURL, NotModified: true}
+
+			pageURL, _ := url.Parse("https://www.gov.uk/foo")
+			page := NewCrawlerMessageItem(amqp.Delivery{Body: []byte(pageURL.String())}, rootURLs, nil)
+			page.Response = &CrawlerResponse{ContentType: HTML, URL: pageURL, NotModified: true}
+
+			outbound := make(chan *CrawlerMessageItem, 2)
+			extract := WriteItemToDisk(store, nil, nil, nil, nil, false, false, outbound)
+
+			outbound <- pdf
+			outbound <- page
+			Expect(<-extract).To(Equal(page))
+
+			Expect(page.Response.Body).To(Equal([]byte("<p>foo</p>")))
+			Expect(pdf.Response.Body).To(BeNil())
+			Expect(store.Reads()).To(Equal([]string{"www.gov.uk/foo.html"}))
+
+			close(outbound)
+		})
+
+		It("forgets the validators of an unchanged item whose stored copy is missing", func() {
+			validatorsDir, err := ioutil.TempDir("", "workflow_test")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(validatorsDir)
+
+			pdfURL, _ := url.Parse("https://www.gov.uk/foo.pdf")
+			validators := NewFileValidatorStore(validatorsDir)
+			Expect(validators.Set(pdfURL, &Validators{ETag: `"v1"`, ContentType: "application/pdf"})).To(BeNil())
+
+			pdf := NewCrawlerMessageItem(amqp.Delivery{Body: []byte(pdfURL.String())}, rootURLs, nil)
+			pdf.Response = &CrawlerResponse{ContentType: "application/pdf", URL: pdfURL, NotModified: true}
+
+			outbound := make(chan *CrawlerMessageItem, 1)
+			WriteItemToDisk(storage.NewMemoryStore(), nil, validators, nil, nil, false, false, outbound)
+
+			outbound <- pdf
+			Eventually(func() (*Validators, error) {
+				return validators.Get(pdfURL)
+			}).Should(BeNil())
+
+			close(outbound)
+		})
+
+		It("hands streamed bodies over to the store", func() {
+			store := storage.NewMemoryStore()
+
+			bodyFile, err := ioutil.TempFile("", "workflow_test")
+			Expect(err).To(BeNil())
+			bodyFile.WriteString("%PDF-1.4")
+			bodyFile.Close()
+
+			itemURL, _ := url.Parse("https://www.gov.uk/foo.pdf")
+			item := NewCrawlerMessageItem(amqp.Delivery{Body: []byte(itemURL.String())}, rootURLs, nil)
+			item.Response = &CrawlerResponse{
+				BodyFile:    bodyFile.Name(),
+				ContentType: "application/pdf",
+				URL:         itemURL,
+			}
+
+			outbound := make(chan *CrawlerMessageItem, 1)
+			WriteItemToDisk(store, nil, nil, nil, nil, false, false, outbound)
+
+			outbound <- item
+
+			Eventually(func() (bool, error) {
+				return store.Exists("www.gov.uk/foo.pdf")
+			}).Should(BeTrue())
+			Expect(readKey(store, "www.gov.uk/foo.pdf")).To(Equal("%PDF-1.4"))
+
+			_, err = os.Stat(bodyFile.Name())
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			close(outbound)
+		})
+
+		It("writes sidecars describing the response each item was written from", func() {
+			store := storage.NewMemoryStore()
+			crawledAt := time.Date(2014, 4, 1, 12, 0, 0, 0, time.UTC)
+
+			bodyFile, err := ioutil.TempFile("", "workflow_test")
+			Expect(err).To(BeNil())
+			bodyFile.WriteString("%PDF-1.4")
+			bodyFile.Close()
+
+			pdfURL, _ := url.Parse("https://www.gov.uk/foo.pdf")
+			pdf := NewCrawlerMessageItem(amqp.Delivery{Body: []byte(pdfURL.String())}, rootURLs, nil)
+			pdf.Response = &CrawlerResponse{
+				BodyFile:    bodyFile.Name(),
+				ContentType: "application/pdf",
+				URL:         pdfURL,
+				ETag:        `"abc"`,
+				ResponseMetadata: ResponseMetadata{
+					StatusCode: http.StatusOK,
+					Header: http.Header{
+						"Content-Disposition": []string{"attachment"},
+						"Set-Cookie":          []string{"session=secret"},
+					},
+					StartedAt: crawledAt,
+				},
+			}
+
+			pageURL, _ := url.Parse("https://www.gov.uk/foo")
+			page := NewCrawlerMessageItem(amqp.Delivery{Body: []byte(pageURL.String())}, rootURLs, nil)
+			page.Response = &CrawlerResponse{
+				Body:        []byte("<p>foo</p>"),
+				ContentType: "text/html; charset=utf-8",
+				URL:         pageURL,
+				ResponseMetadata: ResponseMetadata{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Cache-Control": []string{"max-age=1800"}},
+					StartedAt:  crawledAt,
+				},
+			}
+
+			outbound := make(chan *CrawlerMessageItem, 2)
+			extract := WriteItemToDisk(store, nil, nil, nil, nil, false, true, outbound)
+
+			outbound <- pdf
+			outbound <- page
+			Expect(<-extract).To(Equal(page))
+
+			Eventually(func() (bool, error) {
+				return store.Exists(".meta/www.gov.uk/foo.pdf.json")
+			}).Should(BeTrue())
+
+			Expect(sidecar.Get(store, "www.gov.uk/foo.pdf")).To(Equal(&sidecar.Metadata{
+				URL:         "https://www.gov.uk/foo.pdf",
+				StatusCode:  http.StatusOK,
+				Header:      http.Header{"Content-Disposition": []string{"attachment"}},
+				ContentType: "application/pdf",
+				CrawledAt:   crawledAt,
+				ETag:        `"abc"`,
+				ContentHash: "sha256:e16fa5d9b51928755db85b917f0297babaf22c7a47e97d9212adab56e61ba04e",
+				Key:         "www.gov.uk/foo.pdf",
+			}))
+
+			pageSidecar, err := sidecar.Get(store, "www.gov.uk/foo.html")
+			Expect(err).To(BeNil())
+			Expect(pageSidecar.ContentType).To(Equal("text/html; charset=utf-8"))
+			Expect(pageSidecar.Header.Get("Cache-Control")).To(Equal("max-age=1800"))
+			Expect(pageSidecar.ContentHash).To(Equal(
+				"sha256:" + fmt.Sprintf("%x", sha256.Sum256([]byte("<p>foo</p>")))))
+
+			close(outbound)
+		})
+	})
+
+	Describe("WriteWARC", func() {
+		var rootURLs []*url.URL
+		var warcDir string
+		var writer *warc.Writer
+
+		BeforeEach(func() {
+			rootURL, _ := url.Parse("https://www.gov.uk/")
+			rootURLs = []*url.URL{rootURL}
+
+			var err error
+			warcDir, err = ioutil.TempDir("", "workflow_test_warc")
+			Expect(err).To(BeNil())
+
+			writer, err = warc.NewWriter(warcDir, "test", 0, "GOV.UK Crawler Worker/0.0.0")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(warcDir)
+		})
+
+		// readWARC closes the writer and returns everything it wrote.
+		readWARC := func() string {
+			Expect(writer.Close()).To(BeNil())
+
+			files, err := ioutil.ReadDir(warcDir)
+			Expect(err).To(BeNil())
+			Expect(files).To(HaveLen(1))
+
+			file, err := os.Open(path.Join(warcDir, files[0].Name()))
+			Expect(err).To(BeNil())
+			defer file.Close()
+
+			reader, err := gzip.NewReader(file)
+			Expect(err).To(BeNil())
+
+			contents, err := ioutil.ReadAll(reader)
+			Expect(err).To(BeNil())
+
+			return string(contents)
+		}
+
+		It("records the request and response of each item", func() {
+			itemURL, _ := url.Parse("https://www.gov.uk/foo?bar=baz")
+			item := NewCrawlerMessageItem(amqp.Delivery{Body: []byte(itemURL.String())}, rootURLs, nil)
+			item.Response = &CrawlerResponse{
+				Body:        []byte("<p>foo</p>"),
+				ContentType: HTML,
+				URL:         itemURL,
+				ResponseMetadata: ResponseMetadata{
+					StatusCode: http.StatusOK,
+					Proto:      "HTTP/1.1",
+					Header: http.Header{
+						"Content-Type":     []string{"text/html"},
+						"Content-Encoding": []string{"gzip"},
+					},
+					RequestHeader: http.Header{
+						"User-Agent":       []string{"GOV.UK Crawler Worker/0.0.0"},
+						"Authorization":    []string{"Basic dXNlcm5hbWU6cGFzc3dvcmQ="},
+						"Rate-Limit-Token": []string{"secret"},
+					},
+					StartedAt: time.Date(2014, 4, 1, 12, 0, 0, 0, time.UTC),
+					RemoteIP:  "127.0.0.1",
+				},
+			}
+
+			outbound := make(chan *CrawlerMessageItem, 1)
+			persist := WriteWARC(writer, outbound)
+
+			outbound <- item
+			Expect(<-persist).To(Equal(item))
+
+			records := readWARC()
+			Expect(records).To(ContainSubstring("WARC-Type: request\r\n"))
+			Expect(records).To(ContainSubstring("WARC-Type: response\r\n"))
+			Expect(records).To(ContainSubstring("WARC-Target-URI: https://www.gov.uk/foo?bar=baz\r\n"))
+			Expect(records).To(ContainSubstring("WARC-Date: 2014-04-01T12:00:00Z\r\n"))
+			Expect(records).To(ContainSubstring("WARC-IP-Address: 127.0.0.1\r\n"))
+			Expect(records).To(ContainSubstring("WARC-Payload-Digest: " + warc.Digest([]byte("<p>foo</p>")) + "\r\n"))
+
+			Expect(records).To(ContainSubstring("GET /foo?bar=baz HTTP/1.1\r\nHost: www.gov.uk\r\n"))
+			Expect(records).To(ContainSubstring("User-Agent: GOV.UK Crawler Worker/0.0.0\r\n"))
+			Expect(records).To(ContainSubstring("Authorization: redacted\r\n"))
+			Expect(records).To(ContainSubstring("Rate-Limit-Token: redacted\r\n"))
+			Expect(records).ToNot(ContainSubstring("secret"))
+
+			Expect(records).To(ContainSubstring("HTTP/1.1 200 OK\r\n" +
+				"Content-Length: 10\r\n" +
+				"Content-Type: text/html\r\n" +
+				"\r\n" +
+				"<p>foo</p>"))
+			Expect(records).ToNot(ContainSubstring("Content-Encoding"))
+
+			close(outbound)
+		})
+
+		It("records streamed bodies without moving them", func() {
+			bodyFile, err := ioutil.TempFile("", "workflow_test")
+			Expect(err).To(BeNil())
+			bodyFile.WriteString("%PDF-1.4")
+			bodyFile.Close()
+			defer os.Remove(bodyFile.Name())
+
+			itemURL, _ := url.Parse("https://www.gov.uk/foo.pdf")
+			item := NewCrawlerMessageItem(amqp.Delivery{Body: []byte(itemURL.String())}, rootURLs, nil)
+			item.Response = &CrawlerResponse{
+				BodyFile:    bodyFile.Name(),
+				ContentType: "application/pdf",
+				URL:         itemURL,
+				ResponseMetadata: ResponseMetadata{
+					StatusCode: http.StatusOK,
+				},
+			}
+
+			outbound := make(chan *CrawlerMessageItem, 1)
+			persist := WriteWARC(writer, outbound)
+
+			outbound <- item
+			Expect(<-persist).To(Equal(item))
+
+			Expect(item.Response.BodyFile).To(Equal(bodyFile.Name()))
+			Expect(ioutil.ReadFile(bodyFile.Name())).To(Equal([]byte("%PDF-1.4")))
+			Expect(readWARC()).To(ContainSubstring("Content-Length: 8\r\n\r\n%PDF-1.4\r\n\r\n"))
+
+			close(outbound)
+		})
+
+		It("records redirects without the generated redirect page", func() {
+			itemURL, _ := url.Parse("https://www.gov.uk/foo")
+			location, _ := url.Parse("https://www.gov.uk/bar")
+			item := NewCrawlerMessageItem(amqp.Delivery{Body: []byte(itemURL.String())}, rootURLs, nil)
+			item.Response = &CrawlerResponse{
+				Body:        []byte("<p>Redirecting</p>"),
+				ContentType: HTML,
+				URL:         itemURL,
+				Redirects:   []Redirect{{From: itemURL, To: location, StatusCode: http.StatusMovedPermanently}},
+				ResponseMetadata: ResponseMetadata{
+					StatusCode: http.StatusMovedPermanently,
+					Proto:      "HTTP/1.1",
+					Header:     http.Header{"Location": []string{location.String()}},
+				},
+			}
+
+			outbound := make(chan *CrawlerMessageItem, 1)
+			persist := WriteWARC(writer, outbound)
+
+			outbound <- item
+			Expect(<-persist).To(Equal(item))
+
+			records := readWARC()
+			Expect(records).To(ContainSubstring("HTTP/1.1 301 Moved Permanently\r\n" +
+				"Content-Length: 0\r\n" +
+				"Location: https://www.gov.uk/bar\r\n" +
+				"\r\n\r\n\r\n"))
+			Expect(records).ToNot(ContainSubstring("Redirecting"))
+
+			close(outbound)
+		})
+
+		It("doesn't record items that haven't changed", func() {
+			itemURL, _ := url.Parse("https://www.gov.uk/foo")
+			item := NewCrawlerMessageItem(amqp.Delivery{Body: []byte(itemURL.String())}, rootURLs, nil)
+			item.Response = &CrawlerResponse{
+				ContentType: HTML,
+				URL:         itemURL,
+				NotModified: true,
+			}
+
+			outbound := make(chan *CrawlerMessageItem, 1)
+			persist := WriteWARC(writer, outbound)
+
+			outbound <- item
+			Expect(<-persist).To(Equal(item))
+
+			Expect(writer.Close()).To(BeNil())
+			Expect(ioutil.ReadDir(warcDir)).To(BeEmpty())
+
+			close(outbound)
+		})
+	})
+
+	Describe("SeedFromSitemaps", func() {
+		var fetcher sitemapFetcher
+		var rootURLs []*url.URL
+
+		BeforeEach(func() {
+			rootURL, _ := url.Parse("https://www.gov.uk/")
+			rootURLs = []*url.URL{rootURL}
+
+			recently := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+			fetcher = sitemapFetcher{
+				"https://www.gov.uk/robots.txt": "Sitemap: https://www.gov.uk/sitemap.xml",
+				"https://www.gov.uk/sitemap.xml": `<urlset>
+					<url><loc>https://www.gov.uk/recent#part-2</loc><lastmod>` + recently + `</lastmod></url>
+					<url><loc>https://www.gov.uk/search?q=tax</loc></url>
+					<url><loc>https://www.example.com/elsewhere</loc></url>
+				</urlset>`,
+				"https://www.gov.uk/orphans.xml": `<urlset><url><loc>/orphan</loc></url></urlset>`,
+			}
+		})
+
+		readLinks := func(links <-chan *Link) []*Link {
+			var read []*Link
+			for link := range links {
+				read = append(read, link)
+			}
+
+			return read
+		}
+
+		It("publishes the pages on our hosts listed by sitemaps in robots.txt", func() {
+			links := readLinks(SeedFromSitemaps(fetcher, rootURLs, nil, true, url_rules.ExcludePrefixes([]string{"/search"}), false))
+
+			Expect(links).To(HaveLen(1))
+			Expect(links[0].URL.String()).To(Equal("https://www.gov.uk/recent"))
+			Expect(links[0].Referrer).To(Equal("https://www.gov.uk/sitemap.xml"))
+			Expect(links[0].Depth).To(Equal(0))
+			Expect(links[0].Priority).To(Equal(0))
+		})
+
+		It("publishes the pages listed by configured sitemaps", func() {
+			orphans, _ := url.Parse("https://www.gov.uk/orphans.xml")
+			links := readLinks(SeedFromSitemaps(fetcher, rootURLs, []*url.URL{orphans}, false, nil, false))
+
+			Expect(links).To(HaveLen(1))
+			Expect(links[0].URL.String()).To(Equal("https://www.gov.uk/orphan"))
+		})
+
+		It("prioritises pages by when they were last modified", func() {
+			links := readLinks(SeedFromSitemaps(fetcher, rootURLs, nil, true, nil, true))
+
+			Expect(links).To(HaveLen(2))
+			Expect(links[0].Priority).To(Equal(3))
+			Expect(links[1].Priority).To(Equal(0))
+		})
+	})
+
+	Describe("MergeLinks", func() {
+		It("passes on the links from every channel until they're all closed", func() {
+			first := make(chan *Link, 1)
+			second := make(chan *Link, 1)
+			merged := MergeLinks(first, second)
+
+			u, _ := url.Parse("https://www.gov.uk/")
+			first <- &Link{URL: u}
+			second <- &Link{URL: u}
+			close(first)
+
+			Eventually(merged).Should(Receive())
+			Eventually(merged).Should(Receive())
+
+			close(second)
+			Eventually(merged).Should(BeClosed())
+		})
+	})
 })
 
+// sitemapFetcher fetches sitemaps from a map of URLs to bodies.
+type sitemapFetcher map[string]string
+
+func (f sitemapFetcher) Fetch(u *url.URL) ([]byte, error) {
+	body, ok := f[u.String()]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return []byte(body), nil
+}
+
 func testServer(status int, body string) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(status)